@@ -0,0 +1,113 @@
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/trusch/btrfaas/deployment"
+	"github.com/trusch/btrfaas/fgateway/authz"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Secret is the id of the secret containing the API keys of the gateway
+const Secret = "fgateway-tokens"
+
+// Manager manages the API keys of a deployment.
+// The token list is kept in ~/.btrfaas/<env>/tokens.yaml and deployed as secret, only the hashes of the keys are stored.
+type Manager struct {
+	platform deployment.SecretPlatform
+	env      string
+	path     string
+}
+
+// NewManager returns a new manager instance
+func NewManager(platform deployment.SecretPlatform, env string) (*Manager, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(home, ".btrfaas", env)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Manager{platform: platform, env: env, path: filepath.Join(dir, "tokens.yaml")}, nil
+}
+
+// Create creates a token which may call the given functions (ids or glob patterns) and returns its key
+func (manager *Manager) Create(ctx context.Context, name string, functions []string) (string, error) {
+	list, err := manager.load(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, token := range list.Tokens {
+		if token.Name == name {
+			return "", fmt.Errorf("token %v already exists", name)
+		}
+	}
+	token, key, err := authz.NewToken(name, functions)
+	if err != nil {
+		return "", err
+	}
+	list.Tokens = append(list.Tokens, token)
+	if err = manager.save(ctx, list); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// List returns all tokens
+func (manager *Manager) List(ctx context.Context) ([]*authz.Token, error) {
+	list, err := manager.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return list.Tokens, nil
+}
+
+// Revoke removes a token, the gateway rejects its key after reloading the secret
+func (manager *Manager) Revoke(ctx context.Context, name string) error {
+	list, err := manager.load(ctx)
+	if err != nil {
+		return err
+	}
+	for idx, token := range list.Tokens {
+		if token.Name == name {
+			list.Tokens = append(list.Tokens[:idx], list.Tokens[idx+1:]...)
+			return manager.save(ctx, list)
+		}
+	}
+	return fmt.Errorf("no such token: %v", name)
+}
+
+// load reads the local token list, or the deployed secret if there is no local copy.
+// A deployed secret which can't be read is an error, saving an empty list would revoke every token.
+func (manager *Manager) load(ctx context.Context) (*authz.TokenList, error) {
+	bs, err := ioutil.ReadFile(manager.path)
+	if os.IsNotExist(err) {
+		// swarm doesn't return the value of secrets, this only works on docker and kubernetes
+		if bs, err = deployment.ReadSecret(ctx, manager.platform, manager.env, Secret); err != nil {
+			return nil, fmt.Errorf("no local copy in %v and %v, restore the file to change the tokens", manager.path, err)
+		}
+		if bs == nil {
+			return &authz.TokenList{}, nil
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return authz.ParseTokens(bs)
+}
+
+func (manager *Manager) save(ctx context.Context, list *authz.TokenList) error {
+	bs, err := yaml.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err = deployment.ReplaceSecret(ctx, manager.platform, manager.env, Secret, bs); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(manager.path, bs, 0600)
+}
@@ -0,0 +1,193 @@
+package apikey_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	homedir "github.com/mitchellh/go-homedir"
+	. "github.com/trusch/btrfaas/apikey"
+	"github.com/trusch/btrfaas/deployment"
+	"github.com/trusch/btrfaas/fgateway/authz"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Manager", func() {
+	var (
+		ctx      = context.Background()
+		home     string
+		oldHome  string
+		platform *secretPlatform
+		manager  *Manager
+	)
+
+	BeforeEach(func() {
+		var err error
+		home, err = ioutil.TempDir("", "apikey")
+		Expect(err).NotTo(HaveOccurred())
+		oldHome = os.Getenv("HOME")
+		os.Setenv("HOME", home)
+		homedir.DisableCache = true
+		platform = &secretPlatform{secrets: make(map[string][]byte), rolled: make(map[string]int)}
+		manager, err = NewManager(platform, "test")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.Setenv("HOME", oldHome)
+		os.RemoveAll(home)
+	})
+
+	deployedTokens := func() *authz.TokenList {
+		list, err := authz.ParseTokens(platform.get(Secret))
+		Expect(err).NotTo(HaveOccurred())
+		return list
+	}
+
+	It("should create tokens and deploy their hashes", func() {
+		key, err := manager.Create(ctx, "webhook", []string{"echo"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(platform.get(Secret))).NotTo(ContainSubstring(key))
+		Expect(deployedTokens().Tokens).To(HaveLen(1))
+
+		_, err = manager.Create(ctx, "webhook", []string{"echo"})
+		Expect(err).To(HaveOccurred())
+		tokens, err := manager.List(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tokens).To(HaveLen(1))
+		Expect(tokens[0].Name).To(Equal("webhook"))
+		Expect(tokens[0].Functions).To(Equal([]string{"echo"}))
+	})
+
+	It("should revoke tokens", func() {
+		_, err := manager.Create(ctx, "webhook", []string{"echo"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = manager.Create(ctx, "browser", []string{"*"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manager.Revoke(ctx, "webhook")).To(Succeed())
+		list := deployedTokens()
+		Expect(list.Tokens).To(HaveLen(1))
+		Expect(list.Tokens[0].Name).To(Equal("browser"))
+		Expect(platform.rolled[Secret]).To(BeZero(), "services reload the tokens in place")
+		Expect(manager.Revoke(ctx, "webhook")).NotTo(Succeed())
+	})
+
+	It("should roll the services on platforms with immutable secrets", func() {
+		platform.immutable = true
+		_, err := manager.Create(ctx, "webhook", []string{"echo"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = manager.Create(ctx, "browser", []string{"*"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manager.Revoke(ctx, "webhook")).To(Succeed())
+		Expect(platform.rolled[Secret]).To(Equal(2), "services must not keep accepting a revoked key")
+	})
+
+	It("should fall back to the deployed tokens without a local copy", func() {
+		_, err := manager.Create(ctx, "webhook", []string{"echo"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.RemoveAll(home)).To(Succeed())
+		manager, err = NewManager(platform, "test")
+		Expect(err).NotTo(HaveOccurred())
+		tokens, err := manager.List(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tokens).To(HaveLen(1))
+	})
+
+	It("should not overwrite deployed tokens which can't be read", func() {
+		_, err := manager.Create(ctx, "webhook", []string{"echo"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.RemoveAll(home)).To(Succeed())
+		platform.hideValues = true
+		manager, err = NewManager(platform, "test")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = manager.List(ctx)
+		Expect(err).To(HaveOccurred())
+		_, err = manager.Create(ctx, "browser", []string{"*"})
+		Expect(err).To(HaveOccurred())
+		Expect(manager.Revoke(ctx, "webhook")).NotTo(Succeed())
+		Expect(deployedTokens().Tokens).To(HaveLen(1))
+	})
+
+	It("should start with an empty list if nothing is deployed", func() {
+		platform.hideValues = true
+		tokens, err := manager.List(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tokens).To(BeEmpty())
+		_, err = manager.Create(ctx, "webhook", []string{"echo"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+// secretPlatform keeps secrets in memory, with hideValues it returns empty values and with immutable
+// it needs rolls to update secrets like swarm
+type secretPlatform struct {
+	mutex      sync.Mutex
+	secrets    map[string][]byte
+	hideValues bool
+	immutable  bool
+	rolled     map[string]int
+}
+
+func (p *secretPlatform) SecretsImmutable() bool {
+	return p.immutable
+}
+
+func (p *secretPlatform) get(id string) []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.secrets[id]
+}
+
+func (p *secretPlatform) DeploySecret(ctx context.Context, options *deployment.DeploySecretOptions) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.secrets[options.ID] = options.Value
+	return nil
+}
+
+func (p *secretPlatform) UndeploySecret(ctx context.Context, options *deployment.UndeploySecretOptions) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.secrets, options.ID)
+	return nil
+}
+
+func (p *secretPlatform) UpdateSecret(ctx context.Context, options *deployment.UpdateSecretOptions) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.secrets[options.ID]; !ok {
+		return errors.New("no such secret")
+	}
+	p.secrets[options.ID] = options.Value
+	if options.Roll {
+		p.rolled[options.ID]++
+	}
+	return nil
+}
+
+func (p *secretPlatform) GetSecret(ctx context.Context, options *deployment.GetSecretOptions) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	value, ok := p.secrets[options.ID]
+	if !ok {
+		return nil, errors.New("no such secret")
+	}
+	if p.hideValues {
+		return nil, nil
+	}
+	return value, nil
+}
+
+func (p *secretPlatform) ListSecrets(ctx context.Context, options *deployment.ListSecretsOptions) ([]*deployment.SecretInfo, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var res []*deployment.SecretInfo
+	for id := range p.secrets {
+		res = append(res, &deployment.SecretInfo{ID: id})
+	}
+	return res, nil
+}
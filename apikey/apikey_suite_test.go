@@ -0,0 +1,13 @@
+package apikey_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestApikey(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Apikey Suite")
+}
@@ -23,6 +23,7 @@ package cmd
 import (
 	"context"
 	"os"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -55,6 +56,7 @@ var functionDeployCmd = &cobra.Command{
 				log.Fatal(err)
 			}
 			opts.EnvironmentID = env
+			opts.Timeout, _ = cmd.Flags().GetDuration("timeout")
 			ctx := context.Background()
 			err = cli.DeployFunction(ctx, &opts)
 			if err != nil {
@@ -67,6 +69,7 @@ var functionDeployCmd = &cobra.Command{
 
 func init() {
 	functionCmd.AddCommand(functionDeployCmd)
+	functionDeployCmd.Flags().Duration("timeout", 2*time.Minute, "how long to wait until the function passes its health check on kubernetes and swarm, 0 to not wait")
 
 	// Here you will define your flags and configuration settings.
 
@@ -23,6 +23,7 @@ package cmd
 import (
 	"context"
 	"os"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -57,6 +58,7 @@ var functionUpdateCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 		opts.EnvironmentID = env
+		opts.Timeout, _ = cmd.Flags().GetDuration("timeout")
 		ctx := context.Background()
 		err = cli.UndeployFunction(ctx, &faas.UndeployFunctionOptions{
 			UndeployServiceOptions: deployment.UndeployServiceOptions{
@@ -77,6 +79,7 @@ var functionUpdateCmd = &cobra.Command{
 
 func init() {
 	functionCmd.AddCommand(functionUpdateCmd)
+	functionUpdateCmd.Flags().Duration("timeout", 2*time.Minute, "how long to wait until the function passes its health check on kubernetes and swarm, 0 to not wait")
 
 	// Here you will define your flags and configuration settings.
 
@@ -22,6 +22,7 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"strings"
@@ -29,14 +30,28 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/trusch/btrfaas/expression"
 	"github.com/trusch/btrfaas/faas"
+	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
 )
 
 // invokeCmd represents the invoke command
 var invokeCmd = &cobra.Command{
 	Use:   "invoke <function expression>",
 	Short: "invoke a function",
-	Long:  `invoke a function`,
+	Long: `invoke a function or a chain of functions.
+A single argument is parsed as expression with shell-style quoting: "sed -e 's/a b/c d/' | to-upper".
+Multiple arguments are taken as they are, so that the shell quotes them, a "|" argument separates the functions:
+sed -e 's/a b/c d/' "|" to-upper
+
+Functions in parentheses run in parallel on a copy of the input:
+"cat | (to-upper & to-lower) | wc" concatenates the outputs in order, "(a && b)" interleaves them line by line.
+"tee (audit)" runs audit on a copy of the input, discards its output and passes the input on unchanged.
+If a branch fails the whole call fails.
+
+With --async the input is uploaded, the call runs in the background and the job id is printed,
+see 'btrfaasctl job' for its state and output. --callback is notified with the job and its output
+when the job succeeded or failed, it is a URL which gets a POST request or a function expression.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 1 {
 			cmd.Help()
@@ -50,24 +65,73 @@ var invokeCmd = &cobra.Command{
 			defer cancel()
 			ctx = c
 		}
-		expr := strings.Join(args, " ")
+		expr := joinExpression(args)
+		if _, err := expression.Parse(expr); err != nil {
+			if syntaxErr, ok := err.(*expression.SyntaxError); ok {
+				fmt.Fprintf(os.Stderr, "%v\n%v^\n", expr, strings.Repeat(" ", syntaxErr.Column-1))
+			}
+			log.Fatal(err)
+		}
 		env := viper.GetString("env")
-		if err := cli.Invoke(ctx, &faas.InvokeOptions{
+		options := &faas.InvokeOptions{
 			EnvironmentID:      env,
 			GatewayAddress:     getGateway(cmd),
 			FunctionExpression: expr,
+			User:               viper.GetString("user"),
+			Callback:           viper.GetString("callback"),
 			Input:              os.Stdin,
 			Output:             os.Stdout,
-		}); err != nil {
+		}
+		if async, _ := cmd.Flags().GetBool("async"); async {
+			job, err := cli.InvokeAsync(ctx, options)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(job.ID)
+			return
+		}
+		if err := cli.Invoke(ctx, options); err != nil {
+			if statusErr, ok := err.(*btrfaasgrpc.StatusError); ok && statusErr.Status.FunctionId != "" {
+				printStatus(statusErr.Status)
+				os.Exit(1)
+			}
 			log.Fatal(err)
 		}
 	},
 }
 
+// joinExpression builds the function expression of the arguments, multiple arguments are quoted one by one
+func joinExpression(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	words := make([]string, len(args))
+	for i, arg := range args {
+		if isOperator(arg) {
+			words[i] = arg
+		} else {
+			words[i] = expression.Quote(arg)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// isOperator returns true for arguments which are passed unquoted since they connect functions
+func isOperator(arg string) bool {
+	switch arg {
+	case "|", "&", "&&", "(", ")":
+		return true
+	}
+	return false
+}
+
 func init() {
 	functionCmd.AddCommand(invokeCmd)
 	invokeCmd.Flags().Duration("timeout", 0*time.Second, "specify a timeout for the call")
+	invokeCmd.Flags().Bool("async", false, "run the call in the background and print its job id")
+	invokeCmd.Flags().String("callback", "", "URL or function expression which is notified when an --async call is done")
 	invokeCmd.Flags().String("gateway", "", "gateway address")
+	invokeCmd.Flags().String("user", "", "present the client certificate of this user (see 'btrfaasctl user add'), the shared one if empty")
 	viper.BindPFlags(invokeCmd.Flags())
 }
 
@@ -87,3 +151,15 @@ func getGateway(cmd *cobra.Command) string {
 	}
 	return gw
 }
+
+// printStatus prints which stage of a chain failed and why
+func printStatus(st *btrfaasgrpc.Status) {
+	// the same format as StatusError, chain positions start at 0 like the stages of an expression
+	fmt.Fprintf(os.Stderr, "function %v (chain position %v) failed: %v\n", st.FunctionId, st.ChainPosition, st.Message)
+	if st.ExitCode != 0 {
+		fmt.Fprintf(os.Stderr, "exit code: %v\n", st.ExitCode)
+	}
+	if len(st.Stderr) > 0 {
+		fmt.Fprintf(os.Stderr, "stderr:\n%s\n", st.Stderr)
+	}
+}
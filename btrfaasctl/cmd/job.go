@@ -0,0 +1,67 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/trusch/btrfaas/faas"
+)
+
+// jobCmd represents the job command
+var jobCmd = &cobra.Command{
+	Use:   "job <command> ...",
+	Short: "asynchronous call related commands",
+	Long:  `asynchronous call related commands, jobs are created with 'btrfaasctl function invoke --async'`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// bound here since invoke binds flags with the same names
+		viper.BindPFlags(cmd.Flags())
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(jobCmd)
+	jobCmd.PersistentFlags().String("gateway", "", "gateway address")
+	jobCmd.PersistentFlags().String("user", "", "present the client certificate of this user, only its jobs are visible")
+}
+
+// getJobOptions returns the options of the job calls
+func getJobOptions(cmd *cobra.Command, id string) *faas.JobOptions {
+	return &faas.JobOptions{
+		EnvironmentID:  viper.GetString("env"),
+		GatewayAddress: getGateway(cmd),
+		User:           viper.GetString("user"),
+		ID:             id,
+	}
+}
+
+// printJob prints a job as JSON
+func printJob(job *faas.JobInfo) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(job.Job); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// jobGetCmd represents the job get command
+var jobGetCmd = &cobra.Command{
+	Use:   "get <id>",
+	Short: "print a job",
+	Long: `print the state of a job as JSON, with --result the output of the job is written to stdout instead.
+The output is available once the job is done, the output of failed and canceled jobs may be incomplete.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		cli := getFaaS(cmd)
+		options := getJobOptions(cmd, args[0])
+		if result, _ := cmd.Flags().GetBool("result"); result {
+			options.Output = os.Stdout
+			if err := cli.GetJobResult(context.Background(), options); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		job, err := cli.GetJob(context.Background(), options)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printJob(job)
+	},
+}
+
+func init() {
+	jobCmd.AddCommand(jobGetCmd)
+	jobGetCmd.Flags().Bool("result", false, "write the output of the job to stdout")
+}
@@ -0,0 +1,87 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// jobListCmd represents the job list command
+var jobListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "list jobs",
+	Long:    `list the jobs of the user, oldest first. With --dead-letters the failed jobs which can be retried are listed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if deadLetters, _ := cmd.Flags().GetBool("dead-letters"); deadLetters {
+			listDeadLetters(cmd)
+			return
+		}
+		jobs, err := getFaaS(cmd).ListJobs(context.Background(), getJobOptions(cmd, ""))
+		if err != nil {
+			log.Fatal(err)
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"id", "state", "expression", "created", "output", "error"})
+		for _, job := range jobs {
+			table.Append([]string{
+				job.ID,
+				string(job.State),
+				job.Expression,
+				job.Created.Format(time.RFC3339),
+				strconv.FormatInt(job.OutputSize, 10),
+				job.Error,
+			})
+		}
+		table.Render()
+	},
+}
+
+func listDeadLetters(cmd *cobra.Command) {
+	letters, err := getFaaS(cmd).ListDeadLetters(context.Background(), getJobOptions(cmd, ""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"id", "expression", "attempts", "failed", "error"})
+	for _, letter := range letters {
+		table.Append([]string{
+			letter.ID,
+			letter.Expression,
+			strconv.Itoa(letter.Attempts),
+			letter.Failed.Format(time.RFC3339),
+			letter.Error,
+		})
+	}
+	table.Render()
+}
+
+func init() {
+	jobCmd.AddCommand(jobListCmd)
+	jobListCmd.Flags().Bool("dead-letters", false, "list the failed jobs which can be retried")
+}
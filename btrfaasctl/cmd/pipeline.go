@@ -0,0 +1,47 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/trusch/btrfaas/pipeline"
+)
+
+// pipelineCmd represents the pipeline command
+var pipelineCmd = &cobra.Command{
+	Use:   "pipeline <command> ...",
+	Short: "pipeline related commands",
+	Long:  `pipeline related commands, pipelines are named function expressions which can be invoked like functions`,
+}
+
+func init() {
+	RootCmd.AddCommand(pipelineCmd)
+}
+
+func getPipelineManager(cmd *cobra.Command) *pipeline.Manager {
+	manager, err := pipeline.NewManager(getDeploymentPlatform(cmd), viper.GetString("env"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return manager
+}
@@ -0,0 +1,69 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/trusch/btrfaas/btrfaasctl/inputfile"
+	"github.com/trusch/btrfaas/pipeline"
+)
+
+// pipelineDeployCmd represents the pipeline deploy command
+var pipelineDeployCmd = &cobra.Command{
+	Use:   "deploy <pipeline spec>...",
+	Short: "deploy a pipeline",
+	Long: `deploy a pipeline from a spec file or URL, a deployed pipeline with the same id is replaced:
+
+id: ingest-logs
+description: normalize and archive log lines
+expression: "sed -e 's/ERROR/error/' | tee (archive) | to-upper"
+
+The gateway picks up the pipeline after reloading its pipelines, afterwards it can be invoked like a function.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		manager := getPipelineManager(cmd)
+		for _, arg := range args {
+			bs, err := inputfile.Resolve(arg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			spec, err := pipeline.ParseSpec(bs)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err = manager.Deploy(context.Background(), spec); err != nil {
+				log.Fatal(err)
+			}
+			log.Info("successfully deployed pipeline ", spec.ID)
+		}
+	},
+}
+
+func init() {
+	pipelineCmd.AddCommand(pipelineDeployCmd)
+}
@@ -0,0 +1,53 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// pkiRevokeCmd represents the pkiRevoke command
+var pkiRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "revoke a certificate",
+	Long: `add the current certificate of id to the revocation list, fgateway and the functions reject it from then on.
+A new certificate is issued unless --no-renew is given, which is refused for the certificates of the gateway (client and fgateway).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Help()
+			return
+		}
+		ctx := context.Background()
+		noRenew, _ := cmd.Flags().GetBool("no-renew")
+		if err := getPKIManager(ctx, cmd).Revoke(ctx, args[0], !noRenew); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("successfully revoked the certificate of %v", args[0])
+	},
+}
+
+func init() {
+	pkiCmd.AddCommand(pkiRevokeCmd)
+	pkiRevokeCmd.Flags().Bool("no-renew", false, "don't issue a new certificate, not allowed for client and fgateway")
+}
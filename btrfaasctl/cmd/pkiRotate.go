@@ -0,0 +1,69 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// pkiRotateCmd represents the pkiRotate command
+var pkiRotateCmd = &cobra.Command{
+	Use:   "rotate [id...]",
+	Short: "issue new certificates",
+	Long: `issue new certificates for the given ids or for all certificates of the environment.
+With --ca a new CA is created and everything is reissued, the old CA stays trusted until its certificates are replaced.
+Services pick up the new certificates without restarts.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		manager := getPKIManager(ctx, cmd)
+		validity, _ := cmd.Flags().GetDuration("validity")
+		caValidity, _ := cmd.Flags().GetDuration("ca-validity")
+		if validity > 0 || caValidity > 0 {
+			if err := manager.SetValidity(validity, caValidity); err != nil {
+				log.Fatal(err)
+			}
+		}
+		rotateCA, _ := cmd.Flags().GetBool("ca")
+		var err error
+		if rotateCA {
+			if len(args) > 0 {
+				log.Fatal("--ca rotates all certificates, no ids are allowed")
+			}
+			err = manager.RotateCA(ctx)
+		} else {
+			err = manager.Rotate(ctx, args...)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Info("successfully rotated certificates")
+	},
+}
+
+func init() {
+	pkiCmd.AddCommand(pkiRotateCmd)
+	pkiRotateCmd.Flags().Bool("ca", false, "create a new CA and reissue all certificates")
+	pkiRotateCmd.Flags().Duration("validity", 0, "validity of issued certificates, it is saved for the environment (default 8760h)")
+	pkiRotateCmd.Flags().Duration("ca-validity", 0, "validity of new CAs, it is saved for the environment (default 87600h)")
+}
@@ -0,0 +1,73 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/trusch/btrfaas/pki"
+)
+
+// pkiStatusCmd represents the pkiStatus command
+var pkiStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show the issued certificates",
+	Long:  `show the CAs and the issued certificates with their expiry`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		certs, err := getPKIManager(ctx, cmd).Certificates(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printCertificateTable(certs)
+	},
+}
+
+func init() {
+	pkiCmd.AddCommand(pkiStatusCmd)
+}
+
+func printCertificateTable(certs []*pki.CertificateInfo) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"id", "type", "groups", "serial", "expires", "status"})
+	now := time.Now()
+	for _, cert := range certs {
+		if !cert.Known {
+			table.Append([]string{cert.ID, cert.Type, "-", "-", "-", "unknown"})
+			continue
+		}
+		status := "valid"
+		switch {
+		case cert.Revoked:
+			status = "revoked"
+		case cert.NotAfter.Before(now):
+			status = "expired"
+		}
+		table.Append([]string{cert.ID, cert.Type, strings.Join(cert.Groups, ","), cert.Serial, cert.NotAfter.Format(time.RFC3339), status})
+	}
+	table.Render()
+}
@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
@@ -33,6 +34,7 @@ import (
 	"github.com/trusch/btrfaas/deployment/docker"
 	"github.com/trusch/btrfaas/deployment/k8s"
 	"github.com/trusch/btrfaas/deployment/swarm"
+	"github.com/trusch/btrfaas/deployment/vault"
 	"github.com/trusch/btrfaas/faas"
 	"github.com/trusch/btrfaas/faas/btrfaas"
 	"github.com/trusch/btrfaas/faas/openfaas"
@@ -68,6 +70,8 @@ func init() {
 	RootCmd.PersistentFlags().StringP("env", "e", "btrfaas-default", "environment to use")
 	RootCmd.PersistentFlags().String("platform", "docker", "deployment platform (docker, swarm, k8s)")
 	RootCmd.PersistentFlags().String("faas-provider", "btrfaas", "faas provider (btrfaas, openfaas)")
+	RootCmd.PersistentFlags().String("secret-backend", "file", "secret backend of the docker platform (file, vault)")
+	RootCmd.PersistentFlags().String("vault-dir", "", "directory of the vault secret backend (default is $HOME/.btrfaas/vault)")
 	viper.BindPFlags(RootCmd.PersistentFlags())
 }
 
@@ -106,7 +110,7 @@ func getDeploymentPlatform(cmd *cobra.Command) deployment.Platform {
 	)
 	switch platformID {
 	case "docker":
-		platform, err = docker.NewPlatform()
+		platform, err = getDockerPlatform()
 	case "swarm":
 		platform, err = swarm.NewPlatform()
 	case "k8s":
@@ -114,12 +118,45 @@ func getDeploymentPlatform(cmd *cobra.Command) deployment.Platform {
 	default:
 		err = errors.New("deployment platform unsupported")
 	}
+	if err == nil && platformID != "docker" && viper.GetString("secret-backend") != "file" {
+		err = errors.New("secret backends are only supported by the docker platform")
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 	return platform
 }
 
+// getDockerPlatform returns the docker platform with the configured secret backend
+func getDockerPlatform() (deployment.Platform, error) {
+	switch viper.GetString("secret-backend") {
+	case "file":
+		return docker.NewPlatform()
+	case "vault":
+		key, err := vault.KeyFromEnvironment()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, fmt.Errorf("the vault secret backend needs %v or %v", vault.PassphraseEnv, vault.KeyFileEnv)
+		}
+		dir := viper.GetString("vault-dir")
+		if dir == "" {
+			home, err := homedir.Dir()
+			if err != nil {
+				return nil, err
+			}
+			dir = filepath.Join(home, ".btrfaas", "vault")
+		}
+		if _, err := vault.SealPlaintext(dir, key); err != nil {
+			return nil, fmt.Errorf("could not seal plaintext secrets: %v", err)
+		}
+		return docker.NewPlatformWithSecrets(vault.NewPlatform(dir, key))
+	default:
+		return nil, errors.New("secret backend unsupported")
+	}
+}
+
 func getFaaS(cmd *cobra.Command) faas.FaaS {
 	faasID := viper.GetString("faas-provider")
 	var (
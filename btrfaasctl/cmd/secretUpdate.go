@@ -0,0 +1,65 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/trusch/btrfaas/deployment"
+	"github.com/trusch/btrfaas/faas"
+)
+
+// secretUpdateCmd represents the secretUpdate command
+var secretUpdateCmd = &cobra.Command{
+	Use:   "update <secret id> <secret content>",
+	Short: "update a secret",
+	Long: `update a deployed secret with a new version.
+On swarm the functions keep using the old version until they are rolled, use --roll to do it right away.
+On docker and k8s they see the new value in place, --roll restarts them anyway.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		secretID, secretValue := getIDAndValue(cmd, args)
+		roll, _ := cmd.Flags().GetBool("roll")
+		env := viper.GetString("env")
+		cli := getFaaS(cmd)
+		ctx := context.Background()
+		err := cli.UpdateSecret(ctx, &faas.UpdateSecretOptions{
+			UpdateSecretOptions: deployment.UpdateSecretOptions{
+				EnvironmentID: env,
+				ID:            secretID,
+				Value:         secretValue,
+				Roll:          roll,
+			},
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Info("successfully updated secret ", secretID)
+	},
+}
+
+func init() {
+	secretCmd.AddCommand(secretUpdateCmd)
+	secretUpdateCmd.Flags().StringP("file", "f", "", "read the secret content from a file or URL")
+	secretUpdateCmd.Flags().Bool("roll", false, "restart the functions which use the secret")
+}
@@ -0,0 +1,54 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// tokenCreateCmd represents the tokenCreate command
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "create an API key",
+	Long: `create an API key which may call the functions given by --function (ids or glob patterns).
+The key is only printed once, send it as "Authorization: Bearer <key>".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Help()
+			return
+		}
+		functions, _ := cmd.Flags().GetStringSlice("function")
+		key, err := getTokenManager(cmd).Create(context.Background(), args[0], functions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(key)
+	},
+}
+
+func init() {
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCreateCmd.Flags().StringSlice("function", nil, "function id or glob pattern the key may call, can be repeated")
+}
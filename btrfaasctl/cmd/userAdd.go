@@ -0,0 +1,59 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/trusch/btrfaas/pki"
+)
+
+// userAddCmd represents the userAdd command
+var userAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "issue a client certificate for a user",
+	Long: `issue a client certificate for a user, the name and the groups can be used in the authorization policy of the gateway.
+The certificate and the key are saved in ~/.btrfaas/<env>/users.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Help()
+			return
+		}
+		ctx := context.Background()
+		groups, _ := cmd.Flags().GetStringSlice("group")
+		if err := getPKIManager(ctx, cmd).IssueUser(ctx, args[0], groups); err != nil {
+			log.Fatal(err)
+		}
+		cert, key, err := pki.ClientFiles(viper.GetString("env"), args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("successfully issued a certificate for %v: %v, %v", args[0], cert, key)
+	},
+}
+
+func init() {
+	userCmd.AddCommand(userAddCmd)
+	userAddCmd.Flags().StringSlice("group", nil, "group of the user, can be repeated")
+}
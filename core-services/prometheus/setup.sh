@@ -2,7 +2,7 @@
 
 BTRFAASCTL=${BTRFAASCTL:-"btrfaasctl"}
 
-# build prometheus with config for scraping http://fgateway:8080/metrics
+# build prometheus with config for scraping fgateway and all frunner instances
 pushd core-services/prometheus
 docker build -t btrfaas/prometheus .
 
@@ -13,6 +13,7 @@ import (
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/trusch/btrfaas/deployment"
+	"github.com/trusch/btrfaas/deployment/vault"
 	"github.com/trusch/btrfaas/frunner/env"
 
 	"github.com/docker/docker/api/types"
@@ -25,31 +26,67 @@ import (
 
 // dockerPlatform implements deployment.Platform with the help of a docker
 type dockerPlatform struct {
-	cli *client.Client
+	cli     *client.Client
+	secrets deployment.SecretPlatform
+	// mountRoot contains the secret files which are bind mounted, <mountRoot>/<env>/secrets/<id>
+	mountRoot string
+	// materialize is true if the secrets are copied to mountRoot before they are mounted,
+	// false if the backend keeps them in plaintext in mountRoot
+	materialize bool
 }
 
-// NewPlatform creates a new Platform instance for local docker development
+// NewPlatform creates a new Platform instance for local docker development.
+// The secrets are kept in ~/.btrfaas/<env>/secrets, they are encrypted if BTRFAAS_SECRET_PASSPHRASE or
+// BTRFAAS_SECRET_KEY_FILE is set.
 func NewPlatform() (deployment.Platform, error) {
+	key, err := vault.KeyFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Join(home, ".btrfaas")
+	if key != nil {
+		count, err := vault.SealPlaintext(root, key)
+		if err != nil {
+			return nil, fmt.Errorf("could not seal plaintext secrets: %v", err)
+		}
+		if count > 0 {
+			log.Printf("sealed %v plaintext secrets, redeploy the services which were deployed without a key", count)
+		}
+		return NewPlatformWithSecrets(vault.NewPlatform(root, key))
+	}
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		return nil, err
+	}
+	return &dockerPlatform{cli, vault.NewPlatform(root, nil), root, false}, nil
+}
+
+// NewPlatformWithSecrets creates a new Platform instance which uses the given secret backend.
+// The secrets of a service are decrypted to $XDG_RUNTIME_DIR/btrfaas right before it is started.
+func NewPlatformWithSecrets(secrets deployment.SecretPlatform) (deployment.Platform, error) {
+	root, err := runtimeRoot()
+	if err != nil {
+		return nil, err
+	}
 	cli, err := client.NewEnvClient()
 	if err != nil {
 		return nil, err
 	}
-	return &dockerPlatform{cli}, nil
+	return &dockerPlatform{cli, secrets, root, true}, nil
 }
 
 // PrepareEnvironment prepares an environment to start deploying services
 // This should contain all one time setup like creating namespaces/networks etc.
 func (p *dockerPlatform) PrepareEnvironment(ctx context.Context, options *deployment.PrepareEnvironmentOptions) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		log.Fatal(err)
-	}
-	secretRoot := filepath.Join(home, ".btrfaas", options.ID, "secrets")
-	if err = os.MkdirAll(secretRoot, 0755); err != nil {
+	if err := os.MkdirAll(p.secretRoot(options.ID), 0700); err != nil {
 		return err
 	}
 	name := options.ID + "_network"
-	_, err = p.cli.NetworkInspect(ctx, name, false)
+	_, err := p.cli.NetworkInspect(ctx, name, false)
 	if err != nil {
 		_, err = p.cli.NetworkCreate(ctx, name, types.NetworkCreate{
 			Driver:     "bridge",
@@ -62,11 +99,10 @@ func (p *dockerPlatform) PrepareEnvironment(ctx context.Context, options *deploy
 
 // DeployService deploys a service in an environment
 func (p *dockerPlatform) DeployService(ctx context.Context, options *deployment.DeployServiceOptions) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		log.Fatal(err)
+	secretRoot := p.secretRoot(options.EnvironmentID)
+	if err := p.materializeSecrets(ctx, options.EnvironmentID, options.Secrets); err != nil {
+		return err
 	}
-	secretRoot := filepath.Join(home, ".btrfaas", options.EnvironmentID, "secrets")
 	netName := options.EnvironmentID + "_network"
 	if options.Labels == nil {
 		options.Labels = make(deployment.LabelSet)
@@ -148,52 +184,115 @@ func (p *dockerPlatform) ListServices(ctx context.Context, options *deployment.L
 
 // DeploySecret deploys a secret in an environment
 func (p *dockerPlatform) DeploySecret(ctx context.Context, options *deployment.DeploySecretOptions) error {
-	home, err := homedir.Dir()
+	if err := p.secrets.DeploySecret(ctx, options); err != nil {
+		return err
+	}
+	return p.updateMountedSecret(options.EnvironmentID, options.ID, options.Value)
+}
+
+// UpdateSecret rewrites the secret file in place, running containers see the new value immediately.
+// Rolling restarts the containers which mount the secret.
+func (p *dockerPlatform) UpdateSecret(ctx context.Context, options *deployment.UpdateSecretOptions) error {
+	if err := p.secrets.UpdateSecret(ctx, options); err != nil {
+		return err
+	}
+	if err := p.updateMountedSecret(options.EnvironmentID, options.ID, options.Value); err != nil {
+		return err
+	}
+	path := filepath.Join(p.secretRoot(options.EnvironmentID), options.ID)
+	if !options.Roll {
+		return nil
+	}
+	args := filters.NewArgs()
+	args.Add("label", "btrfaas_env="+options.EnvironmentID)
+	containers, err := p.cli.ContainerList(ctx, types.ContainerListOptions{Filters: args})
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	secretRoot := filepath.Join(home, ".btrfaas", options.EnvironmentID, "secrets")
-	return ioutil.WriteFile(filepath.Join(secretRoot, options.ID), options.Value, 0600)
+	d := 5 * time.Second
+	for _, c := range containers {
+		for _, m := range c.Mounts {
+			if m.Source != path {
+				continue
+			}
+			if err = p.cli.ContainerRestart(ctx, c.ID, &d); err != nil {
+				return fmt.Errorf("could not restart %v: %v", c.Names[0][1:], err)
+			}
+			break
+		}
+	}
+	return nil
 }
 
 // GetSecret returns the secret value
 func (p *dockerPlatform) GetSecret(ctx context.Context, options *deployment.GetSecretOptions) ([]byte, error) {
-	home, err := homedir.Dir()
-	if err != nil {
-		log.Fatal(err)
-	}
-	secretRoot := filepath.Join(home, ".btrfaas", options.EnvironmentID, "secrets")
-	return ioutil.ReadFile(filepath.Join(secretRoot, options.ID))
+	return p.secrets.GetSecret(ctx, options)
 }
 
 // UndeploySecret unddeploys a secret from an environment
 func (p *dockerPlatform) UndeploySecret(ctx context.Context, options *deployment.UndeploySecretOptions) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		log.Fatal(err)
+	if err := p.secrets.UndeploySecret(ctx, options); err != nil {
+		return err
+	}
+	if !p.materialize {
+		return nil
+	}
+	err := os.Remove(filepath.Join(p.secretRoot(options.EnvironmentID), options.ID))
+	if os.IsNotExist(err) {
+		return nil
 	}
-	secretRoot := filepath.Join(home, ".btrfaas", options.EnvironmentID, "secrets")
-	return os.Remove(filepath.Join(secretRoot, options.ID))
+	return err
 }
 
 // ListSecrets returns a list of all deployed secrets
 func (p *dockerPlatform) ListSecrets(ctx context.Context, options *deployment.ListSecretsOptions) ([]*deployment.SecretInfo, error) {
-	home, err := homedir.Dir()
-	if err != nil {
-		log.Fatal(err)
+	return p.secrets.ListSecrets(ctx, options)
+}
+
+// secretRoot returns the directory of the secret files which are mounted into the containers of env
+func (p *dockerPlatform) secretRoot(env string) string {
+	return filepath.Join(p.mountRoot, env, "secrets")
+}
+
+// updateMountedSecret updates a decrypted copy in place, so that running containers see the new value
+func (p *dockerPlatform) updateMountedSecret(env, id string, value []byte) error {
+	path := filepath.Join(p.secretRoot(env), id)
+	if _, err := os.Stat(path); err != nil || !p.materialize {
+		return nil
 	}
-	secretRoot := filepath.Join(home, ".btrfaas", options.EnvironmentID, "secrets")
-	resp, err := ioutil.ReadDir(secretRoot)
-	if err != nil {
-		return nil, err
+	return ioutil.WriteFile(path, value, 0600)
+}
+
+// materializeSecrets writes the plaintext of the secrets to the mounted directory
+func (p *dockerPlatform) materializeSecrets(ctx context.Context, env string, secrets deployment.LabelSet) error {
+	if !p.materialize || len(secrets) == 0 {
+		return nil
 	}
-	result := make([]*deployment.SecretInfo, len(resp))
-	for idx, val := range resp {
-		result[idx] = &deployment.SecretInfo{
-			ID: val.Name(),
+	if err := os.MkdirAll(p.secretRoot(env), 0700); err != nil {
+		return err
+	}
+	for id := range secrets {
+		value, err := p.secrets.GetSecret(ctx, &deployment.GetSecretOptions{
+			EnvironmentID: env,
+			ID:            id,
+		})
+		if err != nil {
+			return fmt.Errorf("could not read secret %v: %v", id, err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(p.secretRoot(env), id), value, 0600); err != nil {
+			return err
 		}
 	}
-	return result, nil
+	return nil
+}
+
+// runtimeRoot returns the directory for decrypted secrets, it must be on a tmpfs so that they never hit the disk
+func runtimeRoot() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return "", errors.New("XDG_RUNTIME_DIR is not set, encrypted secrets are only decrypted to a per-user tmpfs like /run/user/<uid>")
+	}
+	return filepath.Join(dir, "btrfaas"), nil
 }
 
 func constructSecretBinds(secrets deployment.LabelSet, secretRoot string) []string {
@@ -245,6 +344,11 @@ func (p *dockerPlatform) TeardownEnvironment(ctx context.Context, options *deplo
 			return err
 		}
 	}
+	if p.materialize {
+		if err = os.RemoveAll(filepath.Join(p.mountRoot, options.ID)); err != nil {
+			return err
+		}
+	}
 	return p.cli.NetworkRemove(ctx, options.ID+"_network")
 }
 
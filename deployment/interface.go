@@ -45,6 +45,9 @@ type SecretPlatform interface {
 	// UndeploySecret unddeploys a secret from an environment
 	UndeploySecret(ctx context.Context, options *UndeploySecretOptions) error
 
+	// UpdateSecret replaces the value of a deployed secret with a new version
+	UpdateSecret(ctx context.Context, options *UpdateSecretOptions) error
+
 	// GetSecret returns the secret value
 	GetSecret(ctx context.Context, options *GetSecretOptions) ([]byte, error)
 
@@ -52,6 +55,19 @@ type SecretPlatform interface {
 	ListSecrets(ctx context.Context, options *ListSecretsOptions) ([]*SecretInfo, error)
 }
 
+// ImmutableSecretPlatform is implemented by platforms whose services only see a new value of a secret after they are
+// rolled, like swarm. On the other platforms the mounted files are updated in place and the services reload them.
+type ImmutableSecretPlatform interface {
+	// SecretsImmutable returns true if updated secrets only reach the services which are rolled
+	SecretsImmutable() bool
+}
+
+// ServiceWaiter is implemented by platforms which run the health checks of services, like kubernetes and swarm
+type ServiceWaiter interface {
+	// WaitForService waits until every replica of a service passes its health check or ctx is done
+	WaitForService(ctx context.Context, options *WaitForServiceOptions) error
+}
+
 // PrepareEnvironmentOptions contains the options for the PrepareEnvironment call
 type PrepareEnvironmentOptions struct {
 	ID string
@@ -73,6 +89,17 @@ type DeployServiceOptions struct {
 	Env           LabelSet // Environment variables: key -> val mapping
 	Secrets       LabelSet // Secrets: secret-id -> target-path mapping
 	Volumes       []*VolumeConfig
+	HealthCheck   *HealthCheckConfig // nil means no probes
+	StopTimeout   time.Duration      // time between SIGTERM and SIGKILL, 0 means platform default
+}
+
+// HealthCheckConfig configures readiness and liveness probes of a service.
+// Command runs in the container and must exit with 0 if the service is ready to serve,
+// like "/bin/frunner --probe" which checks the grpc.health.v1 service of frunner.
+// Disabled turns off the probes which are configured by default, like the one of functions.
+type HealthCheckConfig struct {
+	Command  []string
+	Disabled bool
 }
 
 // VolumeConfig specifies a volume
@@ -91,6 +118,12 @@ type PortConfig struct {
 	Host      uint16
 }
 
+// WaitForServiceOptions contains the options for the WaitForService call
+type WaitForServiceOptions struct {
+	EnvironmentID string
+	ID            string
+}
+
 // UndeployServiceOptions contains the options for the UndeployService call
 type UndeployServiceOptions struct {
 	EnvironmentID string
@@ -130,6 +163,15 @@ type UndeploySecretOptions struct {
 	ID            string
 }
 
+// UpdateSecretOptions contains the options for the UpdateSecret call.
+// Services which can't see the new value in place, like swarm services, keep the old version until they are rolled.
+type UpdateSecretOptions struct {
+	EnvironmentID string
+	ID            string
+	Value         []byte
+	Roll          bool // restart the services which use the secret
+}
+
 // ListSecretsOptions contains the options for the ListSecrets call
 type ListSecretsOptions struct {
 	EnvironmentID string
@@ -144,8 +186,9 @@ type GetSecretOptions struct {
 
 // SecretInfo is the (inner) response type for ListSecrets calls
 type SecretInfo struct {
-	ID     string
-	Labels LabelSet
+	ID      string
+	Labels  LabelSet
+	Version uint64 `yaml:",omitempty"` // 0 if the platform doesn't version secrets
 }
 
 // LabelSet is a set of key-value pairs (string-string)
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"time"
 
 	appsv1beta1 "k8s.io/api/apps/v1beta1"
 	apiv1 "k8s.io/api/core/v1"
@@ -19,6 +21,13 @@ import (
 	"k8s.io/client-go/util/retry"
 )
 
+// secretVersionAnnotation holds the version of a secret,
+// deployments which got rolled because of an update carry it with the secret id appended in their pod template
+const secretVersionAnnotation = "btrfaas/secret-version"
+
+// waitInterval is how often WaitForService checks the state of a deployment
+const waitInterval = time.Second
+
 // k8sPlatform implements deployment.Platform with the help of a kubernetes
 type k8sPlatform struct {
 	cli *kubernetes.Clientset
@@ -67,15 +76,18 @@ func (p *k8sPlatform) DeployService(ctx context.Context, options *deployment.Dep
 				Spec: apiv1.PodSpec{
 					Containers: []apiv1.Container{
 						{
-							Name:         options.ID,
-							Image:        options.Image,
-							Ports:        constructContainerPorts(options.Ports),
-							VolumeMounts: constructVolumeMounts(options),
-							Env:          constructEnv(options),
-							Command:      options.Cmd,
+							Name:           options.ID,
+							Image:          options.Image,
+							Ports:          constructContainerPorts(options.Ports),
+							VolumeMounts:   constructVolumeMounts(options),
+							Env:            constructEnv(options),
+							Command:        options.Cmd,
+							ReadinessProbe: constructProbe(options.HealthCheck, 2, 1),
+							LivenessProbe:  constructProbe(options.HealthCheck, 10, 3),
 						},
 					},
-					Volumes: constructVolumes(options),
+					Volumes:                       constructVolumes(options),
+					TerminationGracePeriodSeconds: constructTerminationGracePeriod(options),
 				},
 			},
 		},
@@ -102,6 +114,31 @@ func (p *k8sPlatform) DeployService(ctx context.Context, options *deployment.Dep
 	return err
 }
 
+// WaitForService waits until every replica of the deployment is updated and passes its readiness probe
+func (p *k8sPlatform) WaitForService(ctx context.Context, options *deployment.WaitForServiceOptions) error {
+	deploymentsClient := p.cli.AppsV1beta1().Deployments(options.EnvironmentID)
+	for {
+		depl, err := deploymentsClient.Get(options.ID, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		replicas := int32(1)
+		if depl.Spec.Replicas != nil {
+			replicas = *depl.Spec.Replicas
+		}
+		status := depl.Status
+		if status.ObservedGeneration >= depl.Generation && status.UpdatedReplicas == replicas &&
+			status.ReadyReplicas == replicas && status.Replicas == replicas {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v is not ready, %v of %v replicas are ready: %v", options.ID, status.ReadyReplicas, replicas, ctx.Err())
+		case <-time.After(waitInterval):
+		}
+	}
+}
+
 // UndeployService unddeploys a service from an environment
 func (p *k8sPlatform) UndeployService(ctx context.Context, options *deployment.UndeployServiceOptions) error {
 	deploymentsClient := p.cli.AppsV1beta1().Deployments(options.EnvironmentID)
@@ -157,6 +194,55 @@ func (p *k8sPlatform) GetSecret(ctx context.Context, options *deployment.GetSecr
 	return secret.Data["value"], nil
 }
 
+// UpdateSecret patches the secret and increments its version, the kubelet updates the mounted files eventually.
+// Rolling annotates the pod templates of the deployments which mount the secret, so that their pods get replaced.
+func (p *k8sPlatform) UpdateSecret(ctx context.Context, options *deployment.UpdateSecretOptions) error {
+	secretClient := p.cli.CoreV1().Secrets(options.EnvironmentID)
+	var version uint64
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, getErr := secretClient.Get(options.ID, metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		version = secretVersion(secret) + 1
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string)
+		}
+		secret.Annotations[secretVersionAnnotation] = strconv.FormatUint(version, 10)
+		secret.Data = map[string][]byte{"value": options.Value}
+		_, updateErr := secretClient.Update(secret)
+		return updateErr
+	})
+	if err != nil || !options.Roll {
+		return err
+	}
+	deploymentsClient := p.cli.AppsV1beta1().Deployments(options.EnvironmentID)
+	list, err := deploymentsClient.List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, depl := range list.Items {
+		if !mountsSecret(&depl, options.ID) {
+			continue
+		}
+		if err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			result, getErr := deploymentsClient.Get(depl.Name, metav1.GetOptions{})
+			if getErr != nil {
+				return getErr
+			}
+			if result.Spec.Template.Annotations == nil {
+				result.Spec.Template.Annotations = make(map[string]string)
+			}
+			result.Spec.Template.Annotations[secretVersionAnnotation+"-"+options.ID] = strconv.FormatUint(version, 10)
+			_, updateErr := deploymentsClient.Update(result)
+			return updateErr
+		}); err != nil {
+			return fmt.Errorf("could not roll %v: %v", depl.Name, err)
+		}
+	}
+	return nil
+}
+
 // UndeploySecret unddeploys a secret from an environment
 func (p *k8sPlatform) UndeploySecret(ctx context.Context, options *deployment.UndeploySecretOptions) error {
 	secretClient := p.cli.CoreV1().Secrets(options.EnvironmentID)
@@ -175,8 +261,9 @@ func (p *k8sPlatform) ListSecrets(ctx context.Context, options *deployment.ListS
 	res := make([]*deployment.SecretInfo, len(list.Items))
 	for idx, secret := range list.Items {
 		res[idx] = &deployment.SecretInfo{
-			ID:     secret.Name,
-			Labels: secret.Labels,
+			ID:      secret.Name,
+			Labels:  secret.Labels,
+			Version: secretVersion(&secret),
 		}
 	}
 	return res, nil
@@ -203,6 +290,25 @@ func (p *k8sPlatform) TeardownEnvironment(ctx context.Context, options *deployme
 	return nsClient.Delete(options.ID, &metav1.DeleteOptions{})
 }
 
+// secretVersion returns the version of a secret, secrets which were never updated are version 1
+func secretVersion(secret *apiv1.Secret) uint64 {
+	version, err := strconv.ParseUint(secret.Annotations[secretVersionAnnotation], 10, 64)
+	if err != nil {
+		return 1
+	}
+	return version
+}
+
+// mountsSecret returns true if the pods of the deployment mount the secret
+func mountsSecret(depl *appsv1beta1.Deployment, id string) bool {
+	for _, volume := range depl.Spec.Template.Spec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == id {
+			return true
+		}
+	}
+	return false
+}
+
 func constructContainerPorts(ports []*deployment.PortConfig) []apiv1.ContainerPort {
 	res := make([]apiv1.ContainerPort, 0)
 	for _, cfg := range ports {
@@ -312,6 +418,32 @@ func constructVolumes(cfg *deployment.DeployServiceOptions) []apiv1.Volume {
 	return res
 }
 
+// constructProbe creates a probe which runs the health check command every period seconds and fails after failureThreshold failures
+func constructProbe(cfg *deployment.HealthCheckConfig, period, failureThreshold int32) *apiv1.Probe {
+	if cfg == nil || cfg.Disabled || len(cfg.Command) == 0 {
+		return nil
+	}
+	return &apiv1.Probe{
+		Handler: apiv1.Handler{
+			Exec: &apiv1.ExecAction{
+				Command: cfg.Command,
+			},
+		},
+		InitialDelaySeconds: 1,
+		PeriodSeconds:       period,
+		TimeoutSeconds:      2,
+		FailureThreshold:    failureThreshold,
+	}
+}
+
+func constructTerminationGracePeriod(cfg *deployment.DeployServiceOptions) *int64 {
+	if cfg.StopTimeout <= 0 {
+		return nil
+	}
+	seconds := int64(cfg.StopTimeout.Seconds())
+	return &seconds
+}
+
 func constructEnv(cfg *deployment.DeployServiceOptions) []apiv1.EnvVar {
 	res := make([]apiv1.EnvVar, len(cfg.Env))
 	i := 0
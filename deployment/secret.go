@@ -0,0 +1,61 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+)
+
+// ReplaceSecret deploys a secret, an existing secret with the same id is updated.
+// Services reload updated secrets without restarts, only on platforms where secrets are immutable (swarm)
+// the services which use it are rolled, so that revoked certificates and keys stop being accepted.
+func ReplaceSecret(ctx context.Context, platform SecretPlatform, env, id string, value []byte) error {
+	if _, err := platform.GetSecret(ctx, &GetSecretOptions{EnvironmentID: env, ID: id}); err != nil {
+		return platform.DeploySecret(ctx, &DeploySecretOptions{
+			EnvironmentID: env,
+			ID:            id,
+			Value:         value,
+		})
+	}
+	if err := platform.UpdateSecret(ctx, &UpdateSecretOptions{
+		EnvironmentID: env,
+		ID:            id,
+		Value:         value,
+		Roll:          secretsImmutable(platform),
+	}); err != nil {
+		return fmt.Errorf("could not replace secret %v: %v", id, err)
+	}
+	return nil
+}
+
+func secretsImmutable(platform SecretPlatform) bool {
+	immutable, ok := platform.(ImmutableSecretPlatform)
+	return ok && immutable.SecretsImmutable()
+}
+
+// ReadSecret returns the value of a deployed secret, or nil if it isn't deployed.
+// It fails if the secret exists but its value can't be read, like on swarm which never returns the values of secrets,
+// so callers don't mistake an unreadable secret for an empty one and overwrite it.
+func ReadSecret(ctx context.Context, platform SecretPlatform, env, id string) ([]byte, error) {
+	secrets, err := platform.ListSecrets(ctx, &ListSecretsOptions{EnvironmentID: env})
+	if err != nil {
+		return nil, fmt.Errorf("could not list secrets: %v", err)
+	}
+	deployed := false
+	for _, info := range secrets {
+		if info.ID == id {
+			deployed = true
+			break
+		}
+	}
+	if !deployed {
+		return nil, nil
+	}
+	value, err := platform.GetSecret(ctx, &GetSecretOptions{EnvironmentID: env, ID: id})
+	if err != nil {
+		return nil, fmt.Errorf("could not read secret %v: %v", id, err)
+	}
+	if len(value) == 0 {
+		return nil, fmt.Errorf("the platform doesn't return the value of secret %v", id)
+	}
+	return value, nil
+}
@@ -3,18 +3,34 @@ package swarm
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/trusch/btrfaas/deployment"
 	"github.com/trusch/btrfaas/frunner/env"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
 )
 
+// Swarm secrets are immutable, an update creates a new secret <id>-v<version>.
+// The labels link the versions to the id which is used by the platform interface.
+const (
+	secretLabel        = "btrfaas_secret"
+	secretVersionLabel = "btrfaas_secret_version"
+)
+
+// waitInterval is how often WaitForService checks the tasks of a service
+const waitInterval = time.Second
+
 // swarmPlatform implements deployment.Platform with the help of a docker swarm
 type swarmPlatform struct {
 	cli *client.Client
@@ -52,10 +68,14 @@ func (p *swarmPlatform) DeployService(ctx context.Context, options *deployment.D
 	}
 	options.Labels["btrfaas_env"] = options.EnvironmentID
 
-	secrets, err := p.constructSecretReferences(ctx, options.Secrets)
+	secrets, err := p.constructSecretReferences(ctx, options.EnvironmentID, options.Secrets)
 	if err != nil {
 		return err
 	}
+	var stopGracePeriod *time.Duration
+	if options.StopTimeout > 0 {
+		stopGracePeriod = &options.StopTimeout
+	}
 	service := swarm.ServiceSpec{
 		Annotations: swarm.Annotations{
 			Name:   options.ID,
@@ -63,12 +83,14 @@ func (p *swarmPlatform) DeployService(ctx context.Context, options *deployment.D
 		},
 		TaskTemplate: swarm.TaskSpec{
 			ContainerSpec: swarm.ContainerSpec{
-				Image:   options.Image,
-				Labels:  options.Labels,
-				Command: options.Cmd,
-				Env:     env.Env(options.Env).ToSlice(),
-				Secrets: secrets,
-				Mounts:  createMounts(options.Volumes),
+				Image:           options.Image,
+				Labels:          options.Labels,
+				Command:         options.Cmd,
+				Env:             env.Env(options.Env).ToSlice(),
+				Secrets:         secrets,
+				Mounts:          createMounts(options.Volumes),
+				Healthcheck:     createHealthConfig(options.HealthCheck),
+				StopGracePeriod: stopGracePeriod,
 			},
 			Networks: []swarm.NetworkAttachmentConfig{
 				{Target: netName},
@@ -83,6 +105,48 @@ func (p *swarmPlatform) DeployService(ctx context.Context, options *deployment.D
 	return err
 }
 
+// WaitForService waits until every task of the service is running,
+// tasks with a healthcheck stay in the state starting until the healthcheck passes
+func (p *swarmPlatform) WaitForService(ctx context.Context, options *deployment.WaitForServiceOptions) error {
+	for {
+		service, _, err := p.cli.ServiceInspectWithRaw(ctx, options.ID, types.ServiceInspectOptions{})
+		if err != nil {
+			return err
+		}
+		replicas := uint64(1)
+		if mode := service.Spec.Mode.Replicated; mode != nil && mode.Replicas != nil {
+			replicas = *mode.Replicas
+		}
+		args := filters.NewArgs()
+		args.Add("service", options.ID)
+		tasks, err := p.cli.TaskList(ctx, types.TaskListOptions{Filters: args})
+		if err != nil {
+			return err
+		}
+		running, lastError := uint64(0), ""
+		for _, task := range tasks {
+			if task.DesiredState == swarm.TaskStateRunning && task.Status.State == swarm.TaskStateRunning {
+				running++
+			}
+			if task.Status.Err != "" {
+				lastError = task.Status.Err
+			}
+		}
+		updating := service.UpdateStatus != nil && service.UpdateStatus.State == swarm.UpdateStateUpdating
+		if running == replicas && !updating {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if lastError != "" {
+				return fmt.Errorf("%v is not ready, %v of %v tasks are running, last error: %v", options.ID, running, replicas, lastError)
+			}
+			return fmt.Errorf("%v is not ready, %v of %v tasks are running: %v", options.ID, running, replicas, ctx.Err())
+		case <-time.After(waitInterval):
+		}
+	}
+}
+
 // UndeployService unddeploys a service from an environment
 func (p *swarmPlatform) UndeployService(ctx context.Context, options *deployment.UndeployServiceOptions) error {
 	return p.cli.ServiceRemove(ctx, options.ID)
@@ -127,45 +191,80 @@ func (p *swarmPlatform) ListServices(ctx context.Context, options *deployment.Li
 
 // DeploySecret deploys a secret in an environment
 func (p *swarmPlatform) DeploySecret(ctx context.Context, options *deployment.DeploySecretOptions) error {
-	if options.Labels == nil {
-		options.Labels = make(deployment.LabelSet)
-	}
-	options.Labels["btrfaas_env"] = options.EnvironmentID
-	_, err := p.cli.SecretCreate(ctx, swarm.SecretSpec{
-		Annotations: swarm.Annotations{
-			Name:   options.ID,
-			Labels: options.Labels,
-		},
-		Data: options.Value,
-	})
+	_, err := p.createSecret(ctx, options.EnvironmentID, options.ID, 1, options.Labels, options.Value)
 	return err
 }
 
-// UndeploySecret unddeploys a secret from an environment
+// UpdateSecret creates the next version of a secret.
+// Rolling updates the services which use an older version, afterwards the unused versions are removed.
+func (p *swarmPlatform) UpdateSecret(ctx context.Context, options *deployment.UpdateSecretOptions) error {
+	versions, err := p.secretVersions(ctx, options.EnvironmentID, options.ID)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return errors.New("no such secret")
+	}
+	current := versions[len(versions)-1]
+	labels := make(deployment.LabelSet)
+	for key, val := range current.Spec.Labels {
+		labels[key] = val
+	}
+	next, err := p.createSecret(ctx, options.EnvironmentID, options.ID, secretVersion(current)+1, labels, options.Value)
+	if err != nil {
+		return err
+	}
+	if !options.Roll {
+		return nil
+	}
+	if err = p.rollSecret(ctx, options.EnvironmentID, versions, next); err != nil {
+		return err
+	}
+	for _, old := range versions {
+		// versions which are still in use can't be removed, they are kept until the next update
+		if err = p.cli.SecretRemove(ctx, old.ID); err != nil {
+			log.Printf("can not remove version %v of secret %v: %v", secretVersion(old), options.ID, err)
+		}
+	}
+	return nil
+}
+
+// SecretsImmutable returns true, services only see a new version of a secret after they are rolled
+func (p *swarmPlatform) SecretsImmutable() bool {
+	return true
+}
+
+// UndeploySecret unddeploys all versions of a secret from an environment
 func (p *swarmPlatform) UndeploySecret(ctx context.Context, options *deployment.UndeploySecretOptions) error {
-	return p.cli.SecretRemove(ctx, options.ID)
+	versions, err := p.secretVersions(ctx, options.EnvironmentID, options.ID)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return p.cli.SecretRemove(ctx, options.ID)
+	}
+	for _, secret := range versions {
+		if err = p.cli.SecretRemove(ctx, secret.ID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// GetSecret unddeploys a secret from an environment
+// GetSecret returns the value of the current version of a secret.
+// Docker doesn't return the data of secrets, so it is empty.
 func (p *swarmPlatform) GetSecret(ctx context.Context, options *deployment.GetSecretOptions) ([]byte, error) {
-	args := filters.NewArgs()
-	args.Add("label", "btrfaas_env="+options.EnvironmentID)
-	opts := types.SecretListOptions{
-		Filters: args,
-	}
-	resp, err := p.cli.SecretList(ctx, opts)
+	versions, err := p.secretVersions(ctx, options.EnvironmentID, options.ID)
 	if err != nil {
 		return nil, err
 	}
-	for _, secret := range resp {
-		if secret.Spec.Name == options.ID {
-			return secret.Spec.Data, nil
-		}
+	if len(versions) == 0 {
+		return nil, errors.New("no such secret")
 	}
-	return nil, errors.New("no such secret")
+	return versions[len(versions)-1].Spec.Data, nil
 }
 
-// ListSecrets returns a list of all deployed secrets
+// ListSecrets returns a list of all deployed secrets with their current version
 func (p *swarmPlatform) ListSecrets(ctx context.Context, options *deployment.ListSecretsOptions) ([]*deployment.SecretInfo, error) {
 	if options.Labels == nil {
 		options.Labels = make(deployment.LabelSet)
@@ -185,26 +284,126 @@ func (p *swarmPlatform) ListSecrets(ctx context.Context, options *deployment.Lis
 	if err != nil {
 		return nil, err
 	}
-	result := make([]*deployment.SecretInfo, len(resp))
-	for idx, val := range resp {
-		result[idx] = &deployment.SecretInfo{
-			ID:     val.Spec.Name,
-			Labels: val.Spec.Labels,
+	var result []*deployment.SecretInfo
+	infos := make(map[string]*deployment.SecretInfo)
+	for _, val := range resp {
+		id := secretID(val)
+		info, ok := infos[id]
+		if !ok {
+			info = &deployment.SecretInfo{ID: id}
+			infos[id] = info
+			result = append(result, info)
+		}
+		if version := secretVersion(val); version > info.Version {
+			info.Version = version
+			info.Labels = val.Spec.Labels
 		}
 	}
 	return result, nil
 }
 
-func (p *swarmPlatform) constructSecretReferences(ctx context.Context, list map[string]string) ([]*swarm.SecretReference, error) {
-	res := make([]*swarm.SecretReference, len(list))
-	secrets, err := p.cli.SecretList(ctx, types.SecretListOptions{})
+// createSecret creates the given version of a secret
+func (p *swarmPlatform) createSecret(ctx context.Context, env, id string, version uint64, labels deployment.LabelSet, value []byte) (swarm.Secret, error) {
+	if labels == nil {
+		labels = make(deployment.LabelSet)
+	}
+	labels["btrfaas_env"] = env
+	labels[secretLabel] = id
+	labels[secretVersionLabel] = strconv.FormatUint(version, 10)
+	name := id
+	if version > 1 {
+		name = fmt.Sprintf("%v-v%v", id, version)
+	}
+	spec := swarm.SecretSpec{
+		Annotations: swarm.Annotations{
+			Name:   name,
+			Labels: labels,
+		},
+		Data: value,
+	}
+	resp, err := p.cli.SecretCreate(ctx, spec)
+	if err != nil {
+		return swarm.Secret{}, err
+	}
+	return swarm.Secret{ID: resp.ID, Spec: spec}, nil
+}
+
+// secretVersions returns all versions of a secret, the current version last
+func (p *swarmPlatform) secretVersions(ctx context.Context, env, id string) ([]swarm.Secret, error) {
+	args := filters.NewArgs()
+	args.Add("label", "btrfaas_env="+env)
+	resp, err := p.cli.SecretList(ctx, types.SecretListOptions{Filters: args})
 	if err != nil {
 		return nil, err
 	}
+	var res []swarm.Secret
+	for _, secret := range resp {
+		if secretID(secret) == id {
+			res = append(res, secret)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return secretVersion(res[i]) < secretVersion(res[j])
+	})
+	return res, nil
+}
+
+// rollSecret points the services of the environment which use one of the versions to next, swarm replaces their tasks
+func (p *swarmPlatform) rollSecret(ctx context.Context, env string, versions []swarm.Secret, next swarm.Secret) error {
+	ids := make(map[string]bool)
+	for _, secret := range versions {
+		ids[secret.ID] = true
+	}
+	args := filters.NewArgs()
+	args.Add("label", "btrfaas_env="+env)
+	services, err := p.cli.ServiceList(ctx, types.ServiceListOptions{Filters: args})
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		spec := service.Spec
+		changed := false
+		for _, ref := range spec.TaskTemplate.ContainerSpec.Secrets {
+			if ids[ref.SecretID] {
+				ref.SecretID = next.ID
+				ref.SecretName = next.Spec.Name
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if _, err = p.cli.ServiceUpdate(ctx, service.ID, service.Version, spec, types.ServiceUpdateOptions{}); err != nil {
+			return fmt.Errorf("could not roll %v: %v", spec.Name, err)
+		}
+	}
+	return nil
+}
+
+// secretID returns the id of the secret a version belongs to, secrets of older btrfaas versions have no labels
+func secretID(secret swarm.Secret) string {
+	if id, ok := secret.Spec.Labels[secretLabel]; ok {
+		return id
+	}
+	return secret.Spec.Name
+}
+
+// secretVersion returns the version of a secret, secrets of older btrfaas versions are version 1
+func secretVersion(secret swarm.Secret) uint64 {
+	version, err := strconv.ParseUint(secret.Spec.Labels[secretVersionLabel], 10, 64)
+	if err != nil {
+		return 1
+	}
+	return version
+}
+
+// constructSecretReferences references the current versions of the secrets
+func (p *swarmPlatform) constructSecretReferences(ctx context.Context, env string, list map[string]string) ([]*swarm.SecretReference, error) {
+	res := make([]*swarm.SecretReference, len(list))
 	idx := 0
-	for secretID, path := range list {
+	for id, path := range list {
 		res[idx] = &swarm.SecretReference{
-			SecretName: secretID,
+			SecretName: id,
 			File: &swarm.SecretReferenceFileTarget{
 				Name: path,
 				UID:  "0",
@@ -212,10 +411,14 @@ func (p *swarmPlatform) constructSecretReferences(ctx context.Context, list map[
 				Mode: 0600,
 			},
 		}
-		for _, s := range secrets {
-			if s.Spec.Name == secretID {
-				res[idx].SecretID = s.ID
-			}
+		versions, err := p.secretVersions(ctx, env, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) > 0 {
+			current := versions[len(versions)-1]
+			res[idx].SecretID = current.ID
+			res[idx].SecretName = current.Spec.Name
 		}
 		idx++
 	}
@@ -303,6 +506,20 @@ func createPortConfigs(configs []*deployment.PortConfig) []swarm.PortConfig {
 	return res[:i]
 }
 
+// createHealthConfig creates a healthcheck which runs the health check command,
+// swarm doesn't route requests to tasks until they are healthy and replaces tasks which become unhealthy
+func createHealthConfig(cfg *deployment.HealthCheckConfig) *container.HealthConfig {
+	if cfg == nil || cfg.Disabled || len(cfg.Command) == 0 {
+		return nil
+	}
+	return &container.HealthConfig{
+		Test:     append([]string{"CMD"}, cfg.Command...),
+		Interval: 5 * time.Second,
+		Timeout:  2 * time.Second,
+		Retries:  3,
+	}
+}
+
 func createMounts(volumes []*deployment.VolumeConfig) []mount.Mount {
 	var mounts []mount.Mount
 	for _, cfg := range volumes {
@@ -0,0 +1,154 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// PassphraseEnv is the environment variable which contains the passphrase used to encrypt secrets
+	PassphraseEnv = "BTRFAAS_SECRET_PASSPHRASE"
+	// KeyFileEnv is the environment variable which contains the path of a key file used to encrypt secrets
+	KeyFileEnv = "BTRFAAS_SECRET_KEY_FILE"
+)
+
+// magic prefixes all sealed data, it is followed by the salt, the nonce and the ciphertext
+var magic = []byte("btrfaas-sealed:v1\n")
+
+const (
+	saltSize   = 16
+	keySize    = 32
+	iterations = 100000
+	// minKeyFileSize is the minimal size of a key file, it should contain at least 256 random bits
+	minKeyFileSize = 32
+)
+
+// ErrNoKey is returned if sealed data should be opened without a key
+var ErrNoKey = fmt.Errorf("secret is encrypted, set %v or %v", PassphraseEnv, KeyFileEnv)
+
+// Key encrypts and decrypts data with AES-GCM.
+// Every sealed value gets a random salt from which the actual encryption key is derived.
+type Key struct {
+	derive  func(salt []byte) []byte
+	mutex   sync.Mutex
+	derived map[string][]byte
+}
+
+// NewPassphraseKey returns a key which derives the encryption keys from a passphrase with PBKDF2
+func NewPassphraseKey(passphrase string) *Key {
+	secret := []byte(passphrase)
+	return newKey(func(salt []byte) []byte {
+		return pbkdf2.Key(secret, salt, iterations, keySize, sha256.New)
+	})
+}
+
+// LoadKeyFile returns a key which derives the encryption keys from the contents of a file.
+// The file should contain at least 32 random bytes, like the output of `head -c 32 /dev/urandom | base64`.
+func LoadKeyFile(path string) (*Key, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	bs = bytes.TrimSpace(bs)
+	if len(bs) < minKeyFileSize {
+		return nil, fmt.Errorf("key file %v is too short, it needs at least %v bytes", path, minKeyFileSize)
+	}
+	return newKey(func(salt []byte) []byte {
+		mac := hmac.New(sha256.New, bs)
+		mac.Write(salt)
+		return mac.Sum(nil)
+	}), nil
+}
+
+// KeyFromEnvironment returns the key configured by BTRFAAS_SECRET_KEY_FILE or BTRFAAS_SECRET_PASSPHRASE,
+// the key file wins if both are set. It returns nil if no key is configured.
+func KeyFromEnvironment() (*Key, error) {
+	if path := os.Getenv(KeyFileEnv); path != "" {
+		return LoadKeyFile(path)
+	}
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return NewPassphraseKey(passphrase), nil
+	}
+	return nil, nil
+}
+
+func newKey(derive func(salt []byte) []byte) *Key {
+	return &Key{derive: derive, derived: make(map[string][]byte)}
+}
+
+// IsSealed returns true if data got sealed by a key
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Seal encrypts data
+func (key *Key) Seal(data []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := key.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	res := append([]byte{}, magic...)
+	res = append(res, salt...)
+	res = append(res, nonce...)
+	return aead.Seal(res, nonce, data, magic), nil
+}
+
+// Open decrypts sealed data, it fails if the data was sealed by another key or was modified
+func (key *Key) Open(data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return nil, errors.New("data is not sealed")
+	}
+	data = data[len(magic):]
+	if len(data) < saltSize {
+		return nil, errors.New("sealed data is truncated")
+	}
+	aead, err := key.aead(data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[saltSize:]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("sealed data is truncated")
+	}
+	res, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], magic)
+	if err != nil {
+		return nil, errors.New("could not decrypt sealed data, wrong key?")
+	}
+	return res, nil
+}
+
+// aead returns the cipher for the given salt, derived keys are cached since PBKDF2 is slow on purpose
+func (key *Key) aead(salt []byte) (cipher.AEAD, error) {
+	key.mutex.Lock()
+	k, ok := key.derived[string(salt)]
+	if !ok {
+		k = key.derive(salt)
+		key.derived[string(salt)] = k
+	}
+	key.mutex.Unlock()
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,101 @@
+package vault_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/trusch/btrfaas/deployment/vault"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Key", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "vault")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should seal and open data with a passphrase", func() {
+		key := NewPassphraseKey("secret")
+		sealed, err := key.Seal([]byte("plaintext"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(IsSealed(sealed)).To(BeTrue())
+		Expect(string(sealed)).NotTo(ContainSubstring("plaintext"))
+
+		again, err := key.Seal([]byte("plaintext"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).NotTo(Equal(sealed))
+
+		Expect(NewPassphraseKey("secret").Open(sealed)).To(Equal([]byte("plaintext")))
+		_, err = NewPassphraseKey("wrong").Open(sealed)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should derive passphrase keys with PBKDF2-HMAC-SHA256", func() {
+		// sealed with salt "0123456789abcdef", nonce "btrfaas-nonc" and the key
+		// PBKDF2-HMAC-SHA256("correct horse battery staple", salt, 100000, 32) = 090105d3788cadab9c12509fa1ba1d46a91a158d7a1779b114322f3fd5a825cb
+		sealed, err := base64.StdEncoding.DecodeString("YnRyZmFhcy1zZWFsZWQ6djEKMDEyMzQ1Njc4OWFiY2RlZmJ0cmZhYXMtbm9uY1UxKH65BDqV2KMcSMTY56HYCAdnp78dAe4=")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(NewPassphraseKey("correct horse battery staple").Open(sealed)).To(Equal([]byte("plaintext")))
+	})
+
+	It("should detect modified data", func() {
+		key := NewPassphraseKey("secret")
+		sealed, err := key.Seal([]byte("plaintext"))
+		Expect(err).NotTo(HaveOccurred())
+		sealed[len(sealed)-1] ^= 1
+		_, err = key.Open(sealed)
+		Expect(err).To(HaveOccurred())
+		_, err = key.Open(sealed[:30])
+		Expect(err).To(HaveOccurred())
+		_, err = key.Open([]byte("plaintext"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should use key files", func() {
+		path := filepath.Join(dir, "secret.key")
+		Expect(ioutil.WriteFile(path, []byte("short\n"), 0600)).To(Succeed())
+		_, err := LoadKeyFile(path)
+		Expect(err).To(HaveOccurred())
+
+		Expect(ioutil.WriteFile(path, []byte("c2VjcmV0LWtleS1maWxlLWNvbnRlbnQtd2l0aC1lbm91Z2gtYnl0ZXMK\n"), 0600)).To(Succeed())
+		key, err := LoadKeyFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		sealed, err := key.Seal([]byte("plaintext"))
+		Expect(err).NotTo(HaveOccurred())
+		other, err := LoadKeyFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.Open(sealed)).To(Equal([]byte("plaintext")))
+		_, err = NewPassphraseKey("c2VjcmV0LWtleS1maWxlLWNvbnRlbnQtd2l0aC1lbm91Z2gtYnl0ZXMK").Open(sealed)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should read the key from the environment", func() {
+		defer os.Unsetenv(PassphraseEnv)
+		defer os.Unsetenv(KeyFileEnv)
+		os.Unsetenv(PassphraseEnv)
+		os.Unsetenv(KeyFileEnv)
+		Expect(KeyFromEnvironment()).To(BeNil())
+
+		os.Setenv(PassphraseEnv, "secret")
+		key, err := KeyFromEnvironment()
+		Expect(err).NotTo(HaveOccurred())
+		sealed, err := key.Seal([]byte("plaintext"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(NewPassphraseKey("secret").Open(sealed)).To(Equal([]byte("plaintext")))
+
+		os.Setenv(KeyFileEnv, filepath.Join(dir, "missing.key"))
+		_, err = KeyFromEnvironment()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,157 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/trusch/btrfaas/deployment"
+)
+
+// filePlatform implements deployment.SecretPlatform with files in <root>/<env>/secrets.
+// It is a local stand-in for a real vault: if a key is set the files are sealed, otherwise they are kept in plaintext.
+type filePlatform struct {
+	root string
+	key  *Key
+}
+
+// NewPlatform returns a secret platform which keeps the secrets in <root>/<env>/secrets, key may be nil
+func NewPlatform(root string, key *Key) deployment.SecretPlatform {
+	return &filePlatform{root, key}
+}
+
+// DeploySecret deploys a secret in an environment, an existing secret is overwritten in place
+func (p *filePlatform) DeploySecret(ctx context.Context, options *deployment.DeploySecretOptions) error {
+	path, err := p.path(options.EnvironmentID, options.ID)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return p.write(path, options.Value)
+}
+
+// UpdateSecret overwrites an existing secret in place, the files are not versioned
+func (p *filePlatform) UpdateSecret(ctx context.Context, options *deployment.UpdateSecretOptions) error {
+	path, err := p.path(options.EnvironmentID, options.ID)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(path); err != nil {
+		return err
+	}
+	return p.write(path, options.Value)
+}
+
+// GetSecret returns the secret value, plaintext secrets written before a key was configured are returned as they are
+func (p *filePlatform) GetSecret(ctx context.Context, options *deployment.GetSecretOptions) ([]byte, error) {
+	path, err := p.path(options.EnvironmentID, options.ID)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil || !IsSealed(bs) {
+		return bs, err
+	}
+	if p.key == nil {
+		return nil, ErrNoKey
+	}
+	return p.key.Open(bs)
+}
+
+// UndeploySecret unddeploys a secret from an environment
+func (p *filePlatform) UndeploySecret(ctx context.Context, options *deployment.UndeploySecretOptions) error {
+	path, err := p.path(options.EnvironmentID, options.ID)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// ListSecrets returns a list of all deployed secrets
+func (p *filePlatform) ListSecrets(ctx context.Context, options *deployment.ListSecretsOptions) ([]*deployment.SecretInfo, error) {
+	resp, err := ioutil.ReadDir(filepath.Join(p.root, options.EnvironmentID, "secrets"))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*deployment.SecretInfo, len(resp))
+	for idx, val := range resp {
+		result[idx] = &deployment.SecretInfo{
+			ID: val.Name(),
+		}
+	}
+	return result, nil
+}
+
+// SealPlaintext seals the secrets of all environments in root which were written in plaintext before a key was
+// configured and returns their number. The sealed files replace the plaintext ones by a rename, containers which
+// still mount a plaintext file keep their copy until they are redeployed.
+func SealPlaintext(root string, key *Key) (int, error) {
+	envs, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, env := range envs {
+		if !env.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, env.Name(), "secrets")
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		for _, file := range files {
+			if !file.Mode().IsRegular() {
+				continue
+			}
+			path := filepath.Join(dir, file.Name())
+			bs, err := ioutil.ReadFile(path)
+			if err != nil {
+				return count, err
+			}
+			if IsSealed(bs) {
+				continue
+			}
+			if bs, err = key.Seal(bs); err != nil {
+				return count, err
+			}
+			if err = ioutil.WriteFile(path+".sealed", bs, 0600); err != nil {
+				return count, err
+			}
+			if err = os.Rename(path+".sealed", path); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// write writes the value sealed if a key is set, the file is overwritten in place so that bind mounts see the change
+func (p *filePlatform) write(path string, value []byte) error {
+	if p.key != nil {
+		var err error
+		if value, err = p.key.Seal(value); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path, value, 0600)
+}
+
+func (p *filePlatform) path(env, id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return "", errors.New("invalid secret id: " + id)
+	}
+	return filepath.Join(p.root, env, "secrets", id), nil
+}
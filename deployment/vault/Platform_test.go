@@ -0,0 +1,112 @@
+package vault_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/trusch/btrfaas/deployment"
+	. "github.com/trusch/btrfaas/deployment/vault"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Platform", func() {
+	var (
+		ctx = context.Background()
+		dir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "vault")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	deploy := func(platform deployment.SecretPlatform, id, value string) error {
+		return platform.DeploySecret(ctx, &deployment.DeploySecretOptions{
+			EnvironmentID: "test",
+			ID:            id,
+			Value:         []byte(value),
+		})
+	}
+
+	get := func(platform deployment.SecretPlatform, id string) (string, error) {
+		bs, err := platform.GetSecret(ctx, &deployment.GetSecretOptions{
+			EnvironmentID: "test",
+			ID:            id,
+		})
+		return string(bs), err
+	}
+
+	It("should keep sealed secrets", func() {
+		platform := NewPlatform(dir, NewPassphraseKey("secret"))
+		Expect(deploy(platform, "fgateway-key", "private key")).To(Succeed())
+		bs, err := ioutil.ReadFile(filepath.Join(dir, "test", "secrets", "fgateway-key"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(IsSealed(bs)).To(BeTrue())
+		Expect(get(platform, "fgateway-key")).To(Equal("private key"))
+
+		_, err = get(NewPlatform(dir, nil), "fgateway-key")
+		Expect(err).To(Equal(ErrNoKey))
+		_, err = get(NewPlatform(dir, NewPassphraseKey("wrong")), "fgateway-key")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should read plaintext secrets written without a key", func() {
+		Expect(deploy(NewPlatform(dir, nil), "legacy", "value")).To(Succeed())
+		bs, err := ioutil.ReadFile(filepath.Join(dir, "test", "secrets", "legacy"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(bs)).To(Equal("value"))
+		Expect(get(NewPlatform(dir, NewPassphraseKey("secret")), "legacy")).To(Equal("value"))
+	})
+
+	It("should seal plaintext secrets written without a key", func() {
+		Expect(deploy(NewPlatform(dir, nil), "legacy", "value")).To(Succeed())
+		key := NewPassphraseKey("secret")
+		Expect(deploy(NewPlatform(dir, key), "sealed", "other value")).To(Succeed())
+		Expect(SealPlaintext(dir, key)).To(Equal(1))
+		bs, err := ioutil.ReadFile(filepath.Join(dir, "test", "secrets", "legacy"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(IsSealed(bs)).To(BeTrue())
+		Expect(get(NewPlatform(dir, key), "legacy")).To(Equal("value"))
+		Expect(get(NewPlatform(dir, key), "sealed")).To(Equal("other value"))
+		Expect(SealPlaintext(dir, key)).To(Equal(0))
+		Expect(SealPlaintext(filepath.Join(dir, "missing"), key)).To(Equal(0))
+	})
+
+	It("should list and undeploy secrets", func() {
+		platform := NewPlatform(dir, NewPassphraseKey("secret"))
+		Expect(deploy(platform, "a", "1")).To(Succeed())
+		Expect(deploy(platform, "b", "2")).To(Succeed())
+		list, err := platform.ListSecrets(ctx, &deployment.ListSecretsOptions{EnvironmentID: "test"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list).To(HaveLen(2))
+		Expect(platform.UndeploySecret(ctx, &deployment.UndeploySecretOptions{EnvironmentID: "test", ID: "a"})).To(Succeed())
+		_, err = get(platform, "a")
+		Expect(err).To(HaveOccurred())
+		Expect(get(platform, "b")).To(Equal("2"))
+	})
+
+	It("should update existing secrets", func() {
+		platform := NewPlatform(dir, NewPassphraseKey("secret"))
+		err := platform.UpdateSecret(ctx, &deployment.UpdateSecretOptions{EnvironmentID: "test", ID: "a", Value: []byte("2")})
+		Expect(err).To(HaveOccurred())
+		Expect(deploy(platform, "a", "1")).To(Succeed())
+		err = platform.UpdateSecret(ctx, &deployment.UpdateSecretOptions{EnvironmentID: "test", ID: "a", Value: []byte("2")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get(platform, "a")).To(Equal("2"))
+	})
+
+	It("should refuse ids which escape the secret directory", func() {
+		platform := NewPlatform(dir, nil)
+		Expect(deploy(platform, "../escape", "value")).NotTo(Succeed())
+		Expect(deploy(platform, "..", "value")).NotTo(Succeed())
+	})
+})
@@ -3,16 +3,26 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"path/filepath"
 	"time"
 
+	homedir "github.com/mitchellh/go-homedir"
 	"github.com/trusch/btrfaas/fgateway/grpc"
+	"github.com/trusch/btrfaas/pki"
 	g "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
+const env = "btrfaas_default"
+
 type devNull struct{}
 
 func (d *devNull) Write(data []byte) (bs int, err error) {
@@ -21,13 +31,46 @@ func (d *devNull) Write(data []byte) (bs int, err error) {
 
 type runSyncFunc func(context.Context, string, []byte, int) error
 
+// getTransportCredentials presents the shared client certificate of the environment to the gateway
+func getTransportCredentials() (g.DialOption, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(home, ".btrfaas", env, "ca-cert.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read ca certificate: %s", err)
+	}
+	certPool := x509.NewCertPool()
+	if ok := certPool.AppendCertsFromPEM(ca); !ok {
+		return nil, errors.New("failed to append ca certs")
+	}
+	certFile, keyFile, err := pki.ClientFiles(env, "")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load client certificate: %s", err)
+	}
+	return g.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		ServerName:   "fgateway",
+		RootCAs:      certPool,
+		Certificates: []tls.Certificate{cert},
+	})), nil
+}
+
 func runBtrfaasSync(ctx context.Context, fn string, data []byte, n int) error {
-	cli, err := grpc.NewClient("127.0.0.1:2424", g.WithInsecure())
+	creds, err := getTransportCredentials()
+	if err != nil {
+		return err
+	}
+	cli, err := grpc.NewClient("127.0.0.1:2424", creds)
 	if err != nil {
 		log.Fatal("init:", err)
 	}
 	for i := 0; i < n; i++ {
-		if err := cli.Run(ctx, []string{fn}, []map[string]string{{}}, bytes.NewReader(data), &devNull{}); err != nil {
+		if err := cli.Run(ctx, []string{fn}, [][]string{{}}, bytes.NewReader(data), &devNull{}); err != nil {
 			log.Print(err)
 		}
 	}
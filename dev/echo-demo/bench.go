@@ -19,13 +19,17 @@ import (
 	"github.com/olekukonko/tablewriter"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/trusch/btrfaas/fgateway/grpc"
+	"github.com/trusch/btrfaas/pki"
 	g "google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
 
 type runSyncFunc func(context.Context, string, []byte, int) error
 
-var certPool *x509.CertPool
+var (
+	certPool   *x509.CertPool
+	clientCert tls.Certificate
+)
 
 func getTransportCredentials(target string) (g.DialOption, error) {
 	if certPool == nil {
@@ -33,7 +37,7 @@ func getTransportCredentials(target string) (g.DialOption, error) {
 		if err != nil {
 			return nil, err
 		}
-		ca, err := ioutil.ReadFile(filepath.Join(home, ".btrfaas", "btrfaas_default", "ca-cert.pem"))
+		ca, err := ioutil.ReadFile(filepath.Join(home, ".btrfaas", *env, "ca-cert.pem"))
 		if err != nil {
 			return nil, fmt.Errorf("could not read ca certificate: %s", err)
 		}
@@ -42,11 +46,19 @@ func getTransportCredentials(target string) (g.DialOption, error) {
 		if ok := certPool.AppendCertsFromPEM(ca); !ok {
 			return nil, errors.New("failed to append ca certs")
 		}
+		certFile, keyFile, err := pki.ClientFiles(*env, *user)
+		if err != nil {
+			return nil, err
+		}
+		if clientCert, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %s", err)
+		}
 	}
 
 	creds := credentials.NewTLS(&tls.Config{
-		ServerName: target,
-		RootCAs:    certPool,
+		ServerName:   target,
+		RootCAs:      certPool,
+		Certificates: []tls.Certificate{clientCert},
 	})
 
 	return g.WithTransportCredentials(creds), nil
@@ -94,6 +106,8 @@ var c = flag.Int("c", 10, "concurrency level")
 var n = flag.Int("n", 1000, "how many requests")
 var size = flag.Int("size", 32, "payload size")
 var fn = flag.String("function", "", "function to benchmark")
+var env = flag.String("env", "btrfaas_default", "environment whose certificates are used")
+var user = flag.String("user", "", "present the client certificate of this user, the shared one if empty")
 
 var stats = prometheus.NewSummaryVec(
 	prometheus.SummaryOpts{
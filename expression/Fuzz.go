@@ -0,0 +1,17 @@
+// +build gofuzz
+
+package expression
+
+// Fuzz is the entry point for go-fuzz, build it with `go-fuzz-build github.com/trusch/btrfaas/expression`
+// and run it with `go-fuzz -bin expression-fuzz.zip -workdir expression/testdata/fuzz`.
+// Errors must point into the expression and the canonical form of a parsed expression must parse to the same chain.
+func Fuzz(data []byte) int {
+	parsed, err := checkRoundTrip(string(data))
+	if err != nil {
+		panic(err)
+	}
+	if !parsed {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,179 @@
+package expression
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// TokenType is the type of a token
+type TokenType int
+
+// token types
+const (
+	EOF TokenType = iota
+	Word
+	Pipe
+	Ampersand       // & separates branches whose outputs are concatenated
+	DoubleAmpersand // && separates branches whose outputs are interleaved line by line
+	LParen
+	RParen
+)
+
+func (t TokenType) String() string {
+	switch t {
+	case EOF:
+		return "end of expression"
+	case Word:
+		return "word"
+	case Pipe:
+		return "|"
+	case Ampersand:
+		return "&"
+	case DoubleAmpersand:
+		return "&&"
+	case LParen:
+		return "("
+	case RParen:
+		return ")"
+	}
+	return fmt.Sprintf("token %d", int(t))
+}
+
+// Token is a token of a function expression, Column is the 1-based position of its first character
+type Token struct {
+	Type   TokenType
+	Value  string
+	Column int
+}
+
+// SyntaxError is returned for malformed expressions, Column is the 1-based position of the offending character
+type SyntaxError struct {
+	Column int
+	Msg    string
+}
+
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf("column %v: %v", err.Column, err.Msg)
+}
+
+// Lexer splits a function expression into tokens.
+// Words are separated by whitespace and quoted like in a shell:
+// single quotes keep everything literally, double quotes allow the escapes \" and \\,
+// outside of quotes a backslash escapes any character.
+type Lexer struct {
+	input []rune
+	pos   int
+}
+
+// NewLexer returns a lexer for expr
+func NewLexer(expr string) *Lexer {
+	return &Lexer{input: []rune(expr)}
+}
+
+// Next returns the next token, EOF at the end of the expression
+func (l *Lexer) Next() (Token, error) {
+	for l.pos < len(l.input) && unicode.IsSpace(l.input[l.pos]) {
+		l.pos++
+	}
+	if l.pos == len(l.input) {
+		return Token{Type: EOF, Column: l.pos + 1}, nil
+	}
+	start := l.pos
+	switch l.input[l.pos] {
+	case '|':
+		return l.operator(Pipe, 1), nil
+	case '(':
+		return l.operator(LParen, 1), nil
+	case ')':
+		return l.operator(RParen, 1), nil
+	case '&':
+		if l.pos+1 < len(l.input) && l.input[l.pos+1] == '&' {
+			return l.operator(DoubleAmpersand, 2), nil
+		}
+		return l.operator(Ampersand, 1), nil
+	}
+	value, err := l.word()
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{Type: Word, Value: value, Column: start + 1}, nil
+}
+
+// operator returns the operator token of the given length at the current position
+func (l *Lexer) operator(typ TokenType, length int) Token {
+	token := Token{Type: typ, Value: string(l.input[l.pos : l.pos+length]), Column: l.pos + 1}
+	l.pos += length
+	return token
+}
+
+// word reads a word up to the next unquoted whitespace or operator
+func (l *Lexer) word() (string, error) {
+	var res []rune
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		switch {
+		case unicode.IsSpace(c) || isOperator(c):
+			return string(res), nil
+		case c == '\'':
+			end := l.find(l.pos+1, '\'')
+			if end < 0 {
+				return "", l.errorf(l.pos, "unterminated single quote")
+			}
+			res = append(res, l.input[l.pos+1:end]...)
+			l.pos = end + 1
+		case c == '"':
+			quoted, err := l.doubleQuoted()
+			if err != nil {
+				return "", err
+			}
+			res = append(res, quoted...)
+		case c == '\\':
+			if l.pos+1 == len(l.input) {
+				return "", l.errorf(l.pos, "trailing backslash")
+			}
+			res = append(res, l.input[l.pos+1])
+			l.pos += 2
+		default:
+			res = append(res, c)
+			l.pos++
+		}
+	}
+	return string(res), nil
+}
+
+// doubleQuoted reads a double quoted string starting at the current position
+func (l *Lexer) doubleQuoted() ([]rune, error) {
+	start := l.pos
+	res := []rune{}
+	for l.pos++; l.pos < len(l.input); l.pos++ {
+		c := l.input[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return res, nil
+		case c == '\\' && l.pos+1 < len(l.input) && (l.input[l.pos+1] == '"' || l.input[l.pos+1] == '\\'):
+			l.pos++
+			res = append(res, l.input[l.pos])
+		default:
+			res = append(res, c)
+		}
+	}
+	return nil, l.errorf(start, "unterminated double quote")
+}
+
+func isOperator(c rune) bool {
+	return c == '|' || c == '&' || c == '(' || c == ')'
+}
+
+func (l *Lexer) find(from int, c rune) int {
+	for i := from; i < len(l.input); i++ {
+		if l.input[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *Lexer) errorf(pos int, format string, args ...interface{}) error {
+	return &SyntaxError{Column: pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,326 @@
+package expression
+
+import (
+	"strings"
+)
+
+// maxDepth limits the nesting of groups
+const maxDepth = 32
+
+// Expression is a parsed function expression like `sed -e 's/a b/c d/' | to-upper`.
+// Besides linear chains it may contain parallel groups and tees, like `a | (b & c) | tee (audit) | d`.
+type Expression struct {
+	Pipeline *Pipeline
+}
+
+// Pipeline is a list of nodes separated by pipes, the output of every node is the input of the next one
+type Pipeline struct {
+	Nodes []Node
+}
+
+// Node is an element of a pipeline, it is either a *Stage, a *Group or a *Tee
+type Node interface {
+	String() string
+}
+
+// Stage is a function of the chain with its options
+type Stage struct {
+	Function string
+	Options  []string
+	Column   int
+}
+
+// MergeMode defines how the outputs of the branches of a group are merged
+type MergeMode int
+
+const (
+	// Concat writes the outputs of the branches one after another in the order of the branches, written as `&`
+	Concat MergeMode = iota
+	// Interleave writes the outputs of the branches line by line as soon as a line is complete, written as `&&`
+	Interleave
+)
+
+func (mode MergeMode) String() string {
+	if mode == Interleave {
+		return "&&"
+	}
+	return "&"
+}
+
+// Group runs its branches in parallel, every branch gets a copy of the input.
+// It is written as `(a & b)` or `(a && b)`, a group with a single branch just groups a pipeline.
+type Group struct {
+	Merge    MergeMode
+	Branches []*Pipeline
+	Column   int
+}
+
+// Tee passes its input through unchanged and runs its branch with a copy of the input,
+// the output of the branch is discarded. It is written as `tee (a | b)`.
+type Tee struct {
+	Branch *Group
+	Column int
+}
+
+// Parse parses a chain of functions separated by pipes, every function may be followed by options
+func Parse(expr string) (*Expression, error) {
+	p := &parser{lexer: NewLexer(expr)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.token.Type == EOF {
+		return nil, &SyntaxError{Column: 1, Msg: "empty expression"}
+	}
+	pipeline, err := p.pipeline(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.token.Type != EOF {
+		return nil, p.unexpected()
+	}
+	return &Expression{Pipeline: pipeline}, nil
+}
+
+// New returns a linear expression which chains the functions, options may be nil
+func New(chain []string, options [][]string) *Expression {
+	pipeline := &Pipeline{}
+	for i, function := range chain {
+		stage := &Stage{Function: function}
+		if i < len(options) {
+			stage.Options = options[i]
+		}
+		pipeline.Nodes = append(pipeline.Nodes, stage)
+	}
+	return &Expression{Pipeline: pipeline}
+}
+
+// Stages returns all stages of the expression depth first, in the order they appear in the expression
+func (expr *Expression) Stages() []*Stage {
+	return expr.Pipeline.stages(nil)
+}
+
+// Linear returns true if the expression is a plain chain of functions without groups or tees
+func (expr *Expression) Linear() bool {
+	for _, node := range expr.Pipeline.Nodes {
+		if _, ok := node.(*Stage); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Chain returns the function names of the stages
+func (expr *Expression) Chain() []string {
+	stages := expr.Stages()
+	res := make([]string, len(stages))
+	for i, stage := range stages {
+		res[i] = stage.Function
+	}
+	return res
+}
+
+// Options returns the options of the stages
+func (expr *Expression) Options() [][]string {
+	stages := expr.Stages()
+	res := make([][]string, len(stages))
+	for i, stage := range stages {
+		res[i] = stage.Options
+	}
+	return res
+}
+
+// String returns the expression in its canonical form, options are quoted if necessary
+func (expr *Expression) String() string {
+	return expr.Pipeline.String()
+}
+
+func (pipeline *Pipeline) String() string {
+	nodes := make([]string, len(pipeline.Nodes))
+	for i, node := range pipeline.Nodes {
+		nodes[i] = node.String()
+	}
+	return strings.Join(nodes, " | ")
+}
+
+func (pipeline *Pipeline) stages(res []*Stage) []*Stage {
+	for _, node := range pipeline.Nodes {
+		switch node := node.(type) {
+		case *Stage:
+			res = append(res, node)
+		case *Group:
+			res = node.stages(res)
+		case *Tee:
+			res = node.Branch.stages(res)
+		}
+	}
+	return res
+}
+
+func (stage *Stage) String() string {
+	words := []string{Quote(stage.Function)}
+	for _, opt := range stage.Options {
+		words = append(words, Quote(opt))
+	}
+	return strings.Join(words, " ")
+}
+
+func (group *Group) String() string {
+	branches := make([]string, len(group.Branches))
+	for i, branch := range group.Branches {
+		branches[i] = branch.String()
+	}
+	return "(" + strings.Join(branches, " "+group.Merge.String()+" ") + ")"
+}
+
+func (group *Group) stages(res []*Stage) []*Stage {
+	for _, branch := range group.Branches {
+		res = branch.stages(res)
+	}
+	return res
+}
+
+func (tee *Tee) String() string {
+	return "tee " + tee.Branch.String()
+}
+
+// Quote quotes s so that it is parsed as a single word
+func Quote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(c rune) bool { return !isSafe(c) }) < 0 {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// isSafe returns true for characters which don't need quoting
+func isSafe(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.,:/=+@%", c)
+}
+
+type parser struct {
+	lexer *Lexer
+	token Token
+}
+
+func (p *parser) advance() error {
+	token, err := p.lexer.Next()
+	if err != nil {
+		return err
+	}
+	p.token = token
+	return nil
+}
+
+// pipeline parses nodes separated by pipes, it stops at the first token which is not a pipe
+func (p *parser) pipeline(depth int) (*Pipeline, error) {
+	res := &Pipeline{}
+	for {
+		node, err := p.node(depth)
+		if err != nil {
+			return nil, err
+		}
+		res.Nodes = append(res.Nodes, node)
+		if p.token.Type != Pipe {
+			return res, nil
+		}
+		if err = p.advance(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// node parses a stage, a group or a tee
+func (p *parser) node(depth int) (Node, error) {
+	if p.token.Type == LParen {
+		return p.group(depth)
+	}
+	stage, err := p.stage()
+	if err != nil {
+		return nil, err
+	}
+	if p.token.Type != LParen {
+		return stage, nil
+	}
+	if stage.Function != "tee" || len(stage.Options) > 0 {
+		return nil, p.unexpected()
+	}
+	branch, err := p.group(depth)
+	if err != nil {
+		return nil, err
+	}
+	return &Tee{Branch: branch, Column: stage.Column}, nil
+}
+
+// group parses pipelines in parentheses separated by either & or &&
+func (p *parser) group(depth int) (*Group, error) {
+	if depth == maxDepth {
+		return nil, &SyntaxError{Column: p.token.Column, Msg: "groups are nested too deep"}
+	}
+	group := &Group{Column: p.token.Column}
+	var separator TokenType
+	for {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		branch, err := p.pipeline(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		group.Branches = append(group.Branches, branch)
+		switch p.token.Type {
+		case RParen:
+			if err = p.advance(); err != nil {
+				return nil, err
+			}
+			return group, nil
+		case Ampersand, DoubleAmpersand:
+			if separator != 0 && separator != p.token.Type {
+				return nil, &SyntaxError{Column: p.token.Column, Msg: "& and && can't be mixed in a group, use parentheses"}
+			}
+			separator = p.token.Type
+			if separator == DoubleAmpersand {
+				group.Merge = Interleave
+			}
+		case EOF:
+			return nil, &SyntaxError{Column: group.Column, Msg: "unclosed ("}
+		default:
+			return nil, p.unexpected()
+		}
+	}
+}
+
+// stage parses a function name and its options
+func (p *parser) stage() (*Stage, error) {
+	if p.token.Type != Word {
+		return nil, &SyntaxError{Column: p.token.Column, Msg: "expected function name, got " + p.token.Type.String()}
+	}
+	if !validFunction(p.token.Value) {
+		return nil, &SyntaxError{Column: p.token.Column, Msg: "invalid function name " + Quote(p.token.Value)}
+	}
+	stage := &Stage{Function: p.token.Value, Column: p.token.Column}
+	for {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.token.Type != Word {
+			return stage, nil
+		}
+		stage.Options = append(stage.Options, p.token.Value)
+	}
+}
+
+func (p *parser) unexpected() error {
+	return &SyntaxError{Column: p.token.Column, Msg: "unexpected " + p.token.Type.String()}
+}
+
+// validFunction returns true for function ids, they are host names optionally prefixed with a transport and followed by a port
+func validFunction(name string) bool {
+	if name == "" || name[0] == '-' {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:/", c)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package expression_test
+
+import (
+	. "github.com/trusch/btrfaas/expression"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parser", func() {
+	It("should parse chains and options", func() {
+		for _, c := range []struct {
+			input   string
+			chain   []string
+			options [][]string
+		}{
+			{"echo", []string{"echo"}, [][]string{nil}},
+			{" sed -e s/a/b/ |to-upper|  echo ", []string{"sed", "to-upper", "echo"}, [][]string{{"-e", "s/a/b/"}, nil, nil}},
+			{`sed -e 's/a b/c | d/'`, []string{"sed"}, [][]string{{"-e", "s/a b/c | d/"}}},
+			{`echo "a \"b\" \\ \n" x"y"z`, []string{"echo"}, [][]string{{`a "b" \ \n`, "xyz"}}},
+			{`echo a\ b \| \'`, []string{"echo"}, [][]string{{"a b", "|", "'"}}},
+			{`echo '' ""`, []string{"echo"}, [][]string{{"", ""}}},
+			{"grpc://echo:2424 | http://web", []string{"grpc://echo:2424", "http://web"}, [][]string{nil, nil}},
+		} {
+			expr, err := Parse(c.input)
+			Expect(err).NotTo(HaveOccurred(), c.input)
+			Expect(expr.Chain()).To(Equal(c.chain), c.input)
+			Expect(expr.Options()).To(Equal(c.options), c.input)
+		}
+	})
+
+	It("should report the column of syntax errors", func() {
+		for _, c := range []struct {
+			input  string
+			column int
+			msg    string
+		}{
+			{"  ", 1, "empty expression"},
+			{"| echo", 1, "expected function name"},
+			{"echo || sed", 7, "expected function name"},
+			{"echo |", 7, "expected function name"},
+			{"sed -e 's/a/b/", 8, "unterminated single quote"},
+			{`echo "abc`, 6, "unterminated double quote"},
+			{`echo a\`, 7, "trailing backslash"},
+			{"echo | 'to upper'", 8, "invalid function name"},
+			{"-e echo", 1, "invalid function name"},
+			{"echo ä | 'ö", 10, "unterminated single quote"},
+		} {
+			_, err := Parse(c.input)
+			Expect(err).To(HaveOccurred(), c.input)
+			syntaxErr, ok := err.(*SyntaxError)
+			Expect(ok).To(BeTrue(), c.input)
+			Expect(syntaxErr.Column).To(Equal(c.column), c.input)
+			Expect(syntaxErr.Msg).To(ContainSubstring(c.msg), c.input)
+		}
+	})
+
+	It("should quote options in the canonical form", func() {
+		expr, err := Parse(`sed   -e "s/a b/it's/"   |to-upper ''`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.String()).To(Equal(`sed -e 's/a b/it'\''s/' | to-upper ''`))
+		again, err := Parse(expr.String())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again.Options()).To(Equal(expr.Options()))
+	})
+
+	It("should keep the column of the stages", func() {
+		expr, err := Parse("echo | sed")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.Stages()[0].Column).To(Equal(1))
+		Expect(expr.Stages()[1].Column).To(Equal(8))
+	})
+
+	It("should parse groups and tees", func() {
+		expr, err := Parse("cat|(to-upper&sed -e 's/a/b/')|tee (audit&&log)|wc")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.Linear()).To(BeFalse())
+		Expect(expr.Chain()).To(Equal([]string{"cat", "to-upper", "sed", "audit", "log", "wc"}))
+		Expect(expr.Options()[2]).To(Equal([]string{"-e", "s/a/b/"}))
+		Expect(expr.String()).To(Equal("cat | (to-upper & sed -e s/a/b/) | tee (audit && log) | wc"))
+
+		nodes := expr.Pipeline.Nodes
+		Expect(nodes).To(HaveLen(4))
+		group, ok := nodes[1].(*Group)
+		Expect(ok).To(BeTrue())
+		Expect(group.Merge).To(Equal(Concat))
+		Expect(group.Branches).To(HaveLen(2))
+		tee, ok := nodes[2].(*Tee)
+		Expect(ok).To(BeTrue())
+		Expect(tee.Branch.Merge).To(Equal(Interleave))
+	})
+
+	It("should parse nested groups and treat tee without a group as a function", func() {
+		expr, err := Parse("(a | (b && c) & d) | tee")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.Chain()).To(Equal([]string{"a", "b", "c", "d", "tee"}))
+		_, ok := expr.Pipeline.Nodes[1].(*Stage)
+		Expect(ok).To(BeTrue())
+		Expect(expr.String()).To(Equal("(a | (b && c) & d) | tee"))
+	})
+
+	It("should quote operators in options", func() {
+		expr, err := Parse(`echo '(a & b)' "&&"`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.Linear()).To(BeTrue())
+		Expect(expr.Options()[0]).To(Equal([]string{"(a & b)", "&&"}))
+		Expect(expr.String()).To(Equal(`echo '(a & b)' '&&'`))
+	})
+})
@@ -0,0 +1,35 @@
+package expression
+
+import (
+	"fmt"
+	"reflect"
+	"unicode/utf8"
+)
+
+// checkRoundTrip checks the invariants Fuzz tests: errors must point into the input and the canonical form of a parsed
+// expression, which quotes its options with Quote, must parse to the same chain. It returns false if input doesn't parse.
+func checkRoundTrip(input string) (bool, error) {
+	expr, err := Parse(input)
+	if err != nil {
+		syntaxErr, ok := err.(*SyntaxError)
+		if !ok {
+			return false, fmt.Errorf("unexpected error type %T", err)
+		}
+		if syntaxErr.Column < 1 || syntaxErr.Column > utf8.RuneCountInString(input)+1 {
+			return false, fmt.Errorf("column out of range: %v", syntaxErr)
+		}
+		return false, nil
+	}
+	canonical := expr.String()
+	again, err := Parse(canonical)
+	if err != nil {
+		return true, fmt.Errorf("canonical form %q of %q doesn't parse: %v", canonical, input, err)
+	}
+	if !reflect.DeepEqual(expr.Chain(), again.Chain()) || !reflect.DeepEqual(expr.Options(), again.Options()) {
+		return true, fmt.Errorf("canonical form %q of %q changed the expression", canonical, input)
+	}
+	if again.String() != canonical {
+		return true, fmt.Errorf("canonical form %q is not stable", canonical)
+	}
+	return true, nil
+}
@@ -0,0 +1,56 @@
+package expression
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// the fuzz corpus and these inputs run through the checks of Fuzz with every go test
+var roundTripSeeds = []string{
+	"",
+	"|",
+	"a |",
+	"(",
+	"(a & b",
+	"a & b",
+	"(a & b && c)",
+	"tee",
+	"tee (",
+	"a | tee (b | c) | d",
+	"((a & b) && (c | d)) | e",
+	`sed -e 's/|/&/' | grep "(x)"`,
+	`echo "it's" 'say "hi"' \'`,
+	`echo '' "" x\ y`,
+	`echo "\\" '\'`,
+	"echo ünïcödé 'ü x' \"\t\"",
+	"grpc://echo:2424 --flag=a|b",
+	"echo 'unterminated",
+	`echo "unterminated \"`,
+	"a\nb",
+}
+
+var _ = Describe("RoundTrip", func() {
+	It("should keep the fuzz invariants for the seed corpus", func() {
+		files, err := filepath.Glob(filepath.Join("testdata", "fuzz", "corpus", "*"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).NotTo(BeEmpty())
+		inputs := append([]string{}, roundTripSeeds...)
+		for _, file := range files {
+			bs, err := ioutil.ReadFile(file)
+			Expect(err).NotTo(HaveOccurred())
+			inputs = append(inputs, string(bs))
+		}
+		parsed := 0
+		for _, input := range inputs {
+			ok, err := checkRoundTrip(input)
+			Expect(err).NotTo(HaveOccurred(), "input %q", input)
+			if ok {
+				parsed++
+			}
+		}
+		Expect(parsed).To(BeNumerically(">", len(inputs)/2))
+	})
+})
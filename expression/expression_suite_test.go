@@ -0,0 +1,13 @@
+package expression_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestExpression(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Expression Suite")
+}
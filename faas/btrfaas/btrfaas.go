@@ -9,18 +9,29 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
+	"time"
 
 	g "google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
+	log "github.com/Sirupsen/logrus"
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/trusch/btrfaas/apikey"
 	"github.com/trusch/btrfaas/deployment"
+	"github.com/trusch/btrfaas/expression"
 	"github.com/trusch/btrfaas/faas"
+	"github.com/trusch/btrfaas/fgateway/authz"
 	"github.com/trusch/btrfaas/fgateway/grpc"
+	"github.com/trusch/btrfaas/pipeline"
 	"github.com/trusch/btrfaas/pki"
 )
 
+// stopTimeout covers the default shutdown grace period of frunner and fgateway (30s) plus the time to cancel calls
+const stopTimeout = 40 * time.Second
+
+// functionHealthCheck is the health check of functions which don't configure one, it needs the frunner of this version
+var functionHealthCheck = []string{"/bin/frunner", "--probe"}
+
 // BtrFaaS is the btrfaas implementation of the FaaS interface
 type BtrFaaS struct {
 	platform deployment.Platform
@@ -40,10 +51,19 @@ func (ptr *BtrFaaS) Init(ctx context.Context, options *faas.InitOptions) error {
 	if err != nil {
 		return err
 	}
-	if err = pkiManager.IssueServer(ctx, "fgateway"); err != nil {
+	if err = pkiManager.IssueServer(ctx, pki.GatewayID); err != nil {
+		return err
+	}
+	if err = pkiManager.IssueClient(ctx, pki.ClientID); err != nil {
+		return err
+	}
+	if err = ptr.deployDefaultSecret(ctx, options.PrepareEnvironmentOptions.ID, "fgateway-policy", []byte(authz.DefaultPolicy)); err != nil {
+		return err
+	}
+	if err = ptr.deployDefaultSecret(ctx, options.PrepareEnvironmentOptions.ID, apikey.Secret, []byte("tokens: []\n")); err != nil {
 		return err
 	}
-	if err = pkiManager.IssueClient(ctx, "client"); err != nil {
+	if err = ptr.deployDefaultSecret(ctx, options.PrepareEnvironmentOptions.ID, pipeline.Secret, []byte("pipelines: []\n")); err != nil {
 		return err
 	}
 	return ptr.deployFgateway(ctx, options.PrepareEnvironmentOptions.ID, options.GatewayImage)
@@ -78,6 +98,7 @@ func (ptr *BtrFaaS) DeployFunction(ctx context.Context, options *faas.DeployFunc
 		options.Secrets = make(map[string]string)
 	}
 	options.Secrets["btrfaas-ca-cert"] = "/run/secrets/btrfaas-ca-cert.pem"
+	options.Secrets["btrfaas-crl"] = "/run/secrets/btrfaas-crl.pem"
 	options.Secrets[options.ID+"-key"] = "/run/secrets/btrfaas-function-key.pem"
 	options.Secrets[options.ID+"-cert"] = "/run/secrets/btrfaas-function-cert.pem"
 	if options.Ports == nil {
@@ -93,7 +114,33 @@ func (ptr *BtrFaaS) DeployFunction(ctx context.Context, options *faas.DeployFunc
 		Container: 8080,
 		Host:      8080,
 	})
-	return ptr.platform.DeployService(ctx, &options.DeployServiceOptions)
+	if options.HealthCheck == nil {
+		options.HealthCheck = &deployment.HealthCheckConfig{Command: functionHealthCheck}
+	}
+	if options.StopTimeout == 0 {
+		options.StopTimeout = stopTimeout
+	}
+	if err := ptr.platform.DeployService(ctx, &options.DeployServiceOptions); err != nil {
+		return err
+	}
+	return ptr.waitForFunction(ctx, options)
+}
+
+// waitForFunction waits until the function passes its health check on platforms which run them
+func (ptr *BtrFaaS) waitForFunction(ctx context.Context, options *faas.DeployFunctionOptions) error {
+	waiter, ok := ptr.platform.(deployment.ServiceWaiter)
+	if !ok || options.Timeout <= 0 || options.HealthCheck.Disabled {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
+	if err := waiter.WaitForService(ctx, &deployment.WaitForServiceOptions{
+		EnvironmentID: options.EnvironmentID,
+		ID:            options.ID,
+	}); err != nil {
+		return fmt.Errorf("function %v is not serving after %v: %v", options.ID, options.Timeout, err)
+	}
+	return nil
 }
 
 // UndeployFunction undeploys a service from an environment
@@ -145,6 +192,11 @@ func (ptr *BtrFaaS) UndeploySecret(ctx context.Context, options *faas.UndeploySe
 	return ptr.platform.UndeploySecret(ctx, &options.UndeploySecretOptions)
 }
 
+// UpdateSecret replaces the value of a deployed secret with a new version
+func (ptr *BtrFaaS) UpdateSecret(ctx context.Context, options *faas.UpdateSecretOptions) error {
+	return ptr.platform.UpdateSecret(ctx, &options.UpdateSecretOptions)
+}
+
 // ListSecrets returns a list of all deployed secrets
 func (ptr *BtrFaaS) ListSecrets(ctx context.Context, options *faas.ListSecretsOptions) ([]*faas.SecretInfo, error) {
 	infos, err := ptr.platform.ListSecrets(ctx, &options.ListSecretsOptions)
@@ -160,52 +212,191 @@ func (ptr *BtrFaaS) ListSecrets(ctx context.Context, options *faas.ListSecretsOp
 
 // Invoke calls a function
 func (ptr *BtrFaaS) Invoke(ctx context.Context, options *faas.InvokeOptions) error {
-	chain, opts, err := createCallRequest(options.FunctionExpression)
+	expr, err := expression.Parse(options.FunctionExpression)
+	if err != nil {
+		return err
+	}
+	cli, err := newClient(options.EnvironmentID, options.GatewayAddress, options.User)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	return cli.RunExpression(ctx, expr, options.Input, options.Output)
+}
+
+// InvokeAsync submits a function call as job
+func (ptr *BtrFaaS) InvokeAsync(ctx context.Context, options *faas.InvokeOptions) (*faas.JobInfo, error) {
+	expr, err := expression.Parse(options.FunctionExpression)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := newClient(options.EnvironmentID, options.GatewayAddress, options.User)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	job, err := cli.SubmitJob(ctx, expr, options.Callback, options.Input)
+	if err != nil {
+		return nil, err
+	}
+	return &faas.JobInfo{Job: *job}, nil
+}
+
+// GetJob returns the state of a job
+func (ptr *BtrFaaS) GetJob(ctx context.Context, options *faas.JobOptions) (*faas.JobInfo, error) {
+	cli, err := newClient(options.EnvironmentID, options.GatewayAddress, options.User)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	job, err := cli.GetJob(ctx, options.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &faas.JobInfo{Job: *job}, nil
+}
+
+// ListJobs returns the jobs of the user
+func (ptr *BtrFaaS) ListJobs(ctx context.Context, options *faas.JobOptions) ([]*faas.JobInfo, error) {
+	cli, err := newClient(options.EnvironmentID, options.GatewayAddress, options.User)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	list, err := cli.ListJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*faas.JobInfo, len(list))
+	for idx, job := range list {
+		res[idx] = &faas.JobInfo{Job: *job}
+	}
+	return res, nil
+}
+
+// CancelJob cancels a pending or running job
+func (ptr *BtrFaaS) CancelJob(ctx context.Context, options *faas.JobOptions) (*faas.JobInfo, error) {
+	cli, err := newClient(options.EnvironmentID, options.GatewayAddress, options.User)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	job, err := cli.CancelJob(ctx, options.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &faas.JobInfo{Job: *job}, nil
+}
+
+// GetJobResult writes the output of a finished job to options.Output
+func (ptr *BtrFaaS) GetJobResult(ctx context.Context, options *faas.JobOptions) error {
+	cli, err := newClient(options.EnvironmentID, options.GatewayAddress, options.User)
 	if err != nil {
 		return err
 	}
+	defer cli.Close()
+	return cli.JobResult(ctx, options.ID, options.Output)
+}
+
+// RetryJob runs a failed job again with its stored input
+func (ptr *BtrFaaS) RetryJob(ctx context.Context, options *faas.JobOptions) (*faas.JobInfo, error) {
+	cli, err := newClient(options.EnvironmentID, options.GatewayAddress, options.User)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	job, err := cli.RetryJob(ctx, options.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &faas.JobInfo{Job: *job}, nil
+}
+
+// ListDeadLetters returns the failed jobs of the user which can be retried
+func (ptr *BtrFaaS) ListDeadLetters(ctx context.Context, options *faas.JobOptions) ([]*faas.DeadLetterInfo, error) {
+	cli, err := newClient(options.EnvironmentID, options.GatewayAddress, options.User)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	letters, err := cli.ListDeadLetters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*faas.DeadLetterInfo, len(letters))
+	for idx, letter := range letters {
+		res[idx] = &faas.DeadLetterInfo{DeadLetter: *letter}
+	}
+	return res, nil
+}
+
+// newClient connects to the gateway of the environment with the certificate of the user
+func newClient(env, address, user string) (*grpc.Client, error) {
 	// Create a certificate pool from the certificate authority
 	certPool := x509.NewCertPool()
 	home, err := homedir.Dir()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	ca, err := ioutil.ReadFile(filepath.Join(home, ".btrfaas", options.EnvironmentID, "ca-cert.pem"))
+	ca, err := ioutil.ReadFile(filepath.Join(home, ".btrfaas", env, "ca-cert.pem"))
 	if err != nil {
-		return fmt.Errorf("could not read ca certificate: %s", err)
+		return nil, fmt.Errorf("could not read ca certificate: %s", err)
 	}
 
 	// Append the certificates from the CA
 	if ok := certPool.AppendCertsFromPEM(ca); !ok {
-		return errors.New("failed to append ca certs")
+		return nil, errors.New("failed to append ca certs")
 	}
 
-	creds := credentials.NewTLS(&tls.Config{
+	cfg := &tls.Config{
 		ServerName: "fgateway",
 		RootCAs:    certPool,
-	})
-	cli, err := grpc.NewClient(options.GatewayAddress, g.WithTransportCredentials(creds))
+	}
+	cert, err := loadClientCertificate(env, user)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
 	}
-	return cli.Run(ctx, chain, opts, options.Input, options.Output)
+	creds := credentials.NewTLS(cfg)
+	return grpc.NewClient(address, g.WithTransportCredentials(creds))
 }
 
-func createCallRequest(expr string) (chain []string, opts [][]string, err error) {
-	fnExpressions := strings.Split(expr, "|")
-	chain = make([]string, len(fnExpressions))
-	opts = make([][]string, len(fnExpressions))
-	for idx, fnExpression := range fnExpressions {
-		parts := strings.Fields(fnExpression)
-		if len(parts) < 1 {
-			return nil, nil, errors.New("malformed expression")
-		}
-		chain[idx] = parts[0]
-		if len(parts) > 1 {
-			opts[idx] = parts[1:]
-		}
+// loadClientCertificate loads the certificate of the user or the shared client certificate.
+// Environments created by older versions have no local copy of the shared client certificate, they are called without one.
+func loadClientCertificate(env, user string) (*tls.Certificate, error) {
+	certFile, keyFile, err := pki.ClientFiles(env, user)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil {
+		return &cert, nil
+	}
+	if user != "" {
+		return nil, fmt.Errorf("could not load the certificate of %v: %v", user, err)
+	}
+	if os.IsNotExist(err) {
+		log.Warnf("no client certificate found, run 'btrfaasctl pki rotate %v' to create one", pki.ClientID)
+		return nil, nil
 	}
-	return chain, opts, nil
+	return nil, fmt.Errorf("could not load the client certificate: %v", err)
+}
+
+// deployDefaultSecret deploys a secret of the gateway unless it exists already
+func (ptr *BtrFaaS) deployDefaultSecret(ctx context.Context, env, id string, value []byte) error {
+	if _, err := ptr.platform.GetSecret(ctx, &deployment.GetSecretOptions{
+		EnvironmentID: env,
+		ID:            id,
+	}); err == nil {
+		return nil
+	}
+	return ptr.platform.DeploySecret(ctx, &deployment.DeploySecretOptions{
+		EnvironmentID: env,
+		ID:            id,
+		Value:         value,
+	})
 }
 
 func (ptr *BtrFaaS) deployFgateway(ctx context.Context, env string, image string) error {
@@ -226,12 +417,23 @@ func (ptr *BtrFaaS) deployFgateway(ctx context.Context, env string, image string
 				Container: 2424,
 				Host:      2424,
 			},
+			{
+				Type:      "host",
+				Container: 8081,
+				Host:      8081,
+			},
 		},
-		Cmd: cmd,
+		Cmd:         cmd,
+		HealthCheck: &deployment.HealthCheckConfig{Command: []string{"fgateway", "probe"}},
+		StopTimeout: stopTimeout,
 		Secrets: deployment.LabelSet{
 			"btrfaas-ca-cert": "/run/secrets/btrfaas-ca-cert.pem",
+			"btrfaas-crl":     "/run/secrets/btrfaas-crl.pem",
 			"fgateway-cert":   "/run/secrets/fgateway-cert.pem",
 			"fgateway-key":    "/run/secrets/fgateway-key.pem",
+			"fgateway-policy": "/run/secrets/fgateway-policy.yaml",
+			"fgateway-tokens": "/run/secrets/fgateway-tokens.yaml",
+			pipeline.Secret:   "/run/secrets/fgateway-pipelines.yaml",
 			"client-cert":     "/run/secrets/client-cert.pem",
 			"client-key":      "/run/secrets/client-key.pem",
 		},
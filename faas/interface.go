@@ -3,8 +3,10 @@ package faas
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/trusch/btrfaas/deployment"
+	"github.com/trusch/btrfaas/fgateway/jobs"
 )
 
 // FaaS is the interface for a function-as-a-service platform
@@ -16,6 +18,27 @@ type FaaS interface {
 	// Invoke performs a function call against the faas
 	Invoke(ctx context.Context, options *InvokeOptions) error
 
+	// InvokeAsync submits a function call as job, the output is kept until it is fetched with GetJobResult
+	InvokeAsync(ctx context.Context, options *InvokeOptions) (*JobInfo, error)
+
+	// GetJob returns the state of a job
+	GetJob(ctx context.Context, options *JobOptions) (*JobInfo, error)
+
+	// ListJobs returns the jobs of the user
+	ListJobs(ctx context.Context, options *JobOptions) ([]*JobInfo, error)
+
+	// CancelJob cancels a pending or running job
+	CancelJob(ctx context.Context, options *JobOptions) (*JobInfo, error)
+
+	// GetJobResult writes the output of a finished job to options.Output
+	GetJobResult(ctx context.Context, options *JobOptions) error
+
+	// RetryJob runs a failed job again with its stored input
+	RetryJob(ctx context.Context, options *JobOptions) (*JobInfo, error)
+
+	// ListDeadLetters returns the failed jobs of the user which can be retried
+	ListDeadLetters(ctx context.Context, options *JobOptions) ([]*DeadLetterInfo, error)
+
 	// Teardown cleans the FaaS completely
 	Teardown(ctx context.Context, options *TeardownOptions) error
 
@@ -37,6 +60,9 @@ type FaaS interface {
 	// UndeploySecret unddeploys a secret from an environment
 	UndeploySecret(ctx context.Context, options *UndeploySecretOptions) error
 
+	// UpdateSecret replaces the value of a deployed secret with a new version
+	UpdateSecret(ctx context.Context, options *UpdateSecretOptions) error
+
 	// ListSecrets returns a list of all deployed secrets
 	ListSecrets(ctx context.Context, options *ListSecretsOptions) ([]*SecretInfo, error)
 }
@@ -55,6 +81,8 @@ type TeardownOptions struct {
 // DeployFunctionOptions contains the options for the DeployFunction call
 type DeployFunctionOptions struct {
 	deployment.DeployServiceOptions `yaml:",inline"`
+	// Timeout is how long DeployFunction waits until the function passes its health check, 0 doesn't wait
+	Timeout time.Duration `yaml:"-"`
 }
 
 // UndeployFunctionOptions contains the options for the UndeployFunction call
@@ -82,6 +110,11 @@ type UndeploySecretOptions struct {
 	deployment.UndeploySecretOptions `yaml:",inline"`
 }
 
+// UpdateSecretOptions contains the options for the UpdateSecret call
+type UpdateSecretOptions struct {
+	deployment.UpdateSecretOptions `yaml:",inline"`
+}
+
 // ListSecretsOptions contains the options for the ListSecrets call
 type ListSecretsOptions struct {
 	deployment.ListSecretsOptions `yaml:",inline"`
@@ -102,6 +135,27 @@ type InvokeOptions struct {
 	EnvironmentID      string
 	GatewayAddress     string
 	FunctionExpression string
+	User               string // user whose client certificate is presented, the shared one if empty
+	Callback           string // URL or function expression which InvokeAsync notifies when the job is done
 	Input              io.Reader
 	Output             io.Writer
 }
+
+// JobOptions are the options for the job calls, ID is ignored by ListJobs and Output is only used by GetJobResult
+type JobOptions struct {
+	EnvironmentID  string
+	GatewayAddress string
+	User           string // user whose client certificate is presented, the shared one if empty
+	ID             string
+	Output         io.Writer
+}
+
+// JobInfo contains infos about an asynchronous function call
+type JobInfo struct {
+	jobs.Job `yaml:",inline"`
+}
+
+// DeadLetterInfo contains infos about a failed asynchronous function call
+type DeadLetterInfo struct {
+	jobs.DeadLetter `yaml:",inline"`
+}
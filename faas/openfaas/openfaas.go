@@ -2,6 +2,7 @@ package openfaas
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,9 @@ import (
 
 const trueString = "true"
 
+// errJobsNotSupported is returned by the job calls, openfaas has its own async mechanism
+var errJobsNotSupported = errors.New("asynchronous calls are not supported by openfaas")
+
 // OpenFaaS is the btrfaas implementation of the FaaS interface
 type OpenFaaS struct {
 	platform deployment.Platform
@@ -109,6 +113,11 @@ func (ptr *OpenFaaS) UndeploySecret(ctx context.Context, options *faas.UndeployS
 	return ptr.platform.UndeploySecret(ctx, &options.UndeploySecretOptions)
 }
 
+// UpdateSecret replaces the value of a deployed secret with a new version
+func (ptr *OpenFaaS) UpdateSecret(ctx context.Context, options *faas.UpdateSecretOptions) error {
+	return ptr.platform.UpdateSecret(ctx, &options.UpdateSecretOptions)
+}
+
 // ListSecrets returns a list of all deployed secrets
 func (ptr *OpenFaaS) ListSecrets(ctx context.Context, options *faas.ListSecretsOptions) ([]*faas.SecretInfo, error) {
 	infos, err := ptr.platform.ListSecrets(ctx, &options.ListSecretsOptions)
@@ -136,3 +145,38 @@ func (ptr *OpenFaaS) Invoke(ctx context.Context, options *faas.InvokeOptions) er
 	}
 	return nil
 }
+
+// InvokeAsync is not supported
+func (ptr *OpenFaaS) InvokeAsync(ctx context.Context, options *faas.InvokeOptions) (*faas.JobInfo, error) {
+	return nil, errJobsNotSupported
+}
+
+// GetJob is not supported
+func (ptr *OpenFaaS) GetJob(ctx context.Context, options *faas.JobOptions) (*faas.JobInfo, error) {
+	return nil, errJobsNotSupported
+}
+
+// ListJobs is not supported
+func (ptr *OpenFaaS) ListJobs(ctx context.Context, options *faas.JobOptions) ([]*faas.JobInfo, error) {
+	return nil, errJobsNotSupported
+}
+
+// CancelJob is not supported
+func (ptr *OpenFaaS) CancelJob(ctx context.Context, options *faas.JobOptions) (*faas.JobInfo, error) {
+	return nil, errJobsNotSupported
+}
+
+// GetJobResult is not supported
+func (ptr *OpenFaaS) GetJobResult(ctx context.Context, options *faas.JobOptions) error {
+	return errJobsNotSupported
+}
+
+// RetryJob is not supported
+func (ptr *OpenFaaS) RetryJob(ctx context.Context, options *faas.JobOptions) (*faas.JobInfo, error) {
+	return nil, errJobsNotSupported
+}
+
+// ListDeadLetters is not supported
+func (ptr *OpenFaaS) ListDeadLetters(ctx context.Context, options *faas.JobOptions) ([]*faas.DeadLetterInfo, error) {
+	return nil, errJobsNotSupported
+}
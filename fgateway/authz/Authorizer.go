@@ -0,0 +1,137 @@
+package authz
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DeniedError is returned if the caller isn't allowed to call a function
+type DeniedError struct {
+	Identity *Identity
+	Function string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("%v is not allowed to call %v", e.Identity, e.Function)
+}
+
+// Authorizer checks calls against the policy of a file and reloads it when the file changes
+type Authorizer struct {
+	path   string
+	mutex  sync.RWMutex
+	raw    []byte
+	policy *Policy
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// NewAuthorizer loads the policy file, it falls back to <path>/value like the kubernetes secret layout
+func NewAuthorizer(path string) (*Authorizer, error) {
+	a := &Authorizer{path: path, stop: make(chan struct{})}
+	if _, err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the policy file again and swaps the policy if it changed, a broken policy keeps the current one
+func (a *Authorizer) Reload() (bool, error) {
+	bs, err := readFile(a.path)
+	if err != nil {
+		return false, fmt.Errorf("could not read policy: %v", err)
+	}
+	a.mutex.RLock()
+	unchanged := a.policy != nil && bytes.Equal(a.raw, bs)
+	a.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	policy, err := ParsePolicy(bs)
+	if err != nil {
+		return false, fmt.Errorf("%v: %v", a.path, err)
+	}
+	a.mutex.Lock()
+	a.raw, a.policy = bs, policy
+	a.mutex.Unlock()
+	return true, nil
+}
+
+// Watch checks the policy file for changes every interval until Close is called
+func (a *Authorizer) Watch(interval time.Duration) {
+	watch(interval, a.stop, a.Reload, "authorization policy "+a.path)
+}
+
+// Close stops watching the policy file
+func (a *Authorizer) Close() {
+	a.once.Do(func() {
+		close(a.stop)
+	})
+}
+
+// Authorize checks if the caller is allowed to call all functions and logs the decision
+func (a *Authorizer) Authorize(id *Identity, functions []string) error {
+	a.mutex.RLock()
+	policy := a.policy
+	a.mutex.RUnlock()
+	for _, function := range functions {
+		if !policy.Allowed(id, function) {
+			log.Warnf("authorization: denied %v to call %v", id, function)
+			return &DeniedError{Identity: id, Function: function}
+		}
+	}
+	log.Infof("authorization: allowed %v to call %v", id, functions)
+	return nil
+}
+
+// CheckScopes denies calls of functions which are not in the scopes of a token and logs the denial,
+// it is used where no policy applies
+func CheckScopes(id *Identity, functions []string) error {
+	for _, function := range functions {
+		if !id.InScope(function) {
+			log.Warnf("authorization: denied %v to call %v", id, function)
+			return &DeniedError{Identity: id, Function: function}
+		}
+	}
+	return nil
+}
+
+// watch calls reload every interval until stop is closed
+func watch(interval time.Duration, stop chan struct{}, reload func() (bool, error), name string) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				changed, err := reload()
+				if err != nil {
+					log.Warnf("failed to reload %v: %v", name, err)
+				} else if changed {
+					log.Infof("reloaded %v", name)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// readFile reads a file, it falls back to <path>/value which is where kubernetes mounts secrets
+func readFile(path string) ([]byte, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		if value, e := ioutil.ReadFile(path + "/value"); e == nil {
+			return value, nil
+		}
+		return nil, err
+	}
+	return bs, nil
+}
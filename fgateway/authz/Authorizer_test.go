@@ -0,0 +1,76 @@
+package authz_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/trusch/btrfaas/fgateway/authz"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authorizer", func() {
+	var (
+		dir  string
+		file string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "authz")
+		Expect(err).NotTo(HaveOccurred())
+		file = filepath.Join(dir, "policy.yaml")
+		Expect(ioutil.WriteFile(file, []byte("rules:\n- users: [alice]\n  functions: [echo]\n"), 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	alice := &Identity{Name: "alice"}
+
+	It("should authorize whole chains", func() {
+		a, err := NewAuthorizer(file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(a.Authorize(alice, []string{"echo", "echo"})).To(Succeed())
+		err = a.Authorize(alice, []string{"echo", "upper"})
+		Expect(err).To(BeAssignableToTypeOf(&DeniedError{}))
+		Expect(err.(*DeniedError).Function).To(Equal("upper"))
+		Expect(err.Error()).To(Equal("alice is not allowed to call upper"))
+		Expect(a.Authorize(nil, []string{"echo"})).NotTo(Succeed())
+	})
+
+	It("should fail without a policy", func() {
+		_, err := NewAuthorizer(filepath.Join(dir, "missing.yaml"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should read the kubernetes secret layout", func() {
+		Expect(os.Mkdir(filepath.Join(dir, "k8s.yaml"), 0700)).To(Succeed())
+		Expect(os.Rename(file, filepath.Join(dir, "k8s.yaml", "value"))).To(Succeed())
+		a, err := NewAuthorizer(filepath.Join(dir, "k8s.yaml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(a.Authorize(alice, []string{"echo"})).To(Succeed())
+	})
+
+	It("should reload the policy and keep it if the new one is broken", func() {
+		a, err := NewAuthorizer(file)
+		Expect(err).NotTo(HaveOccurred())
+		a.Watch(10 * time.Millisecond)
+		defer a.Close()
+
+		Expect(ioutil.WriteFile(file, []byte("rules:\n- users: [alice]\n  functions: [upper]\n"), 0600)).To(Succeed())
+		Eventually(func() error {
+			return a.Authorize(alice, []string{"upper"})
+		}).Should(Succeed())
+		Expect(a.Authorize(alice, []string{"echo"})).NotTo(Succeed())
+
+		Expect(ioutil.WriteFile(file, []byte("rules: ["), 0600)).To(Succeed())
+		_, err = a.Reload()
+		Expect(err).To(HaveOccurred())
+		Expect(a.Authorize(alice, []string{"upper"})).To(Succeed())
+	})
+})
@@ -0,0 +1,158 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultPolicy is deployed with a new environment, it allows the shared client certificate to call every function.
+// Anonymous callers are denied, a rule with anonymous: true opens functions to them.
+const DefaultPolicy = `rules:
+- users: [client]
+  functions: ["*"]
+`
+
+// Policy maps callers to the functions they are allowed to call, everything else is denied.
+// Callers authenticated by a token are only allowed to call the functions of its scopes.
+type Policy struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+// Rule allows the matching callers to call the given functions
+type Rule struct {
+	Users     []string `yaml:"users,omitempty"`     // common names of client certificates, "*" matches every authenticated caller
+	Groups    []string `yaml:"groups,omitempty"`    // organizations of client certificates
+	Anonymous bool     `yaml:"anonymous,omitempty"` // matches callers without a client certificate
+	Functions []string `yaml:"functions"`           // function ids or glob patterns like "dev-*"
+}
+
+// Identity is the authenticated caller of a function, nil means anonymous
+type Identity struct {
+	Name   string
+	Groups []string
+	Scopes []string // function patterns of a token, nil for certificates
+}
+
+func (id *Identity) String() string {
+	if id == nil {
+		return "anonymous"
+	}
+	if id.Scopes != nil {
+		return "token " + id.Name
+	}
+	if len(id.Groups) == 0 {
+		return id.Name
+	}
+	return fmt.Sprintf("%v (groups %v)", id.Name, strings.Join(id.Groups, ","))
+}
+
+// InScope returns true if the function is in the scopes of a token, callers without scopes are not restricted
+func (id *Identity) InScope(function string) bool {
+	if id == nil || id.Scopes == nil {
+		return true
+	}
+	return matches(id.Scopes, function)
+}
+
+// ParsePolicy parses and validates a YAML policy
+func ParsePolicy(bs []byte) (*Policy, error) {
+	policy := &Policy{}
+	if err := yaml.Unmarshal(bs, policy); err != nil {
+		return nil, err
+	}
+	for idx, rule := range policy.Rules {
+		if rule == nil {
+			return nil, fmt.Errorf("rule %v is empty", idx+1)
+		}
+		if err := validPatterns(rule.Functions); err != nil {
+			return nil, fmt.Errorf("rule %v: %v", idx+1, err)
+		}
+	}
+	return policy, nil
+}
+
+// Allowed returns true if a rule allows the caller to call the function
+func (p *Policy) Allowed(id *Identity, function string) bool {
+	if id != nil && id.Scopes != nil {
+		return id.InScope(function)
+	}
+	for _, rule := range p.Rules {
+		if rule.matchesCaller(id) && rule.matchesFunction(function) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesCaller(id *Identity) bool {
+	if id == nil {
+		return r.Anonymous
+	}
+	for _, user := range r.Users {
+		if user == "*" || user == id.Name {
+			return true
+		}
+	}
+	for _, group := range r.Groups {
+		for _, g := range id.Groups {
+			if group == g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesFunction(function string) bool {
+	return matches(r.Functions, function)
+}
+
+// matches returns true if one of the glob patterns matches the function
+func matches(patterns []string, function string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, function); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validPatterns returns an error for the first malformed glob pattern
+func validPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("malformed function pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// IdentityFromContext returns the identity of the verified client certificate of a gRPC call
+func IdentityFromContext(ctx context.Context) *Identity {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := info.State.VerifiedChains[0][0]
+	return &Identity{Name: cert.Subject.CommonName, Groups: cert.Subject.Organization}
+}
+
+// IdentityFromRequest returns the identity of the verified client certificate of a HTTP request
+func IdentityFromRequest(r *http.Request) *Identity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	return &Identity{Name: cert.Subject.CommonName, Groups: cert.Subject.Organization}
+}
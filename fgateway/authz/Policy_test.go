@@ -0,0 +1,86 @@
+package authz_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+
+	. "github.com/trusch/btrfaas/fgateway/authz"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	policy, err := ParsePolicy([]byte(`
+rules:
+- users: [alice]
+  functions: [echo]
+- groups: [dev]
+  functions: ["dev-*", upper]
+- users: ["*"]
+  functions: [public]
+- anonymous: true
+  functions: [status]
+`))
+
+	alice := &Identity{Name: "alice"}
+	bob := &Identity{Name: "bob", Groups: []string{"ops", "dev"}}
+
+	It("should parse the rules", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.Rules).To(HaveLen(4))
+	})
+
+	It("should allow users and groups", func() {
+		Expect(policy.Allowed(alice, "echo")).To(BeTrue())
+		Expect(policy.Allowed(bob, "echo")).To(BeFalse())
+		Expect(policy.Allowed(bob, "dev-echo")).To(BeTrue())
+		Expect(policy.Allowed(bob, "upper")).To(BeTrue())
+		Expect(policy.Allowed(alice, "dev-echo")).To(BeFalse())
+	})
+
+	It("should allow every authenticated user for *", func() {
+		Expect(policy.Allowed(alice, "public")).To(BeTrue())
+		Expect(policy.Allowed(bob, "public")).To(BeTrue())
+		Expect(policy.Allowed(nil, "public")).To(BeFalse())
+	})
+
+	It("should only allow anonymous callers what is explicitly allowed", func() {
+		Expect(policy.Allowed(nil, "status")).To(BeTrue())
+		Expect(policy.Allowed(nil, "echo")).To(BeFalse())
+		Expect(policy.Allowed(alice, "status")).To(BeFalse())
+	})
+
+	It("should deny everything without rules", func() {
+		empty, err := ParsePolicy([]byte(""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(empty.Allowed(alice, "echo")).To(BeFalse())
+		Expect(empty.Allowed(nil, "echo")).To(BeFalse())
+	})
+
+	It("should reject malformed patterns", func() {
+		_, err := ParsePolicy([]byte("rules:\n- users: [alice]\n  functions: [\"dev-[\"]\n"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should allow the shared client certificate by default", func() {
+		policy, err := ParsePolicy([]byte(DefaultPolicy))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.Allowed(&Identity{Name: "client"}, "echo")).To(BeTrue())
+		Expect(policy.Allowed(alice, "echo")).To(BeFalse())
+		Expect(policy.Allowed(nil, "echo")).To(BeFalse())
+	})
+
+	It("should identify callers by their verified client certificate", func() {
+		r := &http.Request{}
+		Expect(IdentityFromRequest(r)).To(BeNil())
+		r.TLS = &tls.ConnectionState{}
+		Expect(IdentityFromRequest(r)).To(BeNil())
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: "bob", Organization: []string{"ops", "dev"}}}
+		r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+		Expect(IdentityFromRequest(r)).To(Equal(bob))
+		Expect(bob.String()).To(Equal("bob (groups ops,dev)"))
+	})
+})
@@ -0,0 +1,164 @@
+package authz
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Token is an API key, only the hash of the key is stored
+type Token struct {
+	Name      string    `yaml:"name"`
+	Hash      string    `yaml:"hash"`      // hex encoded SHA-256 of the key
+	Functions []string  `yaml:"functions"` // function ids or glob patterns the key may call
+	Created   time.Time `yaml:"created"`
+}
+
+// TokenList is the content of a token file
+type TokenList struct {
+	Tokens []*Token `yaml:"tokens"`
+}
+
+// NewToken creates a token with a random key, the key is returned since only its hash is stored
+func NewToken(name string, functions []string) (*Token, string, error) {
+	if name == "" {
+		return nil, "", fmt.Errorf("a token needs a name")
+	}
+	if len(functions) == 0 {
+		return nil, "", fmt.Errorf("a token needs at least one function")
+	}
+	if err := validPatterns(functions); err != nil {
+		return nil, "", err
+	}
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		return nil, "", err
+	}
+	key := base64.RawURLEncoding.EncodeToString(bs)
+	return &Token{Name: name, Hash: hashKey(key), Functions: functions, Created: time.Now().UTC()}, key, nil
+}
+
+// ParseTokens parses and validates a YAML token file
+func ParseTokens(bs []byte) (*TokenList, error) {
+	list := &TokenList{}
+	if err := yaml.Unmarshal(bs, list); err != nil {
+		return nil, err
+	}
+	for idx, token := range list.Tokens {
+		if token == nil || token.Name == "" {
+			return nil, fmt.Errorf("token %v has no name", idx+1)
+		}
+		if len(token.Hash) != 2*sha256.Size {
+			return nil, fmt.Errorf("token %v has a malformed hash", token.Name)
+		}
+		if err := validPatterns(token.Functions); err != nil {
+			return nil, fmt.Errorf("token %v: %v", token.Name, err)
+		}
+	}
+	return list, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenFromRequest returns the bearer token of the Authorization header
+func TokenFromRequest(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// TokenStore validates API keys against a token file and reloads it when the file changes.
+// A missing file means that there are no tokens.
+type TokenStore struct {
+	path   string
+	mutex  sync.RWMutex
+	raw    []byte
+	tokens []*Token
+	loaded bool
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// NewTokenStore loads the token file
+func NewTokenStore(path string) (*TokenStore, error) {
+	s := &TokenStore{path: path, stop: make(chan struct{})}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the token file again and swaps the tokens if it changed, a broken file keeps the current tokens
+func (s *TokenStore) Reload() (bool, error) {
+	bs, err := readFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("could not read tokens: %v", err)
+	}
+	s.mutex.RLock()
+	unchanged := s.loaded && bytes.Equal(s.raw, bs)
+	s.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	list, err := ParseTokens(bs)
+	if err != nil {
+		return false, fmt.Errorf("%v: %v", s.path, err)
+	}
+	s.mutex.Lock()
+	s.raw, s.tokens, s.loaded = bs, list.Tokens, true
+	s.mutex.Unlock()
+	return true, nil
+}
+
+// Watch checks the token file for changes every interval until Close is called
+func (s *TokenStore) Watch(interval time.Duration) {
+	watch(interval, s.stop, s.Reload, "tokens "+s.path)
+}
+
+// Close stops watching the token file
+func (s *TokenStore) Close() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+}
+
+// Authenticate returns the identity of an API key or nil if the key is unknown.
+// The hash of the key is compared with every token in constant time.
+func (s *TokenStore) Authenticate(key string) *Identity {
+	if key == "" {
+		return nil
+	}
+	hash := []byte(hashKey(key))
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var match *Token
+	for _, token := range s.tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(token.Hash)) == 1 {
+			match = token
+		}
+	}
+	if match == nil {
+		return nil
+	}
+	scopes := match.Functions
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return &Identity{Name: match.Name, Scopes: scopes}
+}
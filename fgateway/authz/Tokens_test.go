@@ -0,0 +1,102 @@
+package authz_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+
+	. "github.com/trusch/btrfaas/fgateway/authz"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tokens", func() {
+	var (
+		dir  string
+		file string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "authz")
+		Expect(err).NotTo(HaveOccurred())
+		file = filepath.Join(dir, "tokens.yaml")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	writeTokens := func(tokens ...*Token) {
+		bs, err := yaml.Marshal(&TokenList{Tokens: tokens})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(file, bs, 0600)).To(Succeed())
+	}
+
+	It("should authenticate keys and restrict them to their scopes", func() {
+		webhook, key, err := NewToken("webhook", []string{"echo", "dev-*"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(webhook.Hash).NotTo(ContainSubstring(key))
+		other, _, err := NewToken("other", []string{"*"})
+		Expect(err).NotTo(HaveOccurred())
+		writeTokens(other, webhook)
+
+		store, err := NewTokenStore(file)
+		Expect(err).NotTo(HaveOccurred())
+		id := store.Authenticate(key)
+		Expect(id).NotTo(BeNil())
+		Expect(id.Name).To(Equal("webhook"))
+		Expect(id.String()).To(Equal("token webhook"))
+		Expect(CheckScopes(id, []string{"echo", "dev-upper"})).To(Succeed())
+		Expect(CheckScopes(id, []string{"echo", "upper"})).To(BeAssignableToTypeOf(&DeniedError{}))
+		Expect(store.Authenticate(key + "x")).To(BeNil())
+		Expect(store.Authenticate("")).To(BeNil())
+	})
+
+	It("should apply the scopes instead of the policy", func() {
+		policy, err := ParsePolicy([]byte(DefaultPolicy))
+		Expect(err).NotTo(HaveOccurred())
+		id := &Identity{Name: "client", Scopes: []string{"echo"}}
+		Expect(policy.Allowed(id, "echo")).To(BeTrue())
+		Expect(policy.Allowed(id, "upper")).To(BeFalse())
+	})
+
+	It("should treat a missing file as no tokens and pick up new ones", func() {
+		store, err := NewTokenStore(file)
+		Expect(err).NotTo(HaveOccurred())
+		token, key, err := NewToken("webhook", []string{"echo"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.Authenticate(key)).To(BeNil())
+		writeTokens(token)
+		Expect(store.Reload()).To(BeTrue())
+		Expect(store.Authenticate(key)).NotTo(BeNil())
+
+		Expect(ioutil.WriteFile(file, []byte("tokens:\n- name: broken\n  hash: abc\n"), 0600)).To(Succeed())
+		_, err = store.Reload()
+		Expect(err).To(HaveOccurred())
+		Expect(store.Authenticate(key)).NotTo(BeNil())
+	})
+
+	It("should validate new tokens", func() {
+		_, _, err := NewToken("", []string{"echo"})
+		Expect(err).To(HaveOccurred())
+		_, _, err = NewToken("webhook", nil)
+		Expect(err).To(HaveOccurred())
+		_, _, err = NewToken("webhook", []string{"["})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should read bearer tokens", func() {
+		r, err := http.NewRequest("POST", "/", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(TokenFromRequest(r)).To(BeEmpty())
+		r.Header.Set("Authorization", "Bearer abc")
+		Expect(TokenFromRequest(r)).To(Equal("abc"))
+		r.Header.Set("Authorization", "Basic abc")
+		Expect(TokenFromRequest(r)).To(BeEmpty())
+	})
+})
@@ -21,20 +21,38 @@
 package cmd
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/trusch/btrfaas/fgateway/authz"
+	"github.com/trusch/btrfaas/fgateway/forwarder"
 	"github.com/trusch/btrfaas/fgateway/grpc"
+	gatewayhttp "github.com/trusch/btrfaas/fgateway/http"
+	"github.com/trusch/btrfaas/fgateway/jobs"
 	"github.com/trusch/btrfaas/fgateway/metrics"
+	"github.com/trusch/btrfaas/health"
+	"github.com/trusch/btrfaas/pipeline"
+	"github.com/trusch/btrfaas/shutdown"
+	"github.com/trusch/btrfaas/tlsconfig"
+	"github.com/trusch/btrfaas/trace"
 )
 
-var cfgFile string
+var (
+	cfgFile   string
+	serverTLS = tlsconfig.New("fgateway")
+	clientTLS = tlsconfig.New("client")
+)
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
@@ -53,25 +71,313 @@ var RootCmd = &cobra.Command{
 		case "debug":
 			log.SetLevel(log.DebugLevel)
 		}
+		var exporter *trace.OTLPExporter
+		if endpoint, _ := cmd.Flags().GetString("otlp-endpoint"); endpoint != "" {
+			log.Infof("export traces to %v", endpoint)
+			exporter = trace.NewOTLPExporter(endpoint, "fgateway")
+			trace.SetExporter(exporter)
+		}
+		if err := setupTLS(cmd); err != nil {
+			log.Fatal(err)
+		}
+		if err := setupRetryPolicy(cmd); err != nil {
+			log.Fatal(err)
+		}
+		if err := setupClientPool(cmd); err != nil {
+			log.Fatal(err)
+		}
+		authorizer, err := setupAuthorizer(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tokens, err := setupTokens(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pipelines, err := setupPipelines(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		jobManager, err := setupJobs(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
 		go runMetricsServer(cmd)
-		go runGRPCServer(cmd)
-		select {}
+		go runHealthServer(cmd)
+		servers := []shutdown.Server{runGRPCServer(cmd, authorizer, pipelines, jobManager)}
+		if httpServer := runHTTPServer(cmd, authorizer, tokens, pipelines, jobManager); httpServer != nil {
+			servers = append(servers, httpServer)
+		}
+		health.SetServing(true)
+
+		shutdown.Wait()
+		gracePeriod, _ := cmd.Flags().GetDuration("shutdown-grace-period")
+		log.Infof("waiting up to %v for running calls", gracePeriod)
+		shutdown.Drain(gracePeriod, servers...)
+		if jobManager != nil {
+			// running jobs are marked as failed, they are not resumed after a restart
+			jobManager.Close()
+		}
+		forwarder.DefaultClientPool.Close()
+		if authorizer != nil {
+			authorizer.Close()
+		}
+		if tokens != nil {
+			tokens.Close()
+		}
+		if pipelines != nil {
+			pipelines.Close()
+		}
+		if exporter != nil {
+			exporter.Flush()
+		}
 	},
 }
 
+// setupTLS applies --insecure and the environment variables FGATEWAY_TLS_* and FGATEWAY_CLIENT_TLS_*
+func setupTLS(cmd *cobra.Command) error {
+	if insecure, _ := cmd.Flags().GetBool("insecure"); insecure {
+		serverTLS.Insecure = true
+		clientTLS.Insecure = true
+	}
+	if err := serverTLS.LoadEnvironment("FGATEWAY_TLS"); err != nil {
+		return err
+	}
+	if err := clientTLS.LoadEnvironment("FGATEWAY_CLIENT_TLS"); err != nil {
+		return err
+	}
+	if serverTLS.Insecure {
+		log.Warn("serving function calls without TLS")
+	}
+	if clientTLS.Insecure {
+		log.Warn("calling functions without TLS")
+	}
+	return nil
+}
+
+// setupAuthorizer loads the authorization policy, an empty --policy disables authorization
+func setupAuthorizer(cmd *cobra.Command) (*authz.Authorizer, error) {
+	policy, _ := cmd.Flags().GetString("policy")
+	if policy == "" {
+		log.Warn("authorization is disabled, every caller may call every function")
+		return nil, nil
+	}
+	authorizer, err := authz.NewAuthorizer(policy)
+	if err != nil {
+		return nil, err
+	}
+	interval, _ := cmd.Flags().GetDuration("policy-reload-interval")
+	authorizer.Watch(interval)
+	return authorizer, nil
+}
+
+// setupTokens loads the API keys of the http dispatcher, an empty --tokens disables them
+func setupTokens(cmd *cobra.Command) (*authz.TokenStore, error) {
+	file, _ := cmd.Flags().GetString("tokens")
+	if file == "" {
+		return nil, nil
+	}
+	tokens, err := authz.NewTokenStore(file)
+	if err != nil {
+		return nil, err
+	}
+	interval, _ := cmd.Flags().GetDuration("policy-reload-interval")
+	tokens.Watch(interval)
+	return tokens, nil
+}
+
+// setupPipelines loads the deployed pipelines, an empty --pipelines disables them
+func setupPipelines(cmd *cobra.Command) (*pipeline.Store, error) {
+	file, _ := cmd.Flags().GetString("pipelines")
+	if file == "" {
+		return nil, nil
+	}
+	pipelines, err := pipeline.NewStore(file)
+	if err != nil {
+		return nil, err
+	}
+	interval, _ := cmd.Flags().GetDuration("policy-reload-interval")
+	pipelines.Watch(interval)
+	return pipelines, nil
+}
+
+// jobCleanupInterval is how often expired jobs are removed
+const jobCleanupInterval = 10 * time.Minute
+
+// setupJobs creates the manager of asynchronous calls, an empty --job-dir disables them
+func setupJobs(cmd *cobra.Command) (*jobs.Manager, error) {
+	dir, _ := cmd.Flags().GetString("job-dir")
+	if dir == "" {
+		return nil, nil
+	}
+	store, err := jobs.NewFileStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	concurrency, _ := cmd.Flags().GetInt("job-concurrency")
+	grpcPort, _ := cmd.Flags().GetUint16("grpc-default-port")
+	manager := jobs.NewManager(store, jobs.ForwardFunc(grpcPort), concurrency)
+	if hosts, _ := cmd.Flags().GetString("callback-hosts"); hosts != "" {
+		if err = manager.SetCallbackHosts(strings.Split(hosts, ",")); err != nil {
+			return nil, err
+		}
+	}
+	retention, _ := cmd.Flags().GetDuration("job-retention")
+	deadLetterRetention, _ := cmd.Flags().GetDuration("dead-letter-retention")
+	manager.SetRetention(retention, deadLetterRetention)
+	maxInputSize, _ := cmd.Flags().GetInt64("job-max-input-size")
+	manager.SetMaxInputSize(maxInputSize)
+	if err = manager.Recover(); err != nil {
+		return nil, err
+	}
+	if err = manager.Cleanup(); err != nil {
+		return nil, err
+	}
+	manager.CleanupEvery(jobCleanupInterval)
+	return manager, nil
+}
+
+// setupRetryPolicy applies the --retry-* flags and the retry policies of single functions from --retry-policies
+func setupRetryPolicy(cmd *cobra.Command) error {
+	attempts, _ := cmd.Flags().GetInt("retry-attempts")
+	backoff, _ := cmd.Flags().GetDuration("retry-backoff")
+	maxBackoff, _ := cmd.Flags().GetDuration("retry-max-backoff")
+	bufferSize, _ := cmd.Flags().GetInt("retry-buffer-size")
+	codeList, _ := cmd.Flags().GetString("retry-codes")
+	codes, err := forwarder.ParseCodes(codeList)
+	if err != nil {
+		return err
+	}
+	forwarder.DefaultRetryPolicy = &forwarder.RetryPolicy{
+		Attempts:       attempts,
+		Backoff:        backoff,
+		MaxBackoff:     maxBackoff,
+		RetryableCodes: codes,
+		BufferSize:     bufferSize,
+	}
+	file, _ := cmd.Flags().GetString("retry-policies")
+	if file == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	policies, err := forwarder.ParseRetryPolicies(data, forwarder.DefaultRetryPolicy)
+	if err != nil {
+		return err
+	}
+	for name := range policies {
+		log.Infof("use own retry policy for %v", name)
+	}
+	forwarder.RetryPolicies = policies
+	return nil
+}
+
+func setupClientPool(cmd *cobra.Command) error {
+	maxConns, _ := cmd.Flags().GetInt("max-connections")
+	idleTimeout, _ := cmd.Flags().GetDuration("connection-idle-timeout")
+	healthCheckInterval, _ := cmd.Flags().GetDuration("health-check-interval")
+	reloader, err := tlsconfig.NewReloader(clientTLS)
+	if err != nil {
+		return err
+	}
+	pool := forwarder.NewClientPool(maxConns, idleTimeout, healthCheckInterval)
+	pool.SetCredentials(reloader.DialOption)
+	// new connections use the new client certificate, running calls finish on the old ones
+	reloader.OnReload(pool.Reconnect)
+	reloader.ExportExpiry(metrics.CertificateExpiry, "client")
+	reloader.Watch()
+	forwarder.DefaultClientPool.Close()
+	forwarder.DefaultClientPool = pool
+	return nil
+}
+
 func runMetricsServer(cmd *cobra.Command) {
 	httpAddr, _ := cmd.Flags().GetString("http-address")
-	handler := metrics.Handler()
+	mux := http.NewServeMux()
+	mux.Handle("/", metrics.Handler())
+	mux.Handle(health.Path, health.Handler())
 	log.Infof("start serving prometheus metrics on %v", httpAddr)
-	log.Fatal(http.ListenAndServe(httpAddr, handler))
+	log.Fatal(http.ListenAndServe(httpAddr, mux))
 }
 
-func runGRPCServer(cmd *cobra.Command) {
+// runHealthServer serves grpc.health.v1 for the probes of 'fgateway probe', an empty --health-address disables it
+func runHealthServer(cmd *cobra.Command) {
+	addr, _ := cmd.Flags().GetString("health-address")
+	if addr == "" {
+		return
+	}
+	log.Infof("start serving grpc.health.v1 for probes on %v", addr)
+	log.Fatal(health.ListenAndServe(addr))
+}
+
+func runGRPCServer(cmd *cobra.Command, authorizer *authz.Authorizer, pipelines *pipeline.Store, jobManager *jobs.Manager) *grpc.Server {
 	grpcAddr, _ := cmd.Flags().GetString("grpc-address")
 	grpcPort, _ := cmd.Flags().GetUint16("grpc-default-port")
-	server := grpc.NewServer(grpcAddr, grpcPort)
+	server := grpc.NewServer(grpcAddr, grpcPort, serverTLS)
+	server.SetAuthorizer(authorizer)
+	server.SetPipelines(pipelines)
+	server.SetJobs(jobManager)
+	requireClientCert, _ := cmd.Flags().GetBool("require-client-cert")
+	server.SetRequireClientCert(requireClientCert)
 	log.Infof("start function calls on %v", grpcAddr)
-	log.Fatal(server.ListenAndServe())
+	go func() {
+		// a graceful stop lets ListenAndServe return without error
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	return server
+}
+
+func runHTTPServer(cmd *cobra.Command, authorizer *authz.Authorizer, tokens *authz.TokenStore, pipelines *pipeline.Store, jobManager *jobs.Manager) *http.Server {
+	httpAddr, _ := cmd.Flags().GetString("dispatcher-address")
+	if httpAddr == "" {
+		return nil
+	}
+	grpcPort, _ := cmd.Flags().GetUint16("grpc-default-port")
+	requireClientCert, _ := cmd.Flags().GetBool("require-client-cert")
+	server := &http.Server{
+		Addr: httpAddr,
+		Handler: &gatewayhttp.FunctionDispatcher{
+			DefaultPort:           grpcPort,
+			Authorizer:            authorizer,
+			Tokens:                tokens,
+			RequireAuthentication: requireClientCert,
+			Pipelines:             pipelines,
+			Jobs:                  jobManager,
+		},
+	}
+	lis, err := listenHTTP(server)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("start serving function calls via http on %v", httpAddr)
+	go func() {
+		if err := server.Serve(lis); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	return server
+}
+
+// listenHTTP listens on the address of server with the certificate of the gateway, so that API keys don't cross the
+// network in plaintext. Callers may present a client certificate instead of an API key, calls without either are
+// rejected by the dispatcher if --require-client-cert is set. In insecure mode it listens without TLS.
+func listenHTTP(server *http.Server) (net.Listener, error) {
+	lis, err := net.Listen("tcp", server.Addr)
+	if err != nil || serverTLS.Insecure {
+		return lis, err
+	}
+	reloader, err := tlsconfig.NewReloader(serverTLS)
+	if err != nil {
+		lis.Close()
+		return nil, err
+	}
+	reloader.Watch()
+	server.TLSConfig = reloader.HTTPServerTLS(tls.VerifyClientCertIfGiven)
+	return tls.NewListener(lis, server.TLSConfig), nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -87,7 +393,34 @@ func init() {
 	cobra.OnInitialize(initConfig)
 	RootCmd.Flags().String("http-address", ":8000", "http listen address")
 	RootCmd.Flags().String("grpc-address", ":2424", "grpc listen address")
+	RootCmd.Flags().String("dispatcher-address", ":8081", "https listen address for function calls (plain http with --insecure), empty to disable")
 	RootCmd.Flags().Uint16("grpc-default-port", 2424, "grpc default port")
+	RootCmd.PersistentFlags().String("health-address", "127.0.0.1:2426", "listen address of the grpc.health.v1 service for probes in the container, empty to disable it")
+	RootCmd.Flags().String("otlp-endpoint", "", "OpenTelemetry collector to export traces to via OTLP/HTTP, like http://otel-collector:4318")
+	RootCmd.Flags().Int("retry-attempts", 3, "maximum number of attempts per function call, 1 disables retries")
+	RootCmd.Flags().Duration("retry-backoff", 100*time.Millisecond, "wait time before the first retry, doubled for every further retry")
+	RootCmd.Flags().Duration("retry-max-backoff", 2*time.Second, "maximum wait time between retries")
+	RootCmd.Flags().String("retry-codes", "UNAVAILABLE,RESOURCE_EXHAUSTED", "comma separated list of gRPC status codes which are retried")
+	RootCmd.Flags().Int("retry-buffer-size", 64<<10, "input bytes which are buffered per function call to be able to retry it")
+	RootCmd.Flags().String("retry-policies", "", "yaml file with retry policies of single functions, fields they don't set are taken from the --retry-* flags")
+	RootCmd.Flags().Int("max-connections", 100, "maximum number of pooled gRPC connections to functions, 0 means unbounded")
+	RootCmd.Flags().Duration("connection-idle-timeout", 5*time.Minute, "close connections to functions which are idle for this duration, 0 disables it")
+	RootCmd.Flags().Duration("health-check-interval", 10*time.Second, "interval of health checks and DNS lookups for pooled connections, 0 disables them")
+	RootCmd.Flags().String("policy", "/run/secrets/fgateway-policy.yaml", "authorization policy file, calls which it doesn't allow are denied, empty to disable authorization")
+	RootCmd.Flags().String("tokens", "/run/secrets/fgateway-tokens.yaml", "API keys of the http dispatcher, a missing file means there are none, empty to disable them")
+	RootCmd.Flags().String("pipelines", "/run/secrets/fgateway-pipelines.yaml", "deployed pipelines which can be called by their id, a missing file means there are none, empty to disable them")
+	RootCmd.Flags().Duration("policy-reload-interval", 10*time.Second, "how often the authorization policy, the API keys and the pipelines are checked for changes, 0 disables reloading")
+	RootCmd.Flags().String("job-dir", "/var/lib/btrfaas/jobs", "directory which keeps the input and output of asynchronous calls, mount a volume to keep them across restarts, empty to disable asynchronous calls")
+	RootCmd.Flags().Int("job-concurrency", 10, "maximum number of asynchronous calls which run at the same time")
+	RootCmd.Flags().Int64("job-max-input-size", 64<<20, "maximum input size of asynchronous calls in bytes, 0 for no limit")
+	RootCmd.Flags().Duration("job-retention", 24*time.Hour, "how long finished jobs are kept with their input and output, 0 to keep them forever")
+	RootCmd.Flags().Duration("dead-letter-retention", 7*24*time.Hour, "how long failed jobs are kept for retries, 0 to keep them forever")
+	RootCmd.Flags().String("callback-hosts", "", "comma separated list of hosts which callback URLs of jobs may point to, like hooks.example.com,*.example.org, empty denies all callback URLs")
+	RootCmd.Flags().Duration("shutdown-grace-period", 30*time.Second, "time running calls get to finish on SIGTERM before they are canceled")
+	serverTLS.AddFlags(RootCmd.Flags(), "tls", "grpc server")
+	clientTLS.AddFlags(RootCmd.Flags(), "client-tls", "function client")
+	RootCmd.Flags().Bool("require-client-cert", false, "reject callers without a client certificate signed by the CA, calls via the http dispatcher need a client certificate or an API key")
+	RootCmd.Flags().Bool("insecure", false, "use plaintext gRPC for function calls and connections to functions, for local development only")
 	RootCmd.PersistentFlags().String("log-level", "info", "loglevel: info, error, warn, debug")
 }
 
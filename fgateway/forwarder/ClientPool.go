@@ -0,0 +1,300 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	g "google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	_ "google.golang.org/grpc/balancer/roundrobin"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/trusch/btrfaas/fgateway/metrics"
+	"github.com/trusch/btrfaas/frunner/grpc"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+// ClientPool caches the gRPC connections to functions, it is safe for concurrent use.
+// Connections are health checked periodically, closed if they are idle for too long and replaced if the DNS records
+// of their function changed, so that new replicas are picked up.
+type ClientPool struct {
+	maxConns            int
+	idleTimeout         time.Duration
+	healthCheckInterval time.Duration
+	credentials         func(host string) (g.DialOption, error)
+
+	mutex sync.Mutex
+	conns map[string]*pooledClient
+	creds map[string]g.DialOption
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// pooledClient is a connection of the pool, all fields except the immutable ones are guarded by the pool mutex
+type pooledClient struct {
+	*grpc.Client
+	uri      string
+	host     string
+	conn     *g.ClientConn
+	addrs    []string
+	refs     int
+	lastUsed time.Time
+	broken   bool
+	closed   bool
+}
+
+// DefaultClientPool is used by Forward
+var DefaultClientPool = NewClientPool(100, 5*time.Minute, 10*time.Second)
+
+// NewClientPool creates a new pool with at most maxConns connections (0 means unbounded).
+// Idle connections are closed after idleTimeout, health checks and DNS lookups are done every healthCheckInterval.
+// Zero durations disable the respective feature.
+func NewClientPool(maxConns int, idleTimeout, healthCheckInterval time.Duration) *ClientPool {
+	p := &ClientPool{
+		maxConns:            maxConns,
+		idleTimeout:         idleTimeout,
+		healthCheckInterval: healthCheckInterval,
+		credentials:         getTransportCredentials,
+		conns:               make(map[string]*pooledClient),
+		creds:               make(map[string]g.DialOption),
+		stop:                make(chan struct{}),
+	}
+	if healthCheckInterval > 0 {
+		go p.maintain()
+	}
+	return p
+}
+
+// SetCredentials sets the function which creates the transport credentials for a host
+func (p *ClientPool) SetCredentials(credentials func(host string) (g.DialOption, error)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.credentials = credentials
+	p.creds = make(map[string]g.DialOption)
+}
+
+// Reconnect drops the cached credentials and replaces all connections, for example after the certificates changed.
+// Running calls finish on their current connection.
+func (p *ClientPool) Reconnect() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.creds = make(map[string]g.DialOption)
+	for _, c := range p.conns {
+		p.remove(c)
+	}
+}
+
+// Len returns the number of pooled connections
+func (p *ClientPool) Len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.conns)
+}
+
+// Close stops the maintenance and closes all connections
+func (p *ClientPool) Close() {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, c := range p.conns {
+		p.remove(c)
+	}
+}
+
+// get returns a client for the given host, it must be released after use
+func (p *ClientPool) get(host string, port uint16) (*pooledClient, error) {
+	uri := fmt.Sprintf("dns:///%v:%v", host, port)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if c, ok := p.conns[uri]; ok {
+		c.refs++
+		return c, nil
+	}
+	if p.maxConns > 0 && len(p.conns) >= p.maxConns && !p.evictLeastRecentlyUsed() {
+		return nil, status.Errorf(codes.ResourceExhausted, "connection limit of %v reached", p.maxConns)
+	}
+	creds, ok := p.creds[host]
+	if !ok {
+		var err error
+		if creds, err = p.credentials(host); err != nil {
+			return nil, fmt.Errorf("failed to get credentials for %v: %v", host, err)
+		}
+		p.creds[host] = creds
+	}
+	rr := balancer.Get("round_robin")
+	conn, err := g.Dial(uri, creds, g.WithBalancerBuilder(rr))
+	if err != nil {
+		return nil, err
+	}
+	c := &pooledClient{
+		Client:   grpc.NewClientFromConn(conn),
+		uri:      uri,
+		host:     host,
+		conn:     conn,
+		refs:     1,
+		lastUsed: time.Now(),
+	}
+	p.conns[uri] = c
+	metrics.SetConnections(len(p.conns))
+	log.Debugf("connected to %v", uri)
+	return c, nil
+}
+
+// release gives a client back to the pool, broken clients are replaced by a new connection on the next get
+func (p *ClientPool) release(c *pooledClient, broken bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	c.refs--
+	c.lastUsed = time.Now()
+	if broken {
+		log.Debugf("dropping connection to %v", c.uri)
+		p.markBroken(c)
+	}
+	if c.broken {
+		p.closeIfUnused(c)
+	}
+}
+
+// markBroken removes a client from the pool, it gets closed once it isn't used anymore.
+// The caller must hold the mutex.
+func (p *ClientPool) markBroken(c *pooledClient) {
+	if c.broken {
+		return
+	}
+	c.broken = true
+	if p.conns[c.uri] == c {
+		delete(p.conns, c.uri)
+		metrics.SetConnections(len(p.conns))
+	}
+}
+
+// remove removes a client from the pool and closes it if it isn't used. The caller must hold the mutex.
+func (p *ClientPool) remove(c *pooledClient) {
+	p.markBroken(c)
+	p.closeIfUnused(c)
+}
+
+// closeIfUnused closes the connection of a removed client once. The caller must hold the mutex.
+func (p *ClientPool) closeIfUnused(c *pooledClient) {
+	if c.refs == 0 && !c.closed {
+		c.closed = true
+		c.Close()
+	}
+}
+
+// evictLeastRecentlyUsed closes the idle connection which wasn't used for the longest time.
+// The caller must hold the mutex.
+func (p *ClientPool) evictLeastRecentlyUsed() bool {
+	var lru *pooledClient
+	for _, c := range p.conns {
+		if c.refs == 0 && (lru == nil || c.lastUsed.Before(lru.lastUsed)) {
+			lru = c
+		}
+	}
+	if lru == nil {
+		return false
+	}
+	log.Debugf("connection limit reached, closing connection to %v", lru.uri)
+	p.remove(lru)
+	return true
+}
+
+func (p *ClientPool) maintain() {
+	ticker := time.NewTicker(p.healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.check()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// check closes idle connections and drops unhealthy connections and connections with outdated DNS records
+func (p *ClientPool) check() {
+	p.mutex.Lock()
+	var clients []*pooledClient
+	var addrs [][]string
+	for _, c := range p.conns {
+		if c.refs == 0 && p.idleTimeout > 0 && time.Since(c.lastUsed) > p.idleTimeout {
+			log.Debugf("closing idle connection to %v", c.uri)
+			p.remove(c)
+			continue
+		}
+		clients = append(clients, c)
+		addrs = append(addrs, c.addrs)
+	}
+	p.mutex.Unlock()
+
+	for i, c := range clients {
+		reason := ""
+		current, lookupErr := lookupHost(c.host)
+		if err := healthCheck(c.conn); err != nil {
+			reason = err.Error()
+		} else if lookupErr == nil && addrs[i] != nil && !equalAddrs(current, addrs[i]) {
+			reason = fmt.Sprintf("addresses changed from %v to %v", addrs[i], current)
+		}
+		p.mutex.Lock()
+		if reason != "" {
+			log.Infof("replacing connection to %v: %v", c.uri, reason)
+			p.remove(c)
+		} else if lookupErr == nil && addrs[i] == nil {
+			// the addresses are recorded on the first check to keep DNS lookups out of the call path
+			c.addrs = current
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// healthCheck asks the function for its health, functions which don't implement the health service are considered healthy
+func healthCheck(conn *g.ClientConn) error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		if st, ok := status.FromError(err); ok && st.Code() == codes.Unimplemented {
+			return nil
+		}
+		return fmt.Errorf("health check failed: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("function is %v", resp.Status)
+	}
+	return nil
+}
+
+func lookupHost(host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String()}, nil
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil || len(addrs) == 0 {
+		return nil, fmt.Errorf("failed to resolve %v: %v", host, err)
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+func equalAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package forwarder_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	g "google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	. "github.com/trusch/btrfaas/fgateway/forwarder"
+	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// upperServer is a function which returns its input in upper case
+type upperServer struct{}
+
+func (s *upperServer) Run(stream btrfaasgrpc.FunctionRunner_RunServer) error {
+	buf := &bytes.Buffer{}
+	if err := btrfaasgrpc.CopyFromStream(stream.Context(), stream, buf); err != nil {
+		return err
+	}
+	return btrfaasgrpc.CopyToStream(stream.Context(), bytes.NewReader(bytes.ToUpper(buf.Bytes())), stream)
+}
+
+type testFunction struct {
+	server *g.Server
+	health *health.Server
+	port   uint16
+}
+
+func startTestFunction() *testFunction {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	fn := &testFunction{
+		server: g.NewServer(),
+		health: health.NewServer(),
+		port:   uint16(lis.Addr().(*net.TCPAddr).Port),
+	}
+	btrfaasgrpc.RegisterFunctionRunnerServer(fn.server, &upperServer{})
+	healthpb.RegisterHealthServer(fn.server, fn.health)
+	go fn.server.Serve(lis)
+	return fn
+}
+
+func insecureCredentials(host string) (g.DialOption, error) {
+	return g.WithInsecure(), nil
+}
+
+var _ = Describe("ClientPool", func() {
+	var (
+		functions []*testFunction
+		pool      *ClientPool
+		original  *ClientPool
+	)
+
+	usePool := func(p *ClientPool) {
+		p.SetCredentials(insecureCredentials)
+		pool = p
+		DefaultClientPool = p
+	}
+
+	forward := func(input string, fns ...*testFunction) (string, error) {
+		hosts := make([]*HostConfig, len(fns))
+		for i, fn := range fns {
+			hosts[i] = &HostConfig{Transport: GRPC, Host: "127.0.0.1", Port: fn.port}
+		}
+		output := &bytes.Buffer{}
+		err := Forward(context.Background(), &Options{
+			Hosts:  hosts,
+			Input:  strings.NewReader(input),
+			Output: output,
+		})
+		if err != nil {
+			return "", err
+		}
+		return output.String(), nil
+	}
+
+	BeforeEach(func() {
+		original = DefaultClientPool
+		functions = []*testFunction{startTestFunction(), startTestFunction(), startTestFunction()}
+	})
+
+	AfterEach(func() {
+		pool.Close()
+		DefaultClientPool = original
+		for _, fn := range functions {
+			fn.server.Stop()
+		}
+	})
+
+	It("should share connections between concurrent chains", func() {
+		usePool(NewClientPool(0, time.Minute, 10*time.Millisecond))
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				input := fmt.Sprintf("call %v", i)
+				out, err := forward(input, functions[i%3], functions[(i+1)%3], functions[(i+2)%3])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(out).To(Equal(strings.ToUpper(input)))
+			}(i)
+		}
+		wg.Wait()
+		Expect(pool.Len()).To(Equal(3))
+	})
+
+	It("should cap the number of connections", func() {
+		usePool(NewClientPool(1, time.Minute, 0))
+		for _, fn := range functions {
+			out, err := forward("foo", fn)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(Equal("FOO"))
+			Expect(pool.Len()).To(Equal(1))
+		}
+	})
+
+	It("should drop unhealthy connections", func() {
+		usePool(NewClientPool(0, time.Minute, 20*time.Millisecond))
+		_, err := forward("foo", functions[0], functions[1])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pool.Len()).To(Equal(2))
+		functions[0].health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+		Eventually(pool.Len).Should(Equal(1))
+		Consistently(pool.Len, 100*time.Millisecond).Should(Equal(1))
+	})
+
+	It("should close idle connections", func() {
+		usePool(NewClientPool(0, 50*time.Millisecond, 10*time.Millisecond))
+		_, err := forward("foo", functions[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pool.Len()).To(Equal(1))
+		Eventually(pool.Len).Should(Equal(0))
+		out, err := forward("bar", functions[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("BAR"))
+	})
+	It("should dial with fresh credentials after Reconnect", func() {
+		usePool(NewClientPool(0, time.Minute, 0))
+		var dials int32
+		pool.SetCredentials(func(host string) (g.DialOption, error) {
+			atomic.AddInt32(&dials, 1)
+			return insecureCredentials(host)
+		})
+		_, err := forward("foo", functions[0])
+		Expect(err).NotTo(HaveOccurred())
+		pool.Reconnect()
+		Expect(pool.Len()).To(Equal(0))
+		out, err := forward("bar", functions[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("BAR"))
+		Expect(atomic.LoadInt32(&dials)).To(Equal(int32(2)))
+	})
+})
@@ -2,29 +2,33 @@ package forwarder
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
-	"github.com/trusch/btrfaas/frunner/grpc"
+	"github.com/trusch/btrfaas/expression"
+	"github.com/trusch/btrfaas/fgateway/metrics"
 	"github.com/trusch/btrfaas/frunner/runnable"
 	"github.com/trusch/btrfaas/frunner/runnable/chain"
+	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+	"github.com/trusch/btrfaas/tlsconfig"
+	"github.com/trusch/btrfaas/trace"
 	g "google.golang.org/grpc"
-	"google.golang.org/grpc/balancer"
-	_ "google.golang.org/grpc/balancer/roundrobin"
-	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-// Options are the options for the forwarding
+// Options are the options for the forwarding.
+// If Expression is set, Hosts are its stages in the order of Expression.Stages() and the expression defines how they are connected,
+// otherwise the hosts are chained.
 type Options struct {
-	Hosts  []*HostConfig
-	Input  io.Reader
-	Output io.Writer
+	Hosts      []*HostConfig
+	Expression *expression.Expression
+	Input      io.Reader
+	Output     io.Writer
 }
 
 // HostConfig specifies one function service
@@ -33,6 +37,7 @@ type HostConfig struct {
 	Host        string
 	Port        uint16
 	CallOptions []string
+	Retry       *RetryPolicy // nil means DefaultRetryPolicy, set from RetryPolicies by ParseHostConfigs
 }
 
 // TransportProtocol is the type of the transport, currently only GRPC is supported
@@ -45,49 +50,38 @@ const (
 	HTTP
 )
 
-var clients = make(map[string]*grpc.Client)
-
 // Forward forwards a function call
 func Forward(ctx context.Context, options *Options) (err error) {
 	log.Debug("construct forwarding pipeline")
+	pool := DefaultClientPool
+	pooled := make([]*pooledClient, len(options.Hosts))
 	defer func() {
-		if err != nil {
-			cleanupClients(options)
+		failed := failedStage(err)
+		for i, c := range pooled {
+			if c != nil {
+				pool.release(c, i == failed)
+			}
 		}
 	}()
 	runnables := make([]runnable.Runnable, len(options.Hosts))
-	optSlice := make([][]string, len(options.Hosts))
 	for i, host := range options.Hosts {
 		switch host.Transport {
 		case GRPC:
 			{
-				uri := fmt.Sprintf("dns:///%v:%v", host.Host, host.Port)
-				var fn *grpc.Client
-				if cli, ok := clients[uri]; ok {
-					fn = cli
-				} else {
-					creds, err := getTransportCredentials(host.Host)
-					if err != nil {
-						log.Errorf("failed to get credentials for %v: %v", host.Host, err)
-						return err
-					}
-					rr := balancer.Get("round_robin")
-					fn, err = grpc.NewClientWithContext(ctx, uri, creds, g.WithBalancerBuilder(rr))
-					if err != nil {
-						log.Errorf("failed to get gRPC client for %v: %v", host.Host, err)
-						return err
-					}
-					clients[uri] = fn
+				fn, err := pool.get(host.Host, host.Port)
+				if err != nil {
+					log.Errorf("failed to get gRPC client for %v: %v", host.Host, err)
+					metrics.ObserveDialError(host.Host)
+					return err
 				}
-				runnables[i] = fn
-				optSlice[i] = host.CallOptions
-				log.Debugf("added grpc://%v to the pipeline", uri)
+				pooled[i] = fn
+				runnables[i] = chain.Bind(&stage{withRetry(fn, host), host.Host, i}, host.CallOptions)
+				log.Debugf("added %v to the pipeline", fn.uri)
 			}
 		case HTTP:
 			{
 				fn := NewHTTPRunnable(fmt.Sprintf("http://%v:%v", host.Host, host.Port))
-				runnables[i] = fn
-				optSlice[i] = host.CallOptions
+				runnables[i] = chain.Bind(&stage{withRetry(fn, host), host.Host, i}, host.CallOptions)
 			}
 		default:
 			{
@@ -95,56 +89,93 @@ func Forward(ctx context.Context, options *Options) (err error) {
 			}
 		}
 	}
-	cmd := chain.New(runnables...)
+	cmd := chain.New(runnables...).Bind(nil)
+	if options.Expression != nil {
+		if len(options.Expression.Stages()) != len(runnables) {
+			return errors.New("expression/host count mismatch")
+		}
+		cmd = buildPipeline(options.Expression.Pipeline, &runnables)
+	}
 	log.Debug("finished constructing pipeline, kickoff...")
-	return cmd.Run(ctx, optSlice, options.Input, options.Output)
+	return cmd.Run(ctx, nil, options.Input, options.Output)
 }
 
-var (
-	creds = make(map[string]credentials.TransportCredentials)
-)
-
-func getTransportCredentials(target string) (g.DialOption, error) {
-	if _, ok := creds[target]; !ok {
-		ca, err := ioutil.ReadFile("/run/secrets/btrfaas-ca-cert.pem")
-		if err != nil {
-			ca, err = ioutil.ReadFile("/run/secrets/btrfaas-ca-cert.pem/value")
-			if err != nil {
-				return nil, fmt.Errorf("could not read ca certificate: %s", err)
-			}
+// buildPipeline connects the stage runnables like the expression does, it consumes them in the order of the stages
+func buildPipeline(pipeline *expression.Pipeline, runnables *[]runnable.Runnable) runnable.Runnable {
+	nodes := make([]runnable.Runnable, len(pipeline.Nodes))
+	for i, node := range pipeline.Nodes {
+		switch node := node.(type) {
+		case *expression.Stage:
+			nodes[i] = (*runnables)[0]
+			*runnables = (*runnables)[1:]
+		case *expression.Group:
+			nodes[i] = buildGroup(node, runnables)
+		case *expression.Tee:
+			nodes[i] = chain.NewTee(buildGroup(node.Branch, runnables))
 		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return chain.New(nodes...).Bind(nil)
+}
 
-		certPool := x509.NewCertPool()
-		if ok := certPool.AppendCertsFromPEM(ca); !ok {
-			return nil, errors.New("failed to append ca certs")
-		}
+func buildGroup(group *expression.Group, runnables *[]runnable.Runnable) runnable.Runnable {
+	branches := make([]runnable.Runnable, len(group.Branches))
+	for i, branch := range group.Branches {
+		branches[i] = buildPipeline(branch, runnables)
+	}
+	if len(branches) == 1 {
+		return branches[0]
+	}
+	mode := chain.Concat
+	if group.Merge == expression.Interleave {
+		mode = chain.Interleave
+	}
+	return chain.NewParallel(mode, branches...)
+}
 
-		cert, err := tls.LoadX509KeyPair("/run/secrets/client-cert.pem", "/run/secrets/client-key.pem")
-		if err != nil {
-			cert, err = tls.LoadX509KeyPair("/run/secrets/client-cert.pem/value", "/run/secrets/client-key.pem/value")
-			if err != nil {
-				return nil, err
-			}
-		}
-		cfg := &tls.Config{
-			ServerName:   target,
-			RootCAs:      certPool,
-			Certificates: []tls.Certificate{cert},
-		}
-		cfg.BuildNameToCertificate()
-		creds[target] = credentials.NewTLS(cfg)
+// failedStage returns the chain position of a stage which failed because its function was unavailable, or -1
+func failedStage(err error) int {
+	e, ok := err.(*btrfaasgrpc.StatusError)
+	if !ok {
+		return -1
+	}
+	if st, ok := status.FromError(e.Err); ok && st.Code() == codes.Unavailable {
+		return int(e.Status.ChainPosition)
 	}
+	return -1
+}
 
-	return g.WithTransportCredentials(creds[target]), nil
+// stage is a runnable which annotates errors with its position in the chain and observes its calls
+type stage struct {
+	runnable.Runnable
+	functionID string
+	position   int
 }
 
-func cleanupClients(options *Options) {
-	for _, host := range options.Hosts {
-		if host.Transport == GRPC {
-			key := fmt.Sprintf("dns:///%v:%v", host.Host, host.Port)
-			if _, ok := clients[key]; ok {
-				delete(clients, key)
-			}
-		}
+// Run implements the runnable interface
+func (s *stage) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
+	ctx, span := trace.StartSpan(ctx, "fgateway.stage", trace.KindClient)
+	span.SetAttribute("btrfaas.function", s.functionID)
+	span.SetAttribute("btrfaas.chain_position", s.position)
+	start := time.Now()
+	err := s.Runnable.Run(ctx, options, input, output)
+	metrics.ObserveFunction(ctx, s.functionID, err, time.Since(start))
+	span.End(err)
+	if err == nil {
+		return nil
+	}
+	st := btrfaasgrpc.StatusFromError(err)
+	st.FunctionId = s.functionID
+	st.ChainPosition = int32(s.position)
+	if e, ok := err.(*btrfaasgrpc.StatusError); ok {
+		return e
 	}
+	return &btrfaasgrpc.StatusError{Status: st, Err: err}
+}
+
+// getTransportCredentials loads the client certificate of the gateway for connections to the given function
+func getTransportCredentials(target string) (g.DialOption, error) {
+	return tlsconfig.New("client").DialOption(target)
 }
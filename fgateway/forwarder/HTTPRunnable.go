@@ -1,9 +1,17 @@
 package forwarder
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+
+	"github.com/trusch/btrfaas/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // HTTPRunnable is a Runnable which does an HTTP request for its work (to be used with openfaas)
@@ -23,15 +31,33 @@ func (r *HTTPRunnable) Run(ctx context.Context, options []string, input io.Reade
 		return err
 	}
 	req = req.WithContext(ctx)
+	trace.InjectHTTP(ctx, req.Header)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return responseError(resp)
+	}
 	_, err = io.Copy(output, resp.Body)
 	return err
 }
 
+// responseError turns a failed response into an error, overload and unavailability are reported as gRPC status
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	msg := fmt.Sprintf("%v: %s", resp.Status, bytes.TrimSpace(body))
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return status.Error(codes.ResourceExhausted, msg)
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		return status.Error(codes.Unavailable, msg)
+	default:
+		return errors.New(msg)
+	}
+}
+
 func (r *HTTPRunnable) constructURL(options []string) string {
 	res := r.url
 	if options != nil && len(options) > 0 {
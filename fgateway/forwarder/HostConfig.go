@@ -0,0 +1,67 @@
+package forwarder
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// ParseHostConfigs creates the host configs for a chain of function IDs.
+// A function ID is either a plain service name or an URI like grpc://host:port or http://host:port.
+// opts may be nil, otherwise it must contain the call options for every function.
+// The hosts get their retry policy from RetryPolicies.
+func ParseHostConfigs(functionIDs []string, opts [][]string, defaultPort uint16) ([]*HostConfig, error) {
+	if opts != nil && len(opts) != len(functionIDs) {
+		return nil, fmt.Errorf("chain/option count mismatch")
+	}
+	cfgs := make([]*HostConfig, len(functionIDs))
+	for i, id := range functionIDs {
+		hostConfig := &HostConfig{}
+		uri, err := url.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		switch uri.Scheme {
+		case "":
+			{
+				hostConfig.Transport = GRPC
+				hostConfig.Host = uri.Path
+			}
+		case "grpc":
+			{
+				hostConfig.Transport = GRPC
+				hostConfig.Host = uri.Hostname()
+			}
+		case "http":
+			{
+				hostConfig.Transport = HTTP
+				hostConfig.Host = uri.Hostname()
+			}
+		default:
+			{
+				return nil, fmt.Errorf("no such transport: %v uri: %v", uri.Scheme, id)
+			}
+		}
+		if hostConfig.Host == "" {
+			return nil, fmt.Errorf("no host in function id: %v", id)
+		}
+		if port := uri.Port(); port != "" {
+			portNum, err := strconv.ParseUint(port, 10, 16)
+			if err != nil {
+				return nil, err
+			}
+			hostConfig.Port = uint16(portNum)
+		} else {
+			hostConfig.Port = defaultPort
+			if hostConfig.Transport == HTTP {
+				hostConfig.Port = 8080
+			}
+		}
+		if opts != nil {
+			hostConfig.CallOptions = opts[i]
+		}
+		hostConfig.Retry = RetryPolicies[hostConfig.Host]
+		cfgs[i] = hostConfig
+	}
+	return cfgs, nil
+}
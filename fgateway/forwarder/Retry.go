@@ -0,0 +1,345 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/trusch/btrfaas/fgateway/metrics"
+	"github.com/trusch/btrfaas/frunner/runnable"
+	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+)
+
+// RetryPolicy describes how failed calls to a function are retried.
+// A call is only retried if it produced no output yet and if the function consumed at most BufferSize bytes of its input,
+// because these bytes are buffered by the gateway and can be replayed.
+type RetryPolicy struct {
+	Attempts       int           // total number of attempts, values < 2 disable retries
+	Backoff        time.Duration // wait time before the first retry, doubled for every further retry
+	MaxBackoff     time.Duration // upper bound for the wait time, 0 means unbounded
+	RetryableCodes []codes.Code  // gRPC status codes which are retried
+	BufferSize     int           // number of input bytes which are buffered for replaying
+}
+
+// DefaultRetryPolicy is used for all hosts which don't specify their own policy
+var DefaultRetryPolicy = &RetryPolicy{
+	Attempts:       3,
+	Backoff:        100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	RetryableCodes: []codes.Code{codes.Unavailable, codes.ResourceExhausted},
+	BufferSize:     64 << 10,
+}
+
+// RetryPolicies are the retry policies of single functions by their host name,
+// ParseHostConfigs uses them for the hosts of calls, all other hosts use DefaultRetryPolicy.
+// They are set up once before any calls are forwarded.
+var RetryPolicies = map[string]*RetryPolicy{}
+
+// retryPolicySpec is the yaml representation of a RetryPolicy, fields which are not set are taken from a base policy
+type retryPolicySpec struct {
+	Attempts   *int   `yaml:"attempts,omitempty"`
+	Backoff    string `yaml:"backoff,omitempty"`
+	MaxBackoff string `yaml:"maxBackoff,omitempty"`
+	Codes      string `yaml:"codes,omitempty"`
+	BufferSize *int   `yaml:"bufferSize,omitempty"`
+}
+
+// ParseRetryPolicies parses a yaml map of function names to their retry policies,
+// like "to-upper: {attempts: 5, codes: UNAVAILABLE}". Fields which a policy doesn't set are taken from base.
+func ParseRetryPolicies(data []byte, base *RetryPolicy) (map[string]*RetryPolicy, error) {
+	specs := make(map[string]*retryPolicySpec)
+	if err := yaml.Unmarshal(data, &specs); err != nil {
+		return nil, err
+	}
+	policies := make(map[string]*RetryPolicy)
+	for name, spec := range specs {
+		policy := *base
+		if spec == nil {
+			policies[name] = &policy
+			continue
+		}
+		if spec.Attempts != nil {
+			policy.Attempts = *spec.Attempts
+		}
+		if spec.BufferSize != nil {
+			policy.BufferSize = *spec.BufferSize
+		}
+		var err error
+		if spec.Backoff != "" {
+			if policy.Backoff, err = time.ParseDuration(spec.Backoff); err != nil {
+				return nil, fmt.Errorf("invalid backoff of %v: %v", name, err)
+			}
+		}
+		if spec.MaxBackoff != "" {
+			if policy.MaxBackoff, err = time.ParseDuration(spec.MaxBackoff); err != nil {
+				return nil, fmt.Errorf("invalid maxBackoff of %v: %v", name, err)
+			}
+		}
+		if spec.Codes != "" {
+			if policy.RetryableCodes, err = ParseCodes(spec.Codes); err != nil {
+				return nil, fmt.Errorf("invalid codes of %v: %v", name, err)
+			}
+		}
+		policies[name] = &policy
+	}
+	return policies, nil
+}
+
+// ParseCodes parses a comma separated list of gRPC status codes like "UNAVAILABLE,RESOURCE_EXHAUSTED"
+func ParseCodes(list string) ([]codes.Code, error) {
+	names := make(map[string]codes.Code)
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		names[strings.ToLower(c.String())] = c
+	}
+	var res []codes.Code
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.Replace(strings.TrimSpace(name), "_", "", -1))
+		if name == "" {
+			continue
+		}
+		c, ok := names[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown status code: %v", name)
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+func (p *RetryPolicy) isRetryable(err error) bool {
+	if e, ok := err.(*btrfaasgrpc.StatusError); ok {
+		err = e.Err
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	for _, c := range p.RetryableCodes {
+		if st.Code() == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
+
+// withRetry wraps a runnable with the retry policy of the host
+func withRetry(base runnable.Runnable, host *HostConfig) runnable.Runnable {
+	policy := host.Retry
+	if policy == nil {
+		policy = DefaultRetryPolicy
+	}
+	return &retry{base, policy, host.Host}
+}
+
+// retry is a runnable which retries failed calls to another runnable according to a RetryPolicy
+type retry struct {
+	runnable.Runnable
+	policy     *RetryPolicy
+	functionID string
+}
+
+// Run implements the runnable interface
+func (r *retry) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
+	if r.policy == nil || r.policy.Attempts < 2 {
+		return r.Runnable.Run(ctx, options, input, output)
+	}
+	in := newReplayBuffer(input, r.policy.BufferSize)
+	out := &attemptOutput{w: output}
+	for attempt := 1; ; attempt++ {
+		reader := in.newReader()
+		writer := out.newWriter()
+		attemptCtx, cancel := context.WithCancel(ctx)
+		var attemptInput io.Reader
+		if input != nil {
+			attemptInput = reader
+		}
+		err := r.Runnable.Run(attemptCtx, options, attemptInput, writer)
+		cancel()
+		// calls which are still running in the background must not touch input and output anymore
+		in.detach(reader)
+		out.detach(writer)
+		if err == nil || attempt >= r.policy.Attempts || !r.policy.isRetryable(err) {
+			return err
+		}
+		if out.started() || !in.replayable() {
+			log.Debugf("can not retry call to %v: output started or input buffer exceeded", r.functionID)
+			return err
+		}
+		wait := r.policy.backoff(attempt)
+		log.Infof("call to %v failed (%v), retry in %v", r.functionID, err, wait)
+		metrics.ObserveRetry(r.functionID)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+var errInputLost = errors.New("input got lost while retrying")
+
+// replayBuffer records the first bytes read from a reader so that they can be read again by the next attempt
+type replayBuffer struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	r        io.Reader
+	limit    int
+	data     []byte
+	consumed int
+	overflow bool
+	reading  bool
+	err      error
+}
+
+func newReplayBuffer(r io.Reader, limit int) *replayBuffer {
+	b := &replayBuffer{r: r, limit: limit}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+// replayable returns true if everything consumed so far is buffered
+func (b *replayBuffer) replayable() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return !b.overflow
+}
+
+func (b *replayBuffer) newReader() *replayReader {
+	return &replayReader{buf: b}
+}
+
+func (b *replayBuffer) detach(r *replayReader) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	r.detached = true
+	b.cond.Broadcast()
+}
+
+// replayReader is the view of a single attempt on the replayBuffer
+type replayReader struct {
+	buf      *replayBuffer
+	pos      int
+	detached bool
+}
+
+func (r *replayReader) Read(p []byte) (int, error) {
+	b := r.buf
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for {
+		if r.detached {
+			return 0, io.ErrClosedPipe
+		}
+		if r.pos < b.consumed {
+			if b.overflow {
+				return 0, errInputLost
+			}
+			n := copy(p, b.data[r.pos:])
+			r.pos += n
+			return n, nil
+		}
+		if b.err != nil {
+			return 0, b.err
+		}
+		if b.reading {
+			b.cond.Wait()
+			continue
+		}
+
+		// read from the underlying reader without holding the lock, so that other attempts can replay meanwhile
+		b.reading = true
+		b.mutex.Unlock()
+		tmp := make([]byte, len(p))
+		n, err := b.r.Read(tmp)
+		b.mutex.Lock()
+		b.reading = false
+		start := b.consumed
+		b.consumed += n
+		if !b.overflow && len(b.data)+n <= b.limit {
+			b.data = append(b.data, tmp[:n]...)
+		} else if n > 0 {
+			b.overflow = true
+			b.data = nil
+		}
+		b.err = err
+		b.cond.Broadcast()
+		if r.detached || r.pos != start {
+			continue
+		}
+		r.pos += n
+		copy(p, tmp[:n])
+		if n > 0 {
+			return n, nil
+		}
+		if err == nil {
+			continue
+		}
+		return 0, err
+	}
+}
+
+// attemptOutput remembers if any output was written, writes of detached attempts are rejected
+type attemptOutput struct {
+	mutex   sync.Mutex
+	w       io.Writer
+	written bool
+}
+
+func (o *attemptOutput) newWriter() *attemptWriter {
+	return &attemptWriter{out: o}
+}
+
+func (o *attemptOutput) detach(w *attemptWriter) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	w.detached = true
+}
+
+func (o *attemptOutput) started() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.written
+}
+
+type attemptWriter struct {
+	out      *attemptOutput
+	detached bool
+}
+
+func (w *attemptWriter) Write(p []byte) (int, error) {
+	o := w.out
+	o.mutex.Lock()
+	if w.detached {
+		o.mutex.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	if len(p) > 0 {
+		o.written = true
+	}
+	o.mutex.Unlock()
+	// the write itself may block, once written is set the call is not retried anyway
+	return o.w.Write(p)
+}
@@ -0,0 +1,140 @@
+package forwarder_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	. "github.com/trusch/btrfaas/fgateway/forwarder"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Retry", func() {
+	var (
+		server   *httptest.Server
+		calls    int32
+		failures int32
+		failWith int
+	)
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&calls, 0)
+		failures = 2
+		failWith = http.StatusServiceUnavailable
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if atomic.AddInt32(&calls, 1) <= failures {
+				http.Error(w, "try again", failWith)
+				return
+			}
+			w.Write(bytes.ToUpper(body))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	forward := func(policy *RetryPolicy, input string) (string, error) {
+		uri, err := url.Parse(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		port, err := strconv.ParseUint(uri.Port(), 10, 16)
+		Expect(err).NotTo(HaveOccurred())
+		output := &bytes.Buffer{}
+		err = Forward(context.Background(), &Options{
+			Hosts: []*HostConfig{{
+				Transport: HTTP,
+				Host:      uri.Hostname(),
+				Port:      uint16(port),
+				Retry:     policy,
+			}},
+			Input:  strings.NewReader(input),
+			Output: output,
+		})
+		if err != nil {
+			// the chain doesn't wait for its output on errors
+			return "", err
+		}
+		return output.String(), nil
+	}
+
+	policy := func(attempts, bufferSize int) *RetryPolicy {
+		return &RetryPolicy{
+			Attempts:       attempts,
+			Backoff:        time.Millisecond,
+			RetryableCodes: []codes.Code{codes.Unavailable, codes.ResourceExhausted},
+			BufferSize:     bufferSize,
+		}
+	}
+
+	It("should replay the buffered input to the next attempt", func() {
+		out, err := forward(policy(3, 1024), "hello world")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("HELLO WORLD"))
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(3))
+	})
+
+	It("should give up after the configured attempts", func() {
+		_, err := forward(policy(2, 1024), "hello world")
+		Expect(err).To(HaveOccurred())
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+	})
+
+	It("should not retry if the consumed input exceeds the buffer", func() {
+		_, err := forward(policy(3, 4), "hello world")
+		Expect(err).To(HaveOccurred())
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+	})
+
+	It("should not retry errors with other codes", func() {
+		failWith = http.StatusInternalServerError
+		_, err := forward(policy(3, 1024), "hello world")
+		Expect(err).To(HaveOccurred())
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+	})
+
+	It("should parse lists of status codes", func() {
+		res, err := ParseCodes("UNAVAILABLE, resource_exhausted,DeadlineExceeded")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal([]codes.Code{codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded}))
+		_, err = ParseCodes("NOT_A_CODE")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should parse retry policies of single functions", func() {
+		base := policy(3, 1024)
+		policies, err := ParseRetryPolicies([]byte("to-upper:\n  attempts: 5\n  codes: DEADLINE_EXCEEDED\nsed:\n  backoff: 1s\n"), base)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policies).To(HaveLen(2))
+		Expect(policies["to-upper"].Attempts).To(Equal(5))
+		Expect(policies["to-upper"].RetryableCodes).To(Equal([]codes.Code{codes.DeadlineExceeded}))
+		Expect(policies["to-upper"].BufferSize).To(Equal(1024))
+		Expect(policies["sed"].Attempts).To(Equal(3))
+		Expect(policies["sed"].Backoff).To(Equal(time.Second))
+		_, err = ParseRetryPolicies([]byte("sed:\n  codes: NOT_A_CODE\n"), base)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should use the retry policy of the function", func() {
+		RetryPolicies = map[string]*RetryPolicy{"to-upper": policy(5, 1024)}
+		defer func() {
+			RetryPolicies = map[string]*RetryPolicy{}
+		}()
+		hosts, err := ParseHostConfigs([]string{"to-upper", "grpc://to-upper:2424", "sed"}, nil, 2424)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hosts[0].Retry.Attempts).To(Equal(5))
+		Expect(hosts[1].Retry.Attempts).To(Equal(5))
+		Expect(hosts[2].Retry).To(BeNil())
+	})
+})
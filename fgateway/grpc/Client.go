@@ -1,11 +1,16 @@
 package grpc
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"io"
+	"strings"
 
+	"github.com/trusch/btrfaas/expression"
+	"github.com/trusch/btrfaas/fgateway/jobs"
 	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+	"github.com/trusch/btrfaas/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 )
@@ -28,12 +33,121 @@ func NewClient(gateway string, opts ...grpc.DialOption) (*Client, error) {
 
 // Run nearly implements the runnable interface, except that it supports specifying chains of functions instead of a single function
 func (c *Client) Run(ctx context.Context, chain []string, options [][]string, input io.Reader, output io.Writer) error {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	ctx = metadata.NewOutgoingContext(ctx, metadata.MD{
+	md := metadata.MD{
 		"chain":   chain,
 		"options": buildOptionsForMetadata(options),
-	})
+	}
+	return c.run(ctx, md, strings.Join(chain, " | "), input, output)
+}
+
+// RunExpression runs a function expression which may contain groups and tees.
+// Linear expressions are sent as a chain, so that they also work with gateways which don't know expressions.
+func (c *Client) RunExpression(ctx context.Context, expr *expression.Expression, input io.Reader, output io.Writer) error {
+	if expr.Linear() {
+		return c.Run(ctx, expr.Chain(), expr.Options(), input, output)
+	}
+	md := metadata.MD{
+		"expression": []string{expr.String()},
+	}
+	return c.run(ctx, md, expr.String(), input, output)
+}
+
+// SubmitJob runs an expression in the background, it returns as soon as the input is uploaded.
+// The callback is a URL or a function expression which is notified when the job is done, empty for none.
+func (c *Client) SubmitJob(ctx context.Context, expr *expression.Expression, callback string, input io.Reader) (*jobs.Job, error) {
+	job := &jobs.Job{}
+	md := metadata.MD{
+		"job":        []string{JobSubmit},
+		"expression": []string{expr.String()},
+	}
+	if callback != "" {
+		md["job-callback"] = []string{callback}
+	}
+	if err := c.runJobAction(ctx, md, input, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetJob returns a job
+func (c *Client) GetJob(ctx context.Context, id string) (*jobs.Job, error) {
+	job := &jobs.Job{}
+	if err := c.runJobAction(ctx, jobMetadata(JobGet, id), nil, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ListJobs returns all jobs of the caller
+func (c *Client) ListJobs(ctx context.Context) ([]*jobs.Job, error) {
+	var res []*jobs.Job
+	if err := c.runJobAction(ctx, jobMetadata(JobList, ""), nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CancelJob cancels a job and returns it after it stopped
+func (c *Client) CancelJob(ctx context.Context, id string) (*jobs.Job, error) {
+	job := &jobs.Job{}
+	if err := c.runJobAction(ctx, jobMetadata(JobCancel, id), nil, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// RetryJob runs a failed job again
+func (c *Client) RetryJob(ctx context.Context, id string) (*jobs.Job, error) {
+	job := &jobs.Job{}
+	if err := c.runJobAction(ctx, jobMetadata(JobRetry, id), nil, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ListDeadLetters returns the failed jobs of the caller which can be retried
+func (c *Client) ListDeadLetters(ctx context.Context) ([]*jobs.DeadLetter, error) {
+	var res []*jobs.DeadLetter
+	if err := c.runJobAction(ctx, jobMetadata(JobDeadLetters, ""), nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// JobResult writes the output of a finished job to output
+func (c *Client) JobResult(ctx context.Context, id string, output io.Writer) error {
+	return c.run(ctx, jobMetadata(JobResult, id), "job "+id, &bytes.Buffer{}, output)
+}
+
+func jobMetadata(action, id string) metadata.MD {
+	return metadata.MD{
+		"job":    []string{action},
+		"job-id": []string{id},
+	}
+}
+
+// runJobAction calls the job API and decodes the JSON response into res
+func (c *Client) runJobAction(ctx context.Context, md metadata.MD, input io.Reader, res interface{}) error {
+	if input == nil {
+		input = &bytes.Buffer{}
+	}
+	output := &bytes.Buffer{}
+	if err := c.run(ctx, md, "job "+md["job"][0], input, output); err != nil {
+		return err
+	}
+	return json.Unmarshal(output.Bytes(), res)
+}
+
+func (c *Client) run(ctx context.Context, md metadata.MD, label string, input io.Reader, output io.Writer) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx, span := trace.StartSpan(ctx, "btrfaas.invoke", trace.KindClient)
+	span.SetAttribute("btrfaas.chain", label)
+	defer func() {
+		span.End(err)
+	}()
+	trace.InjectMetadata(ctx, md)
+	ctx = metadata.NewOutgoingContext(ctx, md)
 	cli, err := c.client.Run(ctx)
 	if err != nil {
 		return err
@@ -58,7 +172,7 @@ func (c *Client) Run(ctx context.Context, chain []string, options [][]string, in
 		case err := <-done:
 			{
 				if err != nil {
-					return err
+					return btrfaasgrpc.ErrorFromTrailer(cli, err)
 				}
 				todo--
 				if todo == 0 {
@@ -0,0 +1,155 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/trusch/btrfaas/expression"
+	"github.com/trusch/btrfaas/fgateway/authz"
+	"github.com/trusch/btrfaas/fgateway/forwarder"
+	"github.com/trusch/btrfaas/fgateway/jobs"
+	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Job actions are selected by the metadata key `job`, the id of the job is passed as `job-id`.
+// A submitted job reads its input from the stream, its callback is passed as `job-callback`.
+// Result streams the output of the job, the other actions answer with JSON.
+const (
+	JobSubmit      = "submit"
+	JobGet         = "get"
+	JobList        = "list"
+	JobCancel      = "cancel"
+	JobResult      = "result"
+	JobRetry       = "retry"
+	JobDeadLetters = "dead-letters"
+)
+
+// runJobAction handles the calls of the job API
+func (s *Server) runJobAction(ctx context.Context, stream btrfaasgrpc.FunctionRunner_RunServer, md metadata.MD) error {
+	s.mutex.Lock()
+	manager := s.jobs
+	s.mutex.Unlock()
+	if manager == nil {
+		return status.Error(codes.Unimplemented, "asynchronous calls are disabled")
+	}
+	owner := jobs.Owner(authz.IdentityFromContext(ctx))
+	if owner == "" {
+		return jobError(jobs.ErrAnonymous)
+	}
+	id := ""
+	if ids := md["job-id"]; len(ids) > 0 {
+		id = ids[0]
+	}
+	var (
+		res interface{}
+		err error
+	)
+	switch md["job"][0] {
+	case JobSubmit:
+		res, err = s.submitJob(ctx, stream, md, manager, owner)
+	case JobGet:
+		res, err = manager.Get(id, owner)
+	case JobList:
+		res, err = manager.List(owner)
+	case JobCancel:
+		res, err = manager.Cancel(id, owner)
+	case JobRetry:
+		res, err = manager.Retry(id, owner, s.authorizeExpression(ctx))
+	case JobDeadLetters:
+		res, err = manager.DeadLetters(owner)
+	case JobResult:
+		var result io.ReadCloser
+		if result, err = manager.Result(id, owner); err != nil {
+			return jobError(err)
+		}
+		defer result.Close()
+		return btrfaasgrpc.CopyToStream(ctx, result, stream)
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown job action %v", md["job"][0])
+	}
+	if err != nil {
+		return jobError(err)
+	}
+	bs, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return btrfaasgrpc.CopyToStream(ctx, bytes.NewReader(bs), stream)
+}
+
+// submitJob checks the call and its callback like synchronous calls and stores its input in the job
+func (s *Server) submitJob(ctx context.Context, stream btrfaasgrpc.FunctionRunner_RunServer, md metadata.MD, manager *jobs.Manager, owner string) (*jobs.Job, error) {
+	expr, _, err := s.prepare(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	callback := ""
+	if callbacks := md["job-callback"]; len(callbacks) > 0 {
+		callback = callbacks[0]
+	}
+	s.mutex.Lock()
+	pipelines := s.pipelines
+	s.mutex.Unlock()
+	if callback, err = manager.PrepareCallback(callback, pipelines, s.authorizeExpression(ctx)); err != nil {
+		return nil, callbackError(err)
+	}
+	inputReader, inputWriter := io.Pipe()
+	go func() {
+		inputWriter.CloseWithError(btrfaasgrpc.CopyFromStream(ctx, stream, inputWriter))
+	}()
+	job, err := manager.Submit(&jobs.Job{Expression: expr.String(), Owner: owner, Callback: callback}, inputReader)
+	inputReader.Close()
+	if err == jobs.ErrInputTooLarge {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		log.Errorf("could not submit job: %v", err)
+		return nil, err
+	}
+	return job, nil
+}
+
+// authorizeExpression returns a function which checks if the caller may call every function of a resolved expression
+func (s *Server) authorizeExpression(ctx context.Context) jobs.AuthorizeFunc {
+	return func(expr *expression.Expression) error {
+		hosts, err := forwarder.ParseHostConfigs(expr.Chain(), expr.Options(), s.defaultPort)
+		if err != nil {
+			return err
+		}
+		return s.authorize(ctx, hosts)
+	}
+}
+
+// callbackError keeps the status of denied calls, denied callback URLs are PermissionDenied and malformed callbacks InvalidArgument
+func callbackError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if _, ok := err.(*jobs.CallbackDeniedError); ok {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+func jobError(err error) error {
+	if _, ok := err.(*jobs.CallbackDeniedError); ok {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	switch err {
+	case jobs.ErrNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case jobs.ErrNotDone:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case jobs.ErrAnonymous:
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return err
+}
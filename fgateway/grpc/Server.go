@@ -3,103 +3,173 @@ package grpc
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
-	"net/url"
-	"strconv"
-	"time"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 
+	"github.com/trusch/btrfaas/expression"
+	"github.com/trusch/btrfaas/fgateway/authz"
 	"github.com/trusch/btrfaas/fgateway/forwarder"
+	"github.com/trusch/btrfaas/fgateway/jobs"
 	"github.com/trusch/btrfaas/fgateway/metrics"
 	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+	"github.com/trusch/btrfaas/health"
+	"github.com/trusch/btrfaas/pipeline"
+	"github.com/trusch/btrfaas/shutdown"
+	"github.com/trusch/btrfaas/tlsconfig"
+	"github.com/trusch/btrfaas/trace"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 // Server represents a gRPC based function dispatcher
 type Server struct {
 	addr        string
 	defaultPort uint16
+	tls         *tlsconfig.Config
 	grpcOpts    []grpc.ServerOption
+	mutex       sync.Mutex
+	server      *grpc.Server
+	reloader    *tlsconfig.Reloader
+	authorizer  *authz.Authorizer
+	pipelines   *pipeline.Store
+	jobs        *jobs.Manager
+	clientAuth  tls.ClientAuthType
 }
 
-// NewServer creates a gRPC based function dispatcher
-func NewServer(addr string, defaultPort uint16, opts ...grpc.ServerOption) *Server {
-	return &Server{addr, defaultPort, opts}
+// NewServer creates a gRPC based function dispatcher which loads its certificate according to tlsConfig
+func NewServer(addr string, defaultPort uint16, tlsConfig *tlsconfig.Config, opts ...grpc.ServerOption) *Server {
+	return &Server{addr: addr, defaultPort: defaultPort, tls: tlsConfig, grpcOpts: opts, clientAuth: tls.VerifyClientCertIfGiven}
+}
+
+// SetRequireClientCert rejects callers without a valid client certificate during the handshake
+func (s *Server) SetRequireClientCert(require bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.clientAuth = tls.VerifyClientCertIfGiven
+	if require {
+		s.clientAuth = tls.RequireAndVerifyClientCert
+	}
+}
+
+// SetAuthorizer enables the authorization of calls, without an authorizer every caller may call every function
+func (s *Server) SetAuthorizer(authorizer *authz.Authorizer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.authorizer = authorizer
+}
+
+// SetPipelines enables calling deployed pipelines by their id
+func (s *Server) SetPipelines(pipelines *pipeline.Store) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pipelines = pipelines
+}
+
+// SetJobs enables asynchronous calls
+func (s *Server) SetJobs(manager *jobs.Manager) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.jobs = manager
 }
 
 // ListenAndServe starts listening for connections
 func (s *Server) ListenAndServe() error {
-	lis, err := net.Listen("tcp", s.addr)
+	// certificates are reloaded when their files change, running calls keep their connection
+	reloader, err := tlsconfig.NewReloader(s.tls)
 	if err != nil {
 		return err
 	}
-	certificate, err := tls.LoadX509KeyPair("/run/secrets/fgateway-cert.pem", "/run/secrets/fgateway-key.pem")
+	reloader.ExportExpiry(metrics.CertificateExpiry, "server")
+	reloader.Watch()
+	lis, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		certificate, err = tls.LoadX509KeyPair("/run/secrets/fgateway-cert.pem/value", "/run/secrets/fgateway-key.pem/value") // k8s specific -.-
-		if err != nil {
-			return fmt.Errorf("could not load server key pair: %s", err)
-		}
+		reloader.Close()
+		return err
 	}
+	s.mutex.Lock()
+	clientAuth := s.clientAuth
+	s.mutex.Unlock()
+	grpcServer := grpc.NewServer(append(s.grpcOpts, reloader.ServerOptions(clientAuth)...)...)
 
-	// Create a certificate pool from the certificate authority
-	certPool := x509.NewCertPool()
-	ca, err := ioutil.ReadFile("/run/secrets/btrfaas-ca-cert.pem")
-	if err != nil {
-		ca, err = ioutil.ReadFile("/run/secrets/btrfaas-ca-cert.pem/value") // k8s specific -.-
-		if err != nil {
-			return fmt.Errorf("could not read ca certificate: %s", err)
-		}
-	}
+	btrfaasgrpc.RegisterFunctionRunnerServer(grpcServer, s)
+	health.Register(grpcServer)
+	s.mutex.Lock()
+	s.server = grpcServer
+	s.reloader = reloader
+	s.mutex.Unlock()
+	return grpcServer.Serve(lis)
+}
 
-	// Append the client certificates from the CA
-	if ok := certPool.AppendCertsFromPEM(ca); !ok {
-		return errors.New("failed to append client certs")
+// Shutdown stops accepting new calls and waits until the running calls are finished or ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	grpcServer := s.grpcServer()
+	if grpcServer == nil {
+		return nil
 	}
+	done := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	// Create the TLS credentials
-	creds := credentials.NewTLS(&tls.Config{
-		ClientAuth:   tls.VerifyClientCertIfGiven,
-		Certificates: []tls.Certificate{certificate},
-		ClientCAs:    certPool,
-	})
-	grpcServer := grpc.NewServer(grpc.Creds(creds))
+// Close stops the server immediately
+func (s *Server) Close() error {
+	if grpcServer := s.grpcServer(); grpcServer != nil {
+		grpcServer.Stop()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.reloader != nil {
+		s.reloader.Close()
+	}
+	return nil
+}
 
-	btrfaasgrpc.RegisterFunctionRunnerServer(grpcServer, s)
-	return grpcServer.Serve(lis)
+func (s *Server) grpcServer() *grpc.Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.server
 }
 
 // Run implements the gRPC interface
 func (s *Server) Run(stream btrfaasgrpc.FunctionRunner_RunServer) (err error) {
 	log.Debug("new gRPC request")
-	ctx, cancel := context.WithCancel(stream.Context())
+	ctx, cancel := shutdown.Bind(trace.ExtractMetadata(stream.Context()))
 	defer cancel()
-	start := time.Now()
+	ctx, span := trace.StartSpan(ctx, "fgateway.dispatch", trace.KindServer)
+	defer func() {
+		span.End(err)
+	}()
 
-	chain, options, err := getOptionsFromStream(stream)
-	if err != nil {
-		return err
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok && len(md["job"]) > 0 {
+		span.SetAttribute("btrfaas.job_action", md["job"][0])
+		return s.runJobAction(ctx, stream, md)
 	}
-	hosts, err := s.createHostConfigs(chain, options)
+	expr, hosts, err := s.prepare(ctx, stream)
 	if err != nil {
 		return err
 	}
+	span.SetAttribute("btrfaas.chain", expr.String())
+	chain := expr.Chain()
+	req := metrics.StartRequest(len(hosts))
 	defer func() {
-		end := time.Now()
-		duration := end.Sub(start)
-		log.Debugf("finished gRPC request in %v", duration)
-		for _, host := range hosts {
-			metrics.Observe(host.Host, err != nil, duration)
-		}
+		req.Finish(ctx, err)
 	}()
 
 	inputReader, inputWriter := io.Pipe()
@@ -109,10 +179,11 @@ func (s *Server) Run(stream btrfaasgrpc.FunctionRunner_RunServer) (err error) {
 
 	go func() {
 		log.Debug("forward to function services ", chain)
-		done <- forwarder.Forward(stream.Context(), &forwarder.Options{
-			Hosts:  hosts,
-			Input:  inputReader,
-			Output: outputWriter,
+		done <- forwarder.Forward(ctx, &forwarder.Options{
+			Hosts:      hosts,
+			Expression: expr,
+			Input:      req.Input(inputReader),
+			Output:     req.Output(outputWriter),
 		})
 		done <- outputWriter.Close()
 	}()
@@ -135,6 +206,7 @@ func (s *Server) Run(stream btrfaasgrpc.FunctionRunner_RunServer) (err error) {
 			{
 				if err != nil {
 					log.Debugf("finished with error: %v", err)
+					stream.SetTrailer(btrfaasgrpc.StatusToMetadata(btrfaasgrpc.StatusFromError(err)))
 					return fmt.Errorf("fgateway: %v", err)
 				}
 				todo--
@@ -146,76 +218,77 @@ func (s *Server) Run(stream btrfaasgrpc.FunctionRunner_RunServer) (err error) {
 	}
 }
 
-func getOptionsFromStream(stream btrfaasgrpc.FunctionRunner_RunServer) (chain []string, optionSlice [][]string, err error) {
+// prepare reads the expression of a call, resolves its pipelines and checks if the caller may call its functions
+func (s *Server) prepare(ctx context.Context, stream btrfaasgrpc.FunctionRunner_RunServer) (*expression.Expression, []*forwarder.HostConfig, error) {
+	expr, err := getExpressionFromStream(stream)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mutex.Lock()
+	pipelines := s.pipelines
+	s.mutex.Unlock()
+	if expr, err = pipelines.Resolve(expr); err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	hosts, err := forwarder.ParseHostConfigs(expr.Chain(), expr.Options(), s.defaultPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = s.authorize(ctx, hosts); err != nil {
+		return nil, nil, err
+	}
+	return expr, hosts, nil
+}
+
+// authorize checks if the caller may call every function of the chain
+func (s *Server) authorize(ctx context.Context, hosts []*forwarder.HostConfig) error {
+	s.mutex.Lock()
+	authorizer := s.authorizer
+	s.mutex.Unlock()
+	if authorizer == nil {
+		return nil
+	}
+	functions := make([]string, len(hosts))
+	for i, host := range hosts {
+		functions[i] = host.Host
+	}
+	if err := authorizer.Authorize(authz.IdentityFromContext(ctx), functions); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// getExpressionFromStream reads the call from the metadata, it is either a function expression or a chain with options
+func getExpressionFromStream(stream btrfaasgrpc.FunctionRunner_RunServer) (*expression.Expression, error) {
 	md, ok := metadata.FromIncomingContext(stream.Context())
 	if !ok {
-		return nil, nil, errors.New("no metadata")
+		return nil, errors.New("no metadata")
 	}
-	chain, ok = md["chain"]
+	if exprs, ok := md["expression"]; ok && len(exprs) > 0 {
+		expr, err := expression.Parse(exprs[0])
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("malformed function expression: %v", err))
+		}
+		return expr, nil
+	}
+	chain, ok := md["chain"]
 	if !ok {
-		return nil, nil, errors.New("no chain in metadata")
+		return nil, errors.New("no chain in metadata")
 	}
 	optionsList, ok := md["options"]
 	if !ok {
-		return chain, nil, nil
+		return expression.New(chain, nil), nil
 	}
-	optionSlice = make([][]string, len(optionsList))
+	optionSlice := make([][]string, len(optionsList))
 	for idx, objStr := range optionsList {
 		var options []string
 		if err := json.Unmarshal([]byte(objStr), &options); err != nil {
-			return chain, nil, err
+			return nil, err
 		}
 		optionSlice[idx] = options
 	}
 	if len(chain) != len(optionSlice) {
-		return nil, nil, errors.New("chain/option count mismatch")
-	}
-	return chain, optionSlice, nil
-}
-
-func (s *Server) createHostConfigs(functionIDs []string, opts [][]string) ([]*forwarder.HostConfig, error) {
-	cfgs := make([]*forwarder.HostConfig, len(functionIDs))
-	for i, id := range functionIDs {
-		hostConfig := &forwarder.HostConfig{}
-		uri, err := url.Parse(id)
-		if err != nil {
-			return nil, err
-		}
-		switch uri.Scheme {
-		case "":
-			{
-				hostConfig.Transport = forwarder.GRPC
-				hostConfig.Host = uri.Path
-			}
-		case "grpc":
-			{
-				hostConfig.Transport = forwarder.GRPC
-				hostConfig.Host = uri.Host
-			}
-		case "http":
-			{
-				hostConfig.Transport = forwarder.HTTP
-				hostConfig.Host = uri.Host
-			}
-		default:
-			{
-				return nil, fmt.Errorf("no such transport: %v uri: %v", uri.Scheme, id)
-			}
-		}
-		if port := uri.Port(); port != "" {
-			portNum, err := strconv.ParseUint(port, 10, 64)
-			if err != nil {
-				return nil, err
-			}
-			hostConfig.Port = uint16(portNum)
-		} else {
-			hostConfig.Port = s.defaultPort
-			if hostConfig.Transport == forwarder.HTTP {
-				hostConfig.Port = 8080
-			}
-		}
-		hostConfig.CallOptions = opts[i]
-		cfgs[i] = hostConfig
+		return nil, errors.New("chain/option count mismatch")
 	}
-	return cfgs, nil
+	return expression.New(chain, optionSlice), nil
 }
@@ -2,69 +2,290 @@ package handler
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/trusch/btrfaas/expression"
+	"github.com/trusch/btrfaas/fgateway/authz"
 	"github.com/trusch/btrfaas/fgateway/forwarder"
+	"github.com/trusch/btrfaas/fgateway/jobs"
+	"github.com/trusch/btrfaas/fgateway/metrics"
+	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+	"github.com/trusch/btrfaas/pipeline"
+	"github.com/trusch/btrfaas/shutdown"
+	"github.com/trusch/btrfaas/trace"
+)
+
+const invokePrefix = "/api/v0/invoke/"
+
+// Headers and trailers which describe a failed call
+const (
+	ErrorHeader         = "X-Btrfaas-Error"
+	FunctionHeader      = "X-Btrfaas-Function"
+	ChainPositionHeader = "X-Btrfaas-Chain-Position"
+	ExitCodeHeader      = "X-Btrfaas-Exit-Code"
+	RetryableHeader     = "X-Btrfaas-Retryable"
+	StderrHeader        = "X-Btrfaas-Stderr" // base64 encoded
 )
 
+// OptionsHeaderPrefix is the prefix of the headers which carry the options of a stage: X-Btrfaas-Options-<stage index>
+const OptionsHeaderPrefix = "X-Btrfaas-Options-"
+
+// TimeoutHeader can be used instead of the timeout query parameter
+const TimeoutHeader = "X-Btrfaas-Timeout"
+
 // FunctionDispatcher is an HTTP handler which dispatch function calls
 // accepts something like this: /api/v0/invoke/<my-function-id>
+// or for a chain of functions: /api/v0/invoke/<fn-a>|<fn-b>
+// The path is a function expression, so options can follow the functions: /api/v0/invoke/sed%20-e%20's/a/b/'|to-upper
+// and functions can run in parallel: /api/v0/invoke/(fn-a%20%26%20fn-b)|fn-c
+// Further options of the stages (numbered like Expression.Stages()) are passed as query parameters options.<stage index> or as headers X-Btrfaas-Options-<stage index>,
+// each of them can be repeated to pass multiple options.
+// Stages which name a deployed pipeline of Pipelines are replaced by its expression.
+// Callers authenticate with a client certificate or with an API key of Tokens (Authorization: Bearer <key>).
+// If an Authorizer is set, calls are checked against its policy.
+// With RequireAuthentication anonymous calls are rejected.
+// Calls with async=true (or X-Btrfaas-Async: true) are answered with a job of Jobs, see serveJobs for the job API.
 type FunctionDispatcher struct {
-	DefaultPort uint16
+	DefaultPort           uint16
+	Authorizer            *authz.Authorizer
+	Tokens                *authz.TokenStore
+	RequireAuthentication bool
+	Pipelines             *pipeline.Store
+	Jobs                  *jobs.Manager
 }
 
 // NewFunctionDispatcher returns a new http handler
 func NewFunctionDispatcher(defaultPort uint16) http.Handler {
-	return &FunctionDispatcher{defaultPort}
+	return &FunctionDispatcher{DefaultPort: defaultPort}
 }
 
 func (d *FunctionDispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Info("request: %v %v (%v)", r.Method, r.URL, r.RemoteAddr)
+	log.Infof("request: %v %v (%v)", r.Method, r.URL, r.RemoteAddr)
 	path := r.URL.Path
-	if !strings.HasPrefix(path, "/api/v0") {
-		log.Warn("unknown request path")
-		w.WriteHeader(http.StatusNotFound)
+	if path == jobsPrefix || strings.HasPrefix(path, jobsPrefix+"/") {
+		d.serveJobs(w, r)
+		return
+	}
+	if !strings.HasPrefix(path, invokePrefix) {
+		log.Warn("unknown request path: ", path)
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	expr, err := expression.Parse(strings.TrimPrefix(path, invokePrefix))
+	if err != nil {
+		log.Warn("malformed function expression: ", err)
+		http.Error(w, fmt.Sprintf("malformed function expression: %v", err), http.StatusBadRequest)
+		return
+	}
+	stages := expr.Stages()
+	for i, opts := range getOptions(r, expr) {
+		stages[i].Options = opts
+	}
+	if expr, err = d.Pipelines.Resolve(expr); err != nil {
+		log.Warn("could not resolve pipelines: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	chain := expr.Chain()
+	hosts, err := forwarder.ParseHostConfigs(chain, expr.Options(), d.DefaultPort)
+	if err != nil {
+		log.Warn("malformed invoke request: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	identity, ok := d.authenticate(r)
+	if !ok {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	if identity == nil && d.RequireAuthentication {
+		log.Warnf("rejected anonymous call of %v", chain)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	if err = d.authorize(identity, hosts); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if isAsync(r) {
+		d.submitJob(w, r, expr, identity)
 		return
 	}
-	if strings.HasPrefix(path, "/api/v0/invoke") {
-		parts := strings.Split(path, "/")
-		if len(parts) != 5 {
-			log.Warn("malformed invoke request: ", path)
-			w.WriteHeader(http.StatusBadRequest)
+
+	ctx, cancel := shutdown.Bind(r.Context())
+	defer cancel()
+	ctx, span := trace.StartSpan(trace.ExtractHTTP(ctx, r.Header), "fgateway.dispatch", trace.KindServer)
+	span.SetAttribute("btrfaas.chain", expr.String())
+	defer func() {
+		span.End(err)
+	}()
+	timeout := r.URL.Query().Get("timeout")
+	if timeout == "" {
+		timeout = r.Header.Get(TimeoutHeader)
+	}
+	if timeout != "" {
+		t, e := time.ParseDuration(timeout)
+		if e != nil || t <= 0 {
+			log.Warn("malformed timeout: ", timeout)
+			http.Error(w, fmt.Sprintf("malformed timeout: %v", timeout), http.StatusBadRequest)
 			return
 		}
-		functionID := parts[4]
-		ctx := context.Background()
-		if timeout := r.URL.Query().Get("timeout"); timeout != "" {
-			t, e := time.ParseDuration(timeout)
-			if e != nil {
-				log.Errorf("parsing timeout: %v", e)
-			}
-			c, cancel := context.WithTimeout(ctx, t)
-			defer cancel()
-			ctx = c
-		}
-		err := forwarder.Forward(ctx, &forwarder.Options{
-			Hosts: []*forwarder.HostConfig{
-				{
-					Transport: forwarder.GRPC,
-					Host:      functionID,
-					Port:      d.DefaultPort,
-				},
-			},
-			Input:  r.Body,
-			Output: w,
-		})
-		if err != nil {
-			log.Errorf("error forwarding function call: %v", err)
-		}
-		log.Info("finished request")
+		c, cancel := context.WithTimeout(ctx, t)
+		defer cancel()
+		ctx = c
+	}
+
+	req := metrics.StartRequest(len(hosts))
+	output := &responseWriter{w: w}
+	err = forwarder.Forward(ctx, &forwarder.Options{
+		Hosts:      hosts,
+		Expression: expr,
+		Input:      req.Input(r.Body),
+		Output:     req.Output(output),
+	})
+	req.Finish(ctx, err)
+	started := output.finish()
+	if err != nil {
+		log.Errorf("error forwarding function call: %v", err)
+		writeError(ctx, w, err, started)
 		return
 	}
+	log.Info("finished request")
+}
+
+// authenticate returns the identity of the client certificate or the API key, false if the API key is invalid
+func (d *FunctionDispatcher) authenticate(r *http.Request) (*authz.Identity, bool) {
+	if identity := authz.IdentityFromRequest(r); identity != nil {
+		return identity, true
+	}
+	key := authz.TokenFromRequest(r)
+	if key == "" {
+		return nil, true
+	}
+	var identity *authz.Identity
+	if d.Tokens != nil {
+		identity = d.Tokens.Authenticate(key)
+	}
+	if identity == nil {
+		log.Warnf("rejected unknown token from %v", r.RemoteAddr)
+		return nil, false
+	}
+	return identity, true
+}
+
+// authorize checks if the identity may call every function of hosts
+func (d *FunctionDispatcher) authorize(identity *authz.Identity, hosts []*forwarder.HostConfig) error {
+	functions := make([]string, len(hosts))
+	for i, host := range hosts {
+		functions[i] = host.Host
+	}
+	if d.Authorizer != nil {
+		return d.Authorizer.Authorize(identity, functions)
+	}
+	// the scopes of a token apply even if authorization is disabled
+	return authz.CheckScopes(identity, functions)
+}
+
+// getOptions collects the options of every stage from the expression, the query and the headers
+func getOptions(r *http.Request, expr *expression.Expression) [][]string {
+	query := r.URL.Query()
+	opts := expr.Options()
+	for i := range opts {
+		idx := strconv.Itoa(i)
+		opts[i] = append(opts[i], query["options."+idx]...)
+		opts[i] = append(opts[i], r.Header[http.CanonicalHeaderKey(OptionsHeaderPrefix+idx)]...)
+	}
+	return opts
+}
+
+// writeError reports the error as headers and body, or as trailers if the output already started
+func writeError(ctx context.Context, w http.ResponseWriter, err error, started bool) {
+	st := btrfaasgrpc.StatusFromError(err)
+	prefix := ""
+	if started {
+		prefix = http.TrailerPrefix
+	}
+	header := w.Header()
+	header.Set(prefix+ErrorHeader, err.Error())
+	header.Set(prefix+RetryableHeader, strconv.FormatBool(st.Retryable))
+	if st.FunctionId != "" {
+		header.Set(prefix+FunctionHeader, st.FunctionId)
+		header.Set(prefix+ChainPositionHeader, strconv.Itoa(int(st.ChainPosition)))
+	}
+	if st.ExitCode != 0 {
+		header.Set(prefix+ExitCodeHeader, strconv.Itoa(int(st.ExitCode)))
+	}
+	if len(st.Stderr) > 0 {
+		header.Set(prefix+StderrHeader, base64.StdEncoding.EncodeToString(st.Stderr))
+	}
+	if !started {
+		http.Error(w, err.Error(), statusCode(ctx, err))
+	}
+}
+
+// statusCode maps an error to a HTTP status code
+func statusCode(ctx context.Context, err error) int {
+	cause := err
+	if e, ok := err.(*btrfaasgrpc.StatusError); ok {
+		cause = e.Err
+	}
+	if st, ok := status.FromError(cause); ok && st.Code() == codes.ResourceExhausted {
+		return http.StatusTooManyRequests
+	}
+	switch metrics.ErrorClass(ctx, err) {
+	case metrics.ErrorDial:
+		return http.StatusServiceUnavailable
+	case metrics.ErrorTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// responseWriter flushes every write so that the output is streamed and remembers if the output started.
+// After finish() it drops all writes, since a failed chain may still produce output after Forward returned.
+type responseWriter struct {
+	mutex    sync.Mutex
+	w        http.ResponseWriter
+	started  bool
+	finished bool
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	if rw.finished {
+		return 0, io.ErrClosedPipe
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	rw.started = true
+	n, err := rw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if flusher, ok := rw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, nil
+}
 
-	log.Warn("unknown request path")
-	w.WriteHeader(http.StatusNotFound)
+// finish stops writing to the underlying response and returns if the output started
+func (rw *responseWriter) finish() bool {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	rw.finished = true
+	return rw.started
 }
@@ -0,0 +1,136 @@
+package handler_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trusch/btrfaas/fgateway/authz"
+	. "github.com/trusch/btrfaas/fgateway/http"
+	"github.com/trusch/btrfaas/fgateway/jobs"
+	"github.com/trusch/btrfaas/tlsconfig"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FunctionDispatcher", func() {
+	var (
+		dir        string
+		manager    *jobs.Manager
+		authorizer *authz.Authorizer
+		server     *httptest.Server
+	)
+
+	config := func(name string) *tlsconfig.Config {
+		return &tlsconfig.Config{
+			CertFile: filepath.Join(dir, name+"-cert.pem"),
+			KeyFile:  filepath.Join(dir, name+"-key.pem"),
+			CAFile:   filepath.Join(dir, "ca-cert.pem"),
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "handler")
+		Expect(err).NotTo(HaveOccurred())
+		ca, caKey := writeCert(dir, "ca", nil, nil)
+		for _, name := range []string{"fgateway", "alice", "bob"} {
+			writeCert(dir, name, ca, caKey)
+		}
+		policy := filepath.Join(dir, "policy.yaml")
+		Expect(ioutil.WriteFile(policy, []byte("rules:\n- users: [alice]\n  functions: [echo]\n"), 0600)).To(Succeed())
+		authorizer, err = authz.NewAuthorizer(policy)
+		Expect(err).NotTo(HaveOccurred())
+		store, err := jobs.NewFileStore(filepath.Join(dir, "jobs"))
+		Expect(err).NotTo(HaveOccurred())
+		manager = jobs.NewManager(store, func(ctx context.Context, job *jobs.Job, input io.Reader, output io.Writer) error {
+			_, err := io.Copy(output, input)
+			return err
+		}, 1)
+
+		reloader, err := tlsconfig.NewReloader(config("fgateway"))
+		Expect(err).NotTo(HaveOccurred())
+		server = httptest.NewUnstartedServer(&FunctionDispatcher{
+			DefaultPort:           2424,
+			Authorizer:            authorizer,
+			RequireAuthentication: true,
+			Jobs:                  manager,
+		})
+		server.TLS = reloader.HTTPServerTLS(tls.VerifyClientCertIfGiven)
+		server.StartTLS()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		manager.Close()
+		authorizer.Close()
+		os.RemoveAll(dir)
+	})
+
+	// submit submits an asynchronous call of echo with the client certificate of user, none if user is empty
+	submit := func(user string) int {
+		cfg := config(user)
+		if user == "" {
+			cfg.CertFile, cfg.KeyFile = "", ""
+		}
+		client, err := tlsconfig.NewReloader(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		transport := &http.Transport{TLSClientConfig: client.ClientTLS("localhost")}
+		resp, err := (&http.Client{Transport: transport}).Post(server.URL+"/api/v0/invoke/echo?async=true", "", strings.NewReader("hello"))
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	It("should authorize callers by their client certificate", func() {
+		Expect(submit("alice")).To(Equal(http.StatusAccepted))
+		Expect(submit("bob")).To(Equal(http.StatusForbidden))
+		Expect(submit("")).To(Equal(http.StatusUnauthorized))
+	})
+
+})
+
+// writeCert writes <name>-cert.pem and <name>-key.pem for localhost to dir, the certificate is a CA if ca is nil
+func writeCert(dir, name string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		ca, caKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(ioutil.WriteFile(filepath.Join(dir, name+"-cert.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)).To(Succeed())
+	Expect(ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)).To(Succeed())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return cert, key
+}
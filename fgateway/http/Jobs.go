@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/trusch/btrfaas/expression"
+	"github.com/trusch/btrfaas/fgateway/authz"
+	"github.com/trusch/btrfaas/fgateway/forwarder"
+	"github.com/trusch/btrfaas/fgateway/jobs"
+)
+
+const jobsPrefix = "/api/v0/jobs"
+
+// AsyncHeader can be used instead of the async query parameter
+const AsyncHeader = "X-Btrfaas-Async"
+
+// CallbackHeader can be used instead of the callback query parameter
+const CallbackHeader = "X-Btrfaas-Callback"
+
+// isAsync returns true if the call should run as job
+func isAsync(r *http.Request) bool {
+	value := r.URL.Query().Get("async")
+	if value == "" {
+		value = r.Header.Get(AsyncHeader)
+	}
+	async, _ := strconv.ParseBool(value)
+	return async
+}
+
+// submitJob stores the request body as input of a job and answers with the job.
+// The callback of the job is passed as query parameter callback or as header X-Btrfaas-Callback.
+func (d *FunctionDispatcher) submitJob(w http.ResponseWriter, r *http.Request, expr *expression.Expression, identity *authz.Identity) {
+	if d.Jobs == nil {
+		http.Error(w, "asynchronous calls are disabled", http.StatusNotImplemented)
+		return
+	}
+	if identity == nil {
+		writeJobError(w, jobs.ErrAnonymous)
+		return
+	}
+	callback := r.URL.Query().Get("callback")
+	if callback == "" {
+		callback = r.Header.Get(CallbackHeader)
+	}
+	callback, err := d.Jobs.PrepareCallback(callback, d.Pipelines, d.authorizeExpression(identity))
+	if err != nil {
+		http.Error(w, err.Error(), callbackStatus(err))
+		return
+	}
+	job, err := d.Jobs.Submit(&jobs.Job{Expression: expr.String(), Owner: jobs.Owner(identity), Callback: callback}, r.Body)
+	if err == jobs.ErrInputTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		log.Errorf("could not submit job: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", jobsPrefix+"/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// authorizeExpression returns a function which checks if the identity may call every function of a resolved expression
+func (d *FunctionDispatcher) authorizeExpression(identity *authz.Identity) jobs.AuthorizeFunc {
+	return func(expr *expression.Expression) error {
+		hosts, err := forwarder.ParseHostConfigs(expr.Chain(), expr.Options(), d.DefaultPort)
+		if err != nil {
+			return err
+		}
+		return d.authorize(identity, hosts)
+	}
+}
+
+// callbackStatus returns 403 for denied callbacks and 400 for malformed ones
+func callbackStatus(err error) int {
+	switch err.(type) {
+	case *authz.DeniedError, *jobs.CallbackDeniedError:
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// serveJobs serves the job API:
+// GET /api/v0/jobs lists the jobs of the caller, GET /api/v0/jobs/<id> returns a job,
+// GET /api/v0/jobs/<id>/result returns the output of a finished job and DELETE /api/v0/jobs/<id> cancels a job.
+// GET /api/v0/jobs/dead-letters lists the failed jobs of the caller and POST /api/v0/jobs/<id>/retry runs one again.
+func (d *FunctionDispatcher) serveJobs(w http.ResponseWriter, r *http.Request) {
+	if d.Jobs == nil {
+		http.Error(w, "asynchronous calls are disabled", http.StatusNotImplemented)
+		return
+	}
+	identity, ok := d.authenticate(r)
+	if !ok {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	if identity == nil {
+		writeJobError(w, jobs.ErrAnonymous)
+		return
+	}
+	owner := jobs.Owner(identity)
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, jobsPrefix), "/"), "/")
+	switch {
+	case parts[0] == "dead-letters" && len(parts) == 1 && r.Method == http.MethodGet:
+		letters, err := d.Jobs.DeadLetters(owner)
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, letters)
+	case parts[0] == "" && r.Method == http.MethodGet:
+		list, err := d.Jobs.List(owner)
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		job, err := d.Jobs.Get(parts[0], owner)
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		job, err := d.Jobs.Cancel(parts[0], owner)
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	case len(parts) == 2 && parts[1] == "retry" && r.Method == http.MethodPost:
+		job, err := d.Jobs.Retry(parts[0], owner, d.authorizeExpression(identity))
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+		w.Header().Set("Location", jobsPrefix+"/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	case len(parts) == 2 && parts[1] == "result" && r.Method == http.MethodGet:
+		result, err := d.Jobs.Result(parts[0], owner)
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+		defer result.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.Copy(w, result)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func writeJobError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch err.(type) {
+	case *authz.DeniedError, *jobs.CallbackDeniedError:
+		code = http.StatusForbidden
+	}
+	switch err {
+	case jobs.ErrNotFound:
+		code = http.StatusNotFound
+	case jobs.ErrNotDone:
+		code = http.StatusConflict
+	case jobs.ErrAnonymous:
+		code = http.StatusUnauthorized
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,97 @@
+package handler_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/trusch/btrfaas/fgateway/authz"
+	. "github.com/trusch/btrfaas/fgateway/http"
+	"github.com/trusch/btrfaas/fgateway/jobs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Jobs", func() {
+	var (
+		dir        string
+		manager    *jobs.Manager
+		dispatcher *FunctionDispatcher
+		key        string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "handler")
+		Expect(err).NotTo(HaveOccurred())
+		store, err := jobs.NewFileStore(filepath.Join(dir, "jobs"))
+		Expect(err).NotTo(HaveOccurred())
+		manager = jobs.NewManager(store, func(ctx context.Context, job *jobs.Job, input io.Reader, output io.Writer) error {
+			_, err := io.Copy(output, input)
+			return err
+		}, 1)
+		token, k, err := authz.NewToken("webhook", []string{"echo"})
+		Expect(err).NotTo(HaveOccurred())
+		key = k
+		bs, err := yaml.Marshal(&authz.TokenList{Tokens: []*authz.Token{token}})
+		Expect(err).NotTo(HaveOccurred())
+		file := filepath.Join(dir, "tokens.yaml")
+		Expect(ioutil.WriteFile(file, bs, 0600)).To(Succeed())
+		tokens, err := authz.NewTokenStore(file)
+		Expect(err).NotTo(HaveOccurred())
+		dispatcher = &FunctionDispatcher{DefaultPort: 2424, Tokens: tokens, Jobs: manager}
+	})
+
+	AfterEach(func() {
+		manager.Close()
+		os.RemoveAll(dir)
+	})
+
+	call := func(method, url, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader("hello"))
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		dispatcher.ServeHTTP(w, r)
+		return w
+	}
+
+	It("should reject anonymous callers", func() {
+		Expect(call(http.MethodPost, "/api/v0/invoke/echo?async=true", "").Code).To(Equal(http.StatusUnauthorized))
+		Expect(call(http.MethodGet, "/api/v0/jobs", "").Code).To(Equal(http.StatusUnauthorized))
+		Expect(call(http.MethodGet, "/api/v0/jobs/dead-letters", "").Code).To(Equal(http.StatusUnauthorized))
+
+		w := call(http.MethodPost, "/api/v0/invoke/echo?async=true", key)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+		location := w.Header().Get("Location")
+		Expect(call(http.MethodGet, location, key).Code).To(Equal(http.StatusOK))
+		Expect(call(http.MethodGet, location, "").Code).To(Equal(http.StatusUnauthorized))
+		Expect(call(http.MethodDelete, location, "").Code).To(Equal(http.StatusUnauthorized))
+		Expect(call(http.MethodPost, location+"/retry", "").Code).To(Equal(http.StatusUnauthorized))
+		Expect(call(http.MethodGet, location+"/result", "").Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should deny callback URLs to hosts which are not allowed", func() {
+		w := call(http.MethodPost, "/api/v0/invoke/echo?async=true&callback=http://169.254.169.254/latest", key)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(manager.SetCallbackHosts([]string{"hooks.example.com"})).To(Succeed())
+		w = call(http.MethodPost, "/api/v0/invoke/echo?async=true&callback=https://hooks.example.com/done", key)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+	})
+
+	It("should reject inputs which exceed the maximum input size", func() {
+		manager.SetMaxInputSize(4)
+		Expect(call(http.MethodPost, "/api/v0/invoke/echo?async=true", key).Code).To(Equal(http.StatusRequestEntityTooLarge))
+		manager.SetMaxInputSize(5)
+		Expect(call(http.MethodPost, "/api/v0/invoke/echo?async=true", key).Code).To(Equal(http.StatusAccepted))
+	})
+})
@@ -0,0 +1,202 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/trusch/btrfaas/expression"
+	"github.com/trusch/btrfaas/pipeline"
+)
+
+// callbackTimeout limits how long a callback may take
+const callbackTimeout = 30 * time.Second
+
+// MaxCallbackResult is the maximum output size which is sent with a notification, larger outputs must be fetched by the job id
+const MaxCallbackResult = 1 << 20
+
+// Notification is sent to the callback of a job as JSON, URLs get it as body of a POST request and functions as input
+type Notification struct {
+	Job    *Job   `json:"job"`
+	Result []byte `json:"result,omitempty"` // output of the job, omitted if it is larger than MaxCallbackResult
+}
+
+// callbackClient doesn't follow redirects, they could lead to hosts which are not allowed
+var callbackClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// CallbackDeniedError is returned for callback URLs whose host is not allowed
+type CallbackDeniedError struct {
+	Host string
+}
+
+func (e *CallbackDeniedError) Error() string {
+	return fmt.Sprintf("callbacks to %v are not allowed", e.Host)
+}
+
+// SetCallbackHosts sets the hosts which callback URLs may point to, patterns like "*.example.com" are allowed.
+// Without hosts every callback URL is denied, callback functions are checked by the policy instead.
+func (m *Manager) SetCallbackHosts(hosts []string) error {
+	for _, host := range hosts {
+		if _, err := path.Match(host, ""); err != nil {
+			return fmt.Errorf("malformed callback host %v: %v", host, err)
+		}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.callbackHosts = hosts
+	return nil
+}
+
+// CheckCallback parses a callback like ParseCallback and denies URLs whose host is not allowed
+func (m *Manager) CheckCallback(callback string) (*expression.Expression, error) {
+	expr, err := ParseCallback(callback)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	u, _ := url.Parse(callback)
+	m.mutex.Lock()
+	hosts := m.callbackHosts
+	m.mutex.Unlock()
+	for _, host := range hosts {
+		if ok, _ := path.Match(host, u.Hostname()); ok {
+			return nil, nil
+		}
+		if ok, _ := path.Match(host, u.Host); ok {
+			return nil, nil
+		}
+	}
+	return nil, &CallbackDeniedError{Host: u.Host}
+}
+
+// PrepareCallback checks the callback of a new job and returns the callback to store with it.
+// URLs must point to an allowed host, callback functions are resolved with pipelines and checked with authorize.
+func (m *Manager) PrepareCallback(callback string, pipelines *pipeline.Store, authorize AuthorizeFunc) (string, error) {
+	if callback == "" {
+		return "", nil
+	}
+	expr, err := m.CheckCallback(callback)
+	if err != nil || expr == nil {
+		return callback, err
+	}
+	if expr, err = pipelines.Resolve(expr); err != nil {
+		return "", err
+	}
+	if err = authorize(expr); err != nil {
+		return "", err
+	}
+	return expr.String(), nil
+}
+
+// authorize checks the resolved expression and the callback of a stored job with authorize
+func (m *Manager) authorize(job *Job, authorize AuthorizeFunc) error {
+	expr, err := expression.Parse(job.Expression)
+	if err != nil {
+		return err
+	}
+	if err = authorize(expr); err != nil {
+		return err
+	}
+	if job.Callback == "" {
+		return nil
+	}
+	if expr, err = m.CheckCallback(job.Callback); err != nil || expr == nil {
+		return err
+	}
+	return authorize(expr)
+}
+
+// ParseCallback checks a callback, it returns the expression of a callback function and nil for a URL
+func ParseCallback(callback string) (*expression.Expression, error) {
+	if isURL(callback) {
+		if u, err := url.Parse(callback); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("malformed callback URL %v", callback)
+		}
+		return nil, nil
+	}
+	expr, err := expression.Parse(callback)
+	if err != nil {
+		return nil, fmt.Errorf("malformed callback: %v", err)
+	}
+	return expr, nil
+}
+
+func isURL(callback string) bool {
+	return strings.HasPrefix(callback, "http://") || strings.HasPrefix(callback, "https://")
+}
+
+// notify sends the notification of a finished job to its callback, a failed callback is kept in job.CallbackError
+func (m *Manager) notify(job *Job) {
+	if job.Callback == "" || m.ctx.Err() != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(m.ctx, callbackTimeout)
+	defer cancel()
+	body, err := m.notification(job)
+	if err == nil {
+		if isURL(job.Callback) {
+			// the allowed hosts may have changed since the job was submitted
+			if _, err = m.CheckCallback(job.Callback); err == nil {
+				err = post(ctx, job.Callback, body)
+			}
+		} else {
+			// the output of callback functions is discarded
+			err = m.run(ctx, &Job{ID: job.ID, Expression: job.Callback, Owner: job.Owner}, bytes.NewReader(body), ioutil.Discard)
+		}
+	}
+	if err != nil {
+		log.Warnf("callback of job %v failed: %v", job.ID, err)
+		job.CallbackError = err.Error()
+		m.update(job)
+	}
+}
+
+func (m *Manager) notification(job *Job) ([]byte, error) {
+	snapshot := *job
+	notification := &Notification{Job: &snapshot}
+	if job.OutputSize <= MaxCallbackResult {
+		result, err := m.store.Result(job.ID)
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+		if err == nil {
+			notification.Result, err = ioutil.ReadAll(io.LimitReader(result, MaxCallbackResult))
+			result.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return json.Marshal(notification)
+}
+
+func post(ctx context.Context, target string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := callbackClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("callback answered %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"time"
+
+	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+)
+
+// DeadLetter is a failed job which can be retried, the input of the job is kept in the store until then
+type DeadLetter struct {
+	ID         string              `json:"id"`
+	Expression string              `json:"expression"`
+	Owner      string              `json:"owner,omitempty"`
+	Input      string              `json:"input"` // reference to the stored input, set by the store
+	InputSize  int64               `json:"input_size"`
+	Attempts   int                 `json:"attempts"`
+	Failed     time.Time           `json:"failed"`
+	Error      string              `json:"error"`
+	Status     *btrfaasgrpc.Status `json:"status,omitempty"`
+}
+
+// newDeadLetter returns the dead letter of a failed job
+func newDeadLetter(job *Job) *DeadLetter {
+	letter := &DeadLetter{
+		ID:         job.ID,
+		Expression: job.Expression,
+		Owner:      job.Owner,
+		InputSize:  job.InputSize,
+		Attempts:   job.Attempts,
+		Failed:     time.Now().UTC(),
+		Error:      job.Error,
+		Status:     job.Status,
+	}
+	if job.Finished != nil {
+		letter.Failed = *job.Finished
+	}
+	return letter
+}
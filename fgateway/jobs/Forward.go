@@ -0,0 +1,41 @@
+package jobs
+
+import (
+	"context"
+	"io"
+
+	"github.com/trusch/btrfaas/expression"
+	"github.com/trusch/btrfaas/fgateway/forwarder"
+	"github.com/trusch/btrfaas/fgateway/metrics"
+	"github.com/trusch/btrfaas/trace"
+)
+
+// ForwardFunc returns a RunFunc which forwards the calls of jobs to the functions like synchronous calls.
+// The expression of a job is the resolved expression, so it doesn't change if a pipeline changes afterwards.
+func ForwardFunc(defaultPort uint16) RunFunc {
+	return func(ctx context.Context, job *Job, input io.Reader, output io.Writer) (err error) {
+		ctx, span := trace.StartSpan(ctx, "fgateway.job", trace.KindInternal)
+		span.SetAttribute("btrfaas.chain", job.Expression)
+		span.SetAttribute("btrfaas.job", job.ID)
+		defer func() {
+			span.End(err)
+		}()
+		expr, err := expression.Parse(job.Expression)
+		if err != nil {
+			return err
+		}
+		hosts, err := forwarder.ParseHostConfigs(expr.Chain(), expr.Options(), defaultPort)
+		if err != nil {
+			return err
+		}
+		req := metrics.StartRequest(len(hosts))
+		err = forwarder.Forward(ctx, &forwarder.Options{
+			Hosts:      hosts,
+			Expression: expr,
+			Input:      req.Input(input),
+			Output:     req.Output(output),
+		})
+		req.Finish(ctx, err)
+		return err
+	}
+}
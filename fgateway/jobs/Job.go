@@ -0,0 +1,78 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/trusch/btrfaas/fgateway/authz"
+	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+)
+
+// State is the state of a job
+type State string
+
+// job states
+const (
+	Pending   State = "pending"
+	Running   State = "running"
+	Succeeded State = "succeeded"
+	Failed    State = "failed"
+	Canceled  State = "canceled"
+)
+
+// Job is an asynchronous function call, its input and output are kept in a Store
+type Job struct {
+	ID         string     `json:"id"`
+	Expression string     `json:"expression"`
+	Owner      string     `json:"owner,omitempty"` // name of the identity which submitted the job
+	State      State      `json:"state"`
+	Created    time.Time  `json:"created"`
+	Started    *time.Time `json:"started,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	InputSize  int64      `json:"input_size"`
+	OutputSize int64      `json:"output_size"`
+	Attempts   int        `json:"attempts"` // number of runs, retries of failed jobs run them again
+	// Callback is a URL or a function expression which is notified when the job succeeded or failed
+	Callback      string `json:"callback,omitempty"`
+	CallbackError string `json:"callback_error,omitempty"`
+	// Error and Status describe why a job failed
+	Error  string              `json:"error,omitempty"`
+	Status *btrfaasgrpc.Status `json:"status,omitempty"`
+}
+
+// Done returns true if the job won't change anymore
+func (job *Job) Done() bool {
+	return job.State == Succeeded || job.State == Failed || job.State == Canceled
+}
+
+// newID returns a random job id
+func newID() (string, error) {
+	bs := make([]byte, 12)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
+
+// validID returns true for ids created by newID, so that ids can't be used to escape the store
+func validID(id string) bool {
+	if len(id) != 24 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Owner returns the owner of the jobs of an identity, API keys and certificates with the same name are different owners.
+// Anonymous callers have no owner.
+func Owner(identity *authz.Identity) string {
+	switch {
+	case identity == nil:
+		return ""
+	case identity.Scopes != nil:
+		return "token:" + identity.Name
+	default:
+		return identity.Name
+	}
+}
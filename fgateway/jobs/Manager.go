@@ -0,0 +1,378 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/trusch/btrfaas/expression"
+	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+)
+
+// ErrNotDone is returned if the result of a job is requested before the job is done
+var ErrNotDone = errors.New("job is not done yet")
+
+// ErrAnonymous is returned for anonymous callers, they all would share the same jobs
+var ErrAnonymous = errors.New("asynchronous calls require a client certificate or an API key")
+
+// RunFunc runs the expression of a job
+type RunFunc func(ctx context.Context, job *Job, input io.Reader, output io.Writer) error
+
+// AuthorizeFunc checks if the caller may call every function of a resolved expression
+type AuthorizeFunc func(expr *expression.Expression) error
+
+// Manager runs jobs in the background, at most concurrency jobs run at the same time, the others are pending.
+// Jobs are only visible to their owner, anonymous callers can't use jobs. Failed jobs are kept as dead letters until they are retried,
+// the callback of a job is notified when it succeeded or failed.
+type Manager struct {
+	store   Store
+	run     RunFunc
+	slots   chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mutex   sync.Mutex
+	running map[string]*execution
+	wg      sync.WaitGroup
+
+	callbackHosts []string
+
+	retention           time.Duration
+	deadLetterRetention time.Duration
+	maxInputSize        int64
+}
+
+// execution is a job which is pending or running
+type execution struct {
+	cancel   context.CancelFunc
+	canceled bool
+	done     chan struct{}
+}
+
+// NewManager returns a manager which runs the jobs with run and keeps them in store
+func NewManager(store Store, run RunFunc, concurrency int) *Manager {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		store:   store,
+		run:     run,
+		slots:   make(chan struct{}, concurrency),
+		ctx:     ctx,
+		cancel:  cancel,
+		running: make(map[string]*execution),
+	}
+}
+
+// Recover marks jobs which were pending or running when the gateway stopped as failed, their callbacks are not notified
+func (m *Manager) Recover() error {
+	jobs, err := m.store.List()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if job.Done() {
+			continue
+		}
+		job.Error = fmt.Sprintf("the gateway stopped while the job was %v", job.State)
+		m.finish(job, Failed)
+		m.deadLetter(job)
+	}
+	return nil
+}
+
+// Submit stores the input of a job and starts it in the background, Expression, Owner and Callback of the job must be set
+func (m *Manager) Submit(job *Job, input io.Reader) (*Job, error) {
+	if job.Owner == "" {
+		return nil, ErrAnonymous
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	job.ID = id
+	job.State = Pending
+	job.Created = time.Now().UTC()
+	job.Attempts = 0
+	m.mutex.Lock()
+	maxInputSize := m.maxInputSize
+	m.mutex.Unlock()
+	if maxInputSize > 0 {
+		input = &limitedReader{r: input, n: maxInputSize}
+	}
+	if err = m.store.Create(job, input); err == ErrInputTooLarge {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not store the job input: %v", err)
+	}
+	log.Infof("submitted job %v: %v", job.ID, job.Expression)
+	// the running job is modified in the background, the caller gets a snapshot
+	snapshot := *job
+	m.start(job)
+	return &snapshot, nil
+}
+
+// Get returns a job of the owner
+func (m *Manager) Get(id, owner string) (*Job, error) {
+	if owner == "" {
+		return nil, ErrAnonymous
+	}
+	job, err := m.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Owner != owner {
+		return nil, ErrNotFound
+	}
+	return job, nil
+}
+
+// List returns the jobs of the owner, oldest first
+func (m *Manager) List(owner string) ([]*Job, error) {
+	if owner == "" {
+		return nil, ErrAnonymous
+	}
+	jobs, err := m.store.List()
+	if err != nil {
+		return nil, err
+	}
+	res := []*Job{}
+	for _, job := range jobs {
+		if job.Owner == owner {
+			res = append(res, job)
+		}
+	}
+	return res, nil
+}
+
+// Result returns the output of a finished job, the output of failed jobs may be incomplete
+func (m *Manager) Result(id, owner string) (io.ReadCloser, error) {
+	job, err := m.Get(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	if !job.Done() {
+		return nil, ErrNotDone
+	}
+	return m.store.Result(id)
+}
+
+// Cancel cancels a pending or running job and waits until it stopped, jobs which are done are not changed
+func (m *Manager) Cancel(id, owner string) (*Job, error) {
+	if _, err := m.Get(id, owner); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	exec, ok := m.running[id]
+	if ok {
+		exec.canceled = true
+		exec.cancel()
+	}
+	m.mutex.Unlock()
+	if ok {
+		<-exec.done
+	}
+	return m.store.Get(id)
+}
+
+// DeadLetters returns the failed jobs of the owner which can be retried, oldest failure first
+func (m *Manager) DeadLetters(owner string) ([]*DeadLetter, error) {
+	if owner == "" {
+		return nil, ErrAnonymous
+	}
+	letters, err := m.store.ListDeadLetters()
+	if err != nil {
+		return nil, err
+	}
+	res := []*DeadLetter{}
+	for _, letter := range letters {
+		if letter.Owner == owner {
+			res = append(res, letter)
+		}
+	}
+	return res, nil
+}
+
+// Retry runs a failed job again with its stored input, the job leaves the dead-letter store until it fails again.
+// The expression and the callback of the job are checked with authorize, the caller may have lost the right to call its functions.
+func (m *Manager) Retry(id, owner string, authorize AuthorizeFunc) (*Job, error) {
+	job, err := m.Get(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.authorize(job, authorize); err != nil {
+		return nil, err
+	}
+	// the lock makes sure that a dead letter is retried once
+	m.mutex.Lock()
+	letter, err := m.store.GetDeadLetter(id)
+	if err == nil && letter.Owner != owner {
+		err = ErrNotFound
+	}
+	if _, ok := m.running[id]; ok && err == nil {
+		// the callback of the failed attempt is still running
+		err = ErrNotDone
+	}
+	if err == nil {
+		err = m.store.DeleteDeadLetter(id)
+	}
+	if err == nil {
+		// the job is pending before the lock is released, so Cleanup doesn't remove it
+		job, err = m.reset(id)
+	}
+	m.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("retrying job %v after %v attempts", job.ID, job.Attempts)
+	snapshot := *job
+	m.start(job)
+	return &snapshot, nil
+}
+
+// reset marks a failed job as pending again
+func (m *Manager) reset(id string) (*Job, error) {
+	job, err := m.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	job.State = Pending
+	job.Started = nil
+	job.Finished = nil
+	job.OutputSize = 0
+	job.Error = ""
+	job.Status = nil
+	job.CallbackError = ""
+	m.update(job)
+	return job, nil
+}
+
+// Close cancels all jobs and waits until they stopped, they are marked as failed
+func (m *Manager) Close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+func (m *Manager) start(job *Job) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	exec := &execution{cancel: cancel, done: make(chan struct{})}
+	m.mutex.Lock()
+	m.running[job.ID] = exec
+	m.mutex.Unlock()
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(exec.done)
+		defer func() {
+			cancel()
+			m.mutex.Lock()
+			delete(m.running, job.ID)
+			m.mutex.Unlock()
+		}()
+		m.execute(ctx, job, exec)
+	}()
+}
+
+// execute waits for a free slot and runs the job
+func (m *Manager) execute(ctx context.Context, job *Job, exec *execution) {
+	select {
+	case m.slots <- struct{}{}:
+		defer func() {
+			<-m.slots
+		}()
+	case <-ctx.Done():
+		m.fail(job, exec, ctx.Err())
+		return
+	}
+	now := time.Now().UTC()
+	job.Started = &now
+	job.State = Running
+	job.Attempts++
+	m.update(job)
+	if err := m.runJob(ctx, job); err != nil {
+		m.fail(job, exec, err)
+		return
+	}
+	m.finish(job, Succeeded)
+	log.Infof("job %v succeeded", job.ID)
+	m.notify(job)
+}
+
+func (m *Manager) runJob(ctx context.Context, job *Job) error {
+	input, err := m.store.Input(job.ID)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	output, err := m.store.Output(job.ID)
+	if err != nil {
+		return err
+	}
+	counter := &countingWriter{w: output}
+	err = m.run(ctx, job, input, counter)
+	job.OutputSize = counter.n
+	if e := output.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// fail marks a job as canceled if the owner canceled it, otherwise as failed.
+// Failed jobs become dead letters, the callback is only notified if the gateway is still running.
+func (m *Manager) fail(job *Job, exec *execution, err error) {
+	m.mutex.Lock()
+	canceled := exec.canceled
+	m.mutex.Unlock()
+	switch {
+	case canceled:
+		job.Error = "canceled"
+		m.finish(job, Canceled)
+	case m.ctx.Err() != nil:
+		job.Error = "the gateway stopped while the job was running"
+		m.finish(job, Failed)
+		m.deadLetter(job)
+	default:
+		job.Error = err.Error()
+		job.Status = btrfaasgrpc.StatusFromError(err)
+		m.finish(job, Failed)
+		m.deadLetter(job)
+		m.notify(job)
+	}
+	log.Warnf("job %v %v: %v", job.ID, job.State, job.Error)
+}
+
+func (m *Manager) deadLetter(job *Job) {
+	if err := m.store.PutDeadLetter(newDeadLetter(job)); err != nil {
+		log.Errorf("could not save dead letter of job %v: %v", job.ID, err)
+	}
+}
+
+func (m *Manager) finish(job *Job, state State) {
+	now := time.Now().UTC()
+	job.Finished = &now
+	job.State = state
+	m.update(job)
+}
+
+func (m *Manager) update(job *Job) {
+	if err := m.store.Update(job); err != nil {
+		log.Errorf("could not save job %v: %v", job.ID, err)
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
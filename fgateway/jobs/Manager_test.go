@@ -0,0 +1,451 @@
+package jobs_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/trusch/btrfaas/expression"
+	. "github.com/trusch/btrfaas/fgateway/jobs"
+	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Manager", func() {
+	var (
+		dir     string
+		store   Store
+		manager *Manager
+		flaky   int32
+		notify  chan string
+	)
+
+	// run upper cases its input, the expression "fail" fails, "flaky" fails once and "block" runs until it is canceled.
+	// The input of the expression "notify" is sent to the notify channel.
+	run := func(ctx context.Context, job *Job, input io.Reader, output io.Writer) error {
+		switch job.Expression {
+		case "fail":
+			return &btrfaasgrpc.StatusError{Status: &btrfaasgrpc.Status{FunctionId: "fail", ExitCode: 2, Message: "broken"}}
+		case "flaky":
+			if atomic.AddInt32(&flaky, 1) == 1 {
+				return errors.New("unavailable")
+			}
+		case "block":
+			<-ctx.Done()
+			return ctx.Err()
+		case "notify":
+			bs, err := ioutil.ReadAll(input)
+			notify <- string(bs)
+			return err
+		}
+		bs, err := ioutil.ReadAll(input)
+		if err != nil {
+			return err
+		}
+		_, err = output.Write(bytes.ToUpper(bs))
+		return err
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "jobs")
+		Expect(err).NotTo(HaveOccurred())
+		store, err = NewFileStore(dir)
+		Expect(err).NotTo(HaveOccurred())
+		manager = NewManager(store, run, 2)
+		flaky = 0
+		notify = make(chan string, 1)
+	})
+
+	AfterEach(func() {
+		manager.Close()
+		os.RemoveAll(dir)
+	})
+
+	allow := func(expr *expression.Expression) error { return nil }
+
+	submit := func(expr, owner, input string) *Job {
+		job, err := manager.Submit(&Job{Expression: expr, Owner: owner}, strings.NewReader(input))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(job.ID).To(HaveLen(24))
+		return job
+	}
+
+	waitFor := func(id, owner string, state State) *Job {
+		var job *Job
+		Eventually(func() State {
+			var err error
+			job, err = manager.Get(id, owner)
+			Expect(err).NotTo(HaveOccurred())
+			return job.State
+		}, 5*time.Second, 10*time.Millisecond).Should(Equal(state))
+		return job
+	}
+
+	It("should run jobs in the background and keep their output", func() {
+		job := submit("upper", "alice", "hello")
+		job = waitFor(job.ID, "alice", Succeeded)
+		Expect(job.InputSize).To(Equal(int64(5)))
+		Expect(job.OutputSize).To(Equal(int64(5)))
+		Expect(job.Started).NotTo(BeNil())
+		Expect(job.Finished).NotTo(BeNil())
+		result, err := manager.Result(job.ID, "alice")
+		Expect(err).NotTo(HaveOccurred())
+		defer result.Close()
+		bs, _ := ioutil.ReadAll(result)
+		Expect(string(bs)).To(Equal("HELLO"))
+	})
+
+	It("should keep the status of failed jobs", func() {
+		job := submit("fail", "alice", "")
+		job = waitFor(job.ID, "alice", Failed)
+		Expect(job.Error).To(ContainSubstring("broken"))
+		Expect(job.Status).NotTo(BeNil())
+		Expect(job.Status.FunctionId).To(Equal("fail"))
+		Expect(job.Status.ExitCode).To(Equal(int32(2)))
+	})
+
+	It("should cancel jobs", func() {
+		job := submit("block", "alice", "")
+		waitFor(job.ID, "alice", Running)
+		_, err := manager.Result(job.ID, "alice")
+		Expect(err).To(Equal(ErrNotDone))
+		job, err = manager.Cancel(job.ID, "alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(job.State).To(Equal(Canceled))
+	})
+
+	It("should cancel pending jobs", func() {
+		// both slots are taken
+		waitFor(submit("block", "alice", "").ID, "alice", Running)
+		waitFor(submit("block", "alice", "").ID, "alice", Running)
+		job := submit("block", "alice", "")
+		Expect(job.State).To(Equal(Pending))
+		job, err := manager.Cancel(job.ID, "alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(job.State).To(Equal(Canceled))
+		Expect(job.Started).To(BeNil())
+	})
+
+	It("should only show jobs to their owner", func() {
+		job := submit("upper", "alice", "hello")
+		submit("upper", "token:alice", "hello")
+		_, err := manager.Get(job.ID, "token:alice")
+		Expect(err).To(Equal(ErrNotFound))
+		_, err = manager.Result(job.ID, "bob")
+		Expect(err).To(Equal(ErrNotFound))
+		_, err = manager.Cancel(job.ID, "token:bob")
+		Expect(err).To(Equal(ErrNotFound))
+		jobs, err := manager.List("alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].ID).To(Equal(job.ID))
+	})
+
+	It("should reject anonymous callers", func() {
+		job := submit("upper", "alice", "hello")
+		waitFor(job.ID, "alice", Succeeded)
+		_, err := manager.Submit(&Job{Expression: "upper"}, strings.NewReader("hello"))
+		Expect(err).To(Equal(ErrAnonymous))
+		_, err = manager.Get(job.ID, Owner(nil))
+		Expect(err).To(Equal(ErrAnonymous))
+		_, err = manager.List("")
+		Expect(err).To(Equal(ErrAnonymous))
+		_, err = manager.Result(job.ID, "")
+		Expect(err).To(Equal(ErrAnonymous))
+		_, err = manager.Cancel(job.ID, "")
+		Expect(err).To(Equal(ErrAnonymous))
+		_, err = manager.DeadLetters("")
+		Expect(err).To(Equal(ErrAnonymous))
+		_, err = manager.Retry(job.ID, "", allow)
+		Expect(err).To(Equal(ErrAnonymous))
+	})
+
+	It("should mark jobs as failed if the gateway stops", func() {
+		job := submit("block", "alice", "")
+		waitFor(job.ID, "alice", Running)
+		manager.Close()
+		job, err := manager.Get(job.ID, "alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(job.State).To(Equal(Failed))
+		Expect(job.Error).To(ContainSubstring("gateway stopped"))
+	})
+
+	It("should recover jobs which were running when the gateway stopped", func() {
+		job := &Job{ID: "0123456789abcdef01234567", Expression: "upper", Owner: "alice", State: Running, Created: time.Now()}
+		Expect(store.Create(job, &bytes.Buffer{})).To(Succeed())
+		Expect(manager.Recover()).To(Succeed())
+		job, err := manager.Get(job.ID, "alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(job.State).To(Equal(Failed))
+		Expect(job.Error).To(Equal("the gateway stopped while the job was running"))
+	})
+
+	It("should keep failed jobs as dead letters until they are retried", func() {
+		job := submit("flaky", "alice", "hello")
+		waitFor(job.ID, "alice", Failed)
+		letters, err := manager.DeadLetters("alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(letters).To(HaveLen(1))
+		Expect(letters[0].ID).To(Equal(job.ID))
+		Expect(letters[0].Attempts).To(Equal(1))
+		Expect(letters[0].Error).To(Equal("unavailable"))
+		Expect(letters[0].Input).NotTo(BeEmpty())
+
+		_, err = manager.Retry(job.ID, "bob", allow)
+		Expect(err).To(Equal(ErrNotFound))
+		retried, err := manager.Retry(job.ID, "alice", allow)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(retried.State).To(Equal(Pending))
+		Expect(retried.Error).To(BeEmpty())
+		job = waitFor(job.ID, "alice", Succeeded)
+		Expect(job.Attempts).To(Equal(2))
+		result, err := manager.Result(job.ID, "alice")
+		Expect(err).NotTo(HaveOccurred())
+		defer result.Close()
+		bs, _ := ioutil.ReadAll(result)
+		Expect(string(bs)).To(Equal("HELLO"))
+
+		letters, err = manager.DeadLetters("alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(letters).To(BeEmpty())
+		_, err = manager.Retry(job.ID, "alice", allow)
+		Expect(err).To(Equal(ErrNotFound))
+	})
+
+	It("should not keep canceled jobs as dead letters", func() {
+		job := submit("block", "alice", "")
+		waitFor(job.ID, "alice", Running)
+		_, err := manager.Cancel(job.ID, "alice")
+		Expect(err).NotTo(HaveOccurred())
+		letters, err := manager.DeadLetters("alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(letters).To(BeEmpty())
+	})
+
+	It("should notify callback URLs", func() {
+		notifications := make(chan *Notification, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			notification := &Notification{}
+			Expect(json.NewDecoder(r.Body).Decode(notification)).To(Succeed())
+			notifications <- notification
+		}))
+		defer server.Close()
+		Expect(manager.SetCallbackHosts([]string{"127.0.0.1"})).To(Succeed())
+		job, err := manager.Submit(&Job{Expression: "upper", Owner: "alice", Callback: server.URL}, strings.NewReader("hello"))
+		Expect(err).NotTo(HaveOccurred())
+		var notification *Notification
+		Eventually(notifications, 5*time.Second).Should(Receive(&notification))
+		Expect(notification.Job.ID).To(Equal(job.ID))
+		Expect(notification.Job.State).To(Equal(Succeeded))
+		Expect(string(notification.Result)).To(Equal("HELLO"))
+	})
+
+	It("should notify callback functions about failed jobs", func() {
+		job, err := manager.Submit(&Job{Expression: "fail", Owner: "alice", Callback: "notify"}, strings.NewReader(""))
+		Expect(err).NotTo(HaveOccurred())
+		var body string
+		Eventually(notify, 5*time.Second).Should(Receive(&body))
+		notification := &Notification{}
+		Expect(json.Unmarshal([]byte(body), notification)).To(Succeed())
+		Expect(notification.Job.ID).To(Equal(job.ID))
+		Expect(notification.Job.State).To(Equal(Failed))
+		Expect(notification.Job.Status.ExitCode).To(Equal(int32(2)))
+	})
+
+	It("should keep errors of callbacks", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+		Expect(manager.SetCallbackHosts([]string{"127.0.0.1:*"})).To(Succeed())
+		job, err := manager.Submit(&Job{Expression: "upper", Owner: "alice", Callback: server.URL}, strings.NewReader("hello"))
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() string {
+			job, err := manager.Get(job.ID, "alice")
+			Expect(err).NotTo(HaveOccurred())
+			return job.CallbackError
+		}, 5*time.Second, 10*time.Millisecond).Should(ContainSubstring("503"))
+	})
+
+	It("should deny callback URLs to hosts which are not allowed", func() {
+		_, err := manager.CheckCallback("http://169.254.169.254/latest/meta-data")
+		Expect(err).To(BeAssignableToTypeOf(&CallbackDeniedError{}))
+		Expect(manager.SetCallbackHosts([]string{"hooks.example.com", "*.example.org"})).To(Succeed())
+		_, err = manager.CheckCallback("https://hooks.example.com/done")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = manager.CheckCallback("https://ci.example.org:8443/done")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = manager.CheckCallback("https://example.com/done")
+		Expect(err).To(BeAssignableToTypeOf(&CallbackDeniedError{}))
+		expr, err := manager.CheckCallback("notify")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.String()).To(Equal("notify"))
+		Expect(manager.SetCallbackHosts([]string{"["})).NotTo(Succeed())
+
+		// URLs which were allowed when the job was submitted are checked again
+		requests := int32(0)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+		}))
+		defer server.Close()
+		job, err := manager.Submit(&Job{Expression: "upper", Owner: "alice", Callback: server.URL}, strings.NewReader("hello"))
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() string {
+			job, err := manager.Get(job.ID, "alice")
+			Expect(err).NotTo(HaveOccurred())
+			return job.CallbackError
+		}, 5*time.Second, 10*time.Millisecond).Should(ContainSubstring("not allowed"))
+		Expect(atomic.LoadInt32(&requests)).To(BeZero())
+	})
+
+	It("should not follow redirects of callback URLs", func() {
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer target.Close()
+		server := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+		defer server.Close()
+		Expect(manager.SetCallbackHosts([]string{server.Listener.Addr().String()})).To(Succeed())
+		job, err := manager.Submit(&Job{Expression: "upper", Owner: "alice", Callback: server.URL}, strings.NewReader("hello"))
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() string {
+			job, err := manager.Get(job.ID, "alice")
+			Expect(err).NotTo(HaveOccurred())
+			return job.CallbackError
+		}, 5*time.Second, 10*time.Millisecond).Should(ContainSubstring("307"))
+	})
+
+	It("should authorize retries against the current policy", func() {
+		Expect(manager.SetCallbackHosts([]string{"example.com"})).To(Succeed())
+		job, err := manager.Submit(&Job{Expression: "fail", Owner: "alice", Callback: "notify"}, strings.NewReader(""))
+		Expect(err).NotTo(HaveOccurred())
+		waitFor(job.ID, "alice", Failed)
+		Eventually(notify, 5*time.Second).Should(Receive())
+		denied := errors.New("denied")
+		checked := []string{}
+		_, err = manager.Retry(job.ID, "alice", func(expr *expression.Expression) error {
+			checked = append(checked, expr.String())
+			if expr.String() == "notify" {
+				return denied
+			}
+			return nil
+		})
+		Expect(err).To(Equal(denied))
+		Expect(checked).To(Equal([]string{"fail", "notify"}))
+		letters, err := manager.DeadLetters("alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(letters).To(HaveLen(1))
+	})
+
+	It("should parse callbacks", func() {
+		expr, err := ParseCallback("https://example.com/done")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr).To(BeNil())
+		expr, err = ParseCallback("sed -e 's/a/b/' | notify")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.Chain()).To(Equal([]string{"sed", "notify"}))
+		_, err = ParseCallback("http://")
+		Expect(err).To(HaveOccurred())
+		_, err = ParseCallback("notify |")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should prepare callbacks", func() {
+		Expect(manager.SetCallbackHosts([]string{"*.example.com"})).To(Succeed())
+		denied := errors.New("denied")
+		authorize := func(expr *expression.Expression) error {
+			for _, function := range expr.Chain() {
+				if function == "secret" {
+					return denied
+				}
+			}
+			return nil
+		}
+		callback, err := manager.PrepareCallback("", nil, authorize)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(callback).To(BeEmpty())
+		callback, err = manager.PrepareCallback("https://hooks.example.com/done", nil, authorize)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(callback).To(Equal("https://hooks.example.com/done"))
+		_, err = manager.PrepareCallback("https://evil.com/done", nil, authorize)
+		Expect(err).To(BeAssignableToTypeOf(&CallbackDeniedError{}))
+		callback, err = manager.PrepareCallback("notify", nil, authorize)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(callback).To(Equal("notify"))
+		_, err = manager.PrepareCallback("notify | secret", nil, authorize)
+		Expect(err).To(Equal(denied))
+		_, err = manager.PrepareCallback("notify |", nil, authorize)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject inputs which exceed the maximum input size", func() {
+		manager.SetMaxInputSize(5)
+		_, err := manager.Submit(&Job{Expression: "upper", Owner: "alice"}, strings.NewReader("hello!"))
+		Expect(err).To(Equal(ErrInputTooLarge))
+		jobs, err := manager.List("alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(jobs).To(BeEmpty())
+		job := submit("upper", "alice", "hello")
+		waitFor(job.ID, "alice", Succeeded)
+	})
+
+	It("should remove expired jobs and dead letters", func() {
+		succeeded := submit("upper", "alice", "hello")
+		failed := submit("fail", "alice", "")
+		running := submit("block", "alice", "")
+		waitFor(succeeded.ID, "alice", Succeeded)
+		waitFor(failed.ID, "alice", Failed)
+		waitFor(running.ID, "alice", Running)
+		time.Sleep(20 * time.Millisecond)
+
+		// the dead letter keeps its job
+		manager.SetRetention(time.Millisecond, time.Hour)
+		Expect(manager.Cleanup()).To(Succeed())
+		_, err := manager.Get(succeeded.ID, "alice")
+		Expect(err).To(Equal(ErrNotFound))
+		_, err = manager.Get(failed.ID, "alice")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = manager.Get(running.ID, "alice")
+		Expect(err).NotTo(HaveOccurred())
+
+		// an expired dead letter is removed, its job is kept as long as jobs are kept
+		manager.SetRetention(0, time.Millisecond)
+		Expect(manager.Cleanup()).To(Succeed())
+		letters, err := manager.DeadLetters("alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(letters).To(BeEmpty())
+		_, err = manager.Get(failed.ID, "alice")
+		Expect(err).NotTo(HaveOccurred())
+
+		manager.SetRetention(time.Millisecond, time.Millisecond)
+		Expect(manager.Cleanup()).To(Succeed())
+		_, err = manager.Get(failed.ID, "alice")
+		Expect(err).To(Equal(ErrNotFound))
+		_, err = manager.Get(running.ID, "alice")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should fail to submit jobs whose input can't be read", func() {
+		_, err := manager.Submit(&Job{Expression: "upper", Owner: "alice"}, &failingReader{})
+		Expect(err).To(HaveOccurred())
+		jobs, err := manager.List("alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(jobs).To(BeEmpty())
+	})
+})
+
+type failingReader struct{}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection lost")
+}
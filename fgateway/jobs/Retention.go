@@ -0,0 +1,123 @@
+package jobs
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ErrInputTooLarge is returned if the input of a job exceeds the maximum input size
+var ErrInputTooLarge = errors.New("the input of the job is too large")
+
+// SetRetention sets how long finished jobs and dead letters are kept, 0 keeps them forever.
+// The job of a dead letter is kept as long as the dead letter, it holds the input of the retry.
+// Expired jobs are removed by Cleanup.
+func (m *Manager) SetRetention(jobs, deadLetters time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.retention = jobs
+	m.deadLetterRetention = deadLetters
+}
+
+// SetMaxInputSize sets the maximum size of the input of a job in bytes, 0 allows any size
+func (m *Manager) SetMaxInputSize(size int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.maxInputSize = size
+}
+
+// Cleanup removes the finished jobs and dead letters which are older than the retention with their input and output
+func (m *Manager) Cleanup() error {
+	jobs, err := m.store.List()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, job := range jobs {
+		if err = m.cleanup(job.ID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CleanupEvery runs Cleanup every interval until the manager is closed
+func (m *Manager) CleanupEvery(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.Cleanup(); err != nil {
+					log.Errorf("could not clean up jobs: %v", err)
+				}
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// cleanup removes a job if it expired, the lock makes sure that it isn't retried at the same time
+func (m *Manager) cleanup(id string, now time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.running[id]; ok {
+		// the callback of the job is still running
+		return nil
+	}
+	job, err := m.store.Get(id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !job.Done() || job.Finished == nil {
+		return nil
+	}
+	letter, err := m.store.GetDeadLetter(id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	keepLetter := err == nil && !expired(letter.Failed, m.deadLetterRetention, now)
+	if expired(*job.Finished, m.retention, now) && !keepLetter {
+		log.Infof("removing expired job %v", id)
+		return m.store.Delete(id)
+	}
+	if err == nil && !keepLetter {
+		log.Infof("removing expired dead letter of job %v", id)
+		return m.store.DeleteDeadLetter(id)
+	}
+	return nil
+}
+
+func expired(t time.Time, retention time.Duration, now time.Time) bool {
+	return retention > 0 && now.Sub(t) > retention
+}
+
+// limitedReader fails with ErrInputTooLarge as soon as more than n bytes are read
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return 0, ErrInputTooLarge
+	}
+	return n, err
+}
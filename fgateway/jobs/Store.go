@@ -0,0 +1,254 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ErrNotFound is returned for unknown jobs
+var ErrNotFound = errors.New("no such job")
+
+// Store keeps jobs with their input and output. Implementations must be safe for concurrent use.
+type Store interface {
+	// Create saves a new job and reads its input until EOF
+	Create(job *Job, input io.Reader) error
+	// Update saves the current state of a job
+	Update(job *Job) error
+	// Get returns a job or ErrNotFound
+	Get(id string) (*Job, error)
+	// List returns all jobs, oldest first
+	List() ([]*Job, error)
+	// Input returns the input of a job
+	Input(id string) (io.ReadCloser, error)
+	// Output returns a writer for the output of a job, previous output is truncated
+	Output(id string) (io.WriteCloser, error)
+	// Result returns the output of a job
+	Result(id string) (io.ReadCloser, error)
+	// PutDeadLetter saves a failed job and sets the reference to its input
+	PutDeadLetter(letter *DeadLetter) error
+	// GetDeadLetter returns a dead letter or ErrNotFound
+	GetDeadLetter(id string) (*DeadLetter, error)
+	// ListDeadLetters returns all dead letters, oldest failure first
+	ListDeadLetters() ([]*DeadLetter, error)
+	// DeleteDeadLetter removes a dead letter, the job and its input are kept
+	DeleteDeadLetter(id string) error
+	// Delete removes a job with its input, output and dead letter
+	Delete(id string) error
+}
+
+// fileStore implements Store with a directory per job which contains job.json, input, output and dead-letter.json of failed jobs
+type fileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store which keeps the jobs in dir
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir}, nil
+}
+
+func (s *fileStore) Create(job *Job, input io.Reader) error {
+	path, err := s.path(job.ID, "")
+	if err != nil {
+		return err
+	}
+	if err = os.Mkdir(path, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(path, "input"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	job.InputSize, err = io.Copy(f, input)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.RemoveAll(path)
+		return err
+	}
+	return s.Update(job)
+}
+
+func (s *fileStore) Update(job *Job) error {
+	return s.write(job.ID, "job.json", job)
+}
+
+func (s *fileStore) Get(id string) (*Job, error) {
+	job := &Job{}
+	if err := s.read(id, "job.json", job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (s *fileStore) List() ([]*Job, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+	res := []*Job{}
+	for _, id := range ids {
+		job, err := s.Get(id)
+		if err == ErrNotFound {
+			// the input of the job is still being uploaded
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, job)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Created.Before(res[j].Created)
+	})
+	return res, nil
+}
+
+func (s *fileStore) Input(id string) (io.ReadCloser, error) {
+	return s.open(id, "input")
+}
+
+func (s *fileStore) Output(id string) (io.WriteCloser, error) {
+	path, err := s.path(id, "output")
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+func (s *fileStore) Result(id string) (io.ReadCloser, error) {
+	return s.open(id, "output")
+}
+
+func (s *fileStore) PutDeadLetter(letter *DeadLetter) error {
+	path, err := s.path(letter.ID, "input")
+	if err != nil {
+		return err
+	}
+	letter.Input = path
+	return s.write(letter.ID, "dead-letter.json", letter)
+}
+
+func (s *fileStore) GetDeadLetter(id string) (*DeadLetter, error) {
+	letter := &DeadLetter{}
+	if err := s.read(id, "dead-letter.json", letter); err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
+
+func (s *fileStore) ListDeadLetters() ([]*DeadLetter, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+	res := []*DeadLetter{}
+	for _, id := range ids {
+		letter, err := s.GetDeadLetter(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, letter)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Failed.Before(res[j].Failed)
+	})
+	return res, nil
+}
+
+func (s *fileStore) DeleteDeadLetter(id string) error {
+	path, err := s.path(id, "dead-letter.json")
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *fileStore) Delete(id string) error {
+	path, err := s.path(id, "")
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+// ids returns the ids of all job directories
+func (s *fileStore) ids() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, entry := range entries {
+		if entry.IsDir() && validID(entry.Name()) {
+			res = append(res, entry.Name())
+		}
+	}
+	return res, nil
+}
+
+// write writes a JSON file of a job atomically, so that readers never see a partial file
+func (s *fileStore) write(id, name string, value interface{}) error {
+	path, err := s.path(id, name)
+	if err != nil {
+		return err
+	}
+	bs, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, bs, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *fileStore) read(id, name string, value interface{}) error {
+	path, err := s.path(id, name)
+	if err != nil {
+		return err
+	}
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, value)
+}
+
+func (s *fileStore) open(id, name string) (io.ReadCloser, error) {
+	path, err := s.path(id, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *fileStore) path(id, name string) (string, error) {
+	if !validID(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.dir, id, name), nil
+}
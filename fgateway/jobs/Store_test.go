@@ -0,0 +1,128 @@
+package jobs_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/trusch/btrfaas/fgateway/jobs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FileStore", func() {
+	var (
+		dir   string
+		store Store
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "jobs")
+		Expect(err).NotTo(HaveOccurred())
+		store, err = NewFileStore(dir)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	newJob := func(id string, created time.Time) *Job {
+		return &Job{ID: id, Expression: "echo", State: Pending, Created: created}
+	}
+
+	It("should keep jobs with their input and output", func() {
+		job := newJob("0123456789abcdef01234567", time.Now())
+		Expect(store.Create(job, strings.NewReader("input"))).To(Succeed())
+		Expect(job.InputSize).To(Equal(int64(5)))
+
+		input, err := store.Input(job.ID)
+		Expect(err).NotTo(HaveOccurred())
+		bs, _ := ioutil.ReadAll(input)
+		input.Close()
+		Expect(string(bs)).To(Equal("input"))
+
+		output, err := store.Output(job.ID)
+		Expect(err).NotTo(HaveOccurred())
+		output.Write([]byte("output"))
+		Expect(output.Close()).To(Succeed())
+		result, err := store.Result(job.ID)
+		Expect(err).NotTo(HaveOccurred())
+		bs, _ = ioutil.ReadAll(result)
+		result.Close()
+		Expect(string(bs)).To(Equal("output"))
+
+		job.State = Succeeded
+		Expect(store.Update(job)).To(Succeed())
+		stored, err := store.Get(job.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored.State).To(Equal(Succeeded))
+		Expect(stored.InputSize).To(Equal(int64(5)))
+	})
+
+	It("should list jobs oldest first", func() {
+		now := time.Now()
+		Expect(store.Create(newJob("bbbbbbbbbbbbbbbbbbbbbbbb", now), &bytes.Buffer{})).To(Succeed())
+		Expect(store.Create(newJob("aaaaaaaaaaaaaaaaaaaaaaaa", now.Add(time.Second)), &bytes.Buffer{})).To(Succeed())
+		// a job whose input is still being uploaded
+		Expect(os.Mkdir(filepath.Join(dir, "cccccccccccccccccccccccc"), 0700)).To(Succeed())
+		jobs, err := store.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(jobs).To(HaveLen(2))
+		Expect(jobs[0].ID).To(Equal("bbbbbbbbbbbbbbbbbbbbbbbb"))
+		Expect(jobs[1].ID).To(Equal("aaaaaaaaaaaaaaaaaaaaaaaa"))
+	})
+
+	It("should keep dead letters next to their job", func() {
+		now := time.Now()
+		Expect(store.Create(newJob("bbbbbbbbbbbbbbbbbbbbbbbb", now), strings.NewReader("input"))).To(Succeed())
+		Expect(store.Create(newJob("aaaaaaaaaaaaaaaaaaaaaaaa", now), strings.NewReader("input"))).To(Succeed())
+		Expect(store.PutDeadLetter(&DeadLetter{ID: "bbbbbbbbbbbbbbbbbbbbbbbb", Attempts: 2, Failed: now, Error: "broken"})).To(Succeed())
+		Expect(store.PutDeadLetter(&DeadLetter{ID: "aaaaaaaaaaaaaaaaaaaaaaaa", Attempts: 1, Failed: now.Add(time.Second)})).To(Succeed())
+
+		letters, err := store.ListDeadLetters()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(letters).To(HaveLen(2))
+		Expect(letters[0].ID).To(Equal("bbbbbbbbbbbbbbbbbbbbbbbb"))
+		Expect(letters[0].Attempts).To(Equal(2))
+		Expect(letters[0].Error).To(Equal("broken"))
+		bs, err := ioutil.ReadFile(letters[0].Input)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(bs)).To(Equal("input"))
+
+		Expect(store.DeleteDeadLetter("bbbbbbbbbbbbbbbbbbbbbbbb")).To(Succeed())
+		_, err = store.GetDeadLetter("bbbbbbbbbbbbbbbbbbbbbbbb")
+		Expect(err).To(Equal(ErrNotFound))
+		Expect(store.DeleteDeadLetter("bbbbbbbbbbbbbbbbbbbbbbbb")).To(Equal(ErrNotFound))
+		_, err = store.Get("bbbbbbbbbbbbbbbbbbbbbbbb")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should delete jobs with their input, output and dead letter", func() {
+		id := "aaaaaaaaaaaaaaaaaaaaaaaa"
+		Expect(store.Create(newJob(id, time.Now()), strings.NewReader("input"))).To(Succeed())
+		Expect(store.PutDeadLetter(&DeadLetter{ID: id, Failed: time.Now()})).To(Succeed())
+		Expect(store.Delete(id)).To(Succeed())
+		_, err := store.Get(id)
+		Expect(err).To(Equal(ErrNotFound))
+		_, err = store.GetDeadLetter(id)
+		Expect(err).To(Equal(ErrNotFound))
+		_, err = store.Input(id)
+		Expect(err).To(Equal(ErrNotFound))
+		Expect(store.Delete(id)).To(Succeed())
+	})
+
+	It("should reject unknown and malformed ids", func() {
+		for _, id := range []string{"aaaaaaaaaaaaaaaaaaaaaaaa", "../../etc/passwd", ""} {
+			_, err := store.Get(id)
+			Expect(err).To(Equal(ErrNotFound))
+			_, err = store.Result(id)
+			Expect(err).To(Equal(ErrNotFound))
+		}
+	})
+})
@@ -1,54 +1,215 @@
 package metrics
 
 import (
-	"fmt"
+	"context"
+	"io"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+)
+
+// Error classes used to label failed calls
+const (
+	// ErrorDial means the function could not be reached
+	ErrorDial = "dial"
+	// ErrorTimeout means the call took too long
+	ErrorTimeout = "timeout"
+	// ErrorFunction means the function itself failed
+	ErrorFunction = "function"
+	// ErrorCancel means the call got canceled, for example by the client or because another stage failed
+	ErrorCancel = "cancel"
 )
 
+// latencyBuckets range from 5ms to ~80s which covers everything from a tiny function to a batch job
+var latencyBuckets = prometheus.ExponentialBuckets(0.005, 2, 15)
+
 var (
-	// Create a summary to track interservice RPC latencies for three
-	// distinct services with different latency distributions. These services are
-	// differentiated via a "service" label.
-	rpcDurations = prometheus.NewSummaryVec(
-		prometheus.SummaryOpts{
-			Name:       "rpc_durations_seconds",
-			Help:       "RPC latency distributions.",
-			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
-		},
-		[]string{"function", "error"},
-	)
-
-	// The same as above, but now as a histogram, and only for the normal
-	// distribution. The buckets are targeted to the parameters of the
-	// normal distribution, with 20 buckets centered on the mean, each
-	// half-sigma wide.
-	normDomain            = 0.0002
-	normMean              = 0.00001
-	rpcDurationsHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "rpc_durations_histogram_seconds",
-		Help:    "RPC latency histogram.",
-		Buckets: prometheus.LinearBuckets(normMean-5*normDomain, .5*normDomain, 20),
+	requestDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fgateway_request_duration_seconds",
+		Help:    "Latency of whole requests (all stages of a chain), by result.",
+		Buckets: latencyBuckets,
+	}, []string{"result"})
+
+	functionDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fgateway_function_duration_seconds",
+		Help:    "Latency of single function calls, by function and result.",
+		Buckets: latencyBuckets,
+	}, []string{"function", "result"})
+
+	functionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fgateway_function_errors_total",
+		Help: "Failed function calls, by function and error class (dial, timeout, function, cancel).",
+	}, []string{"function", "class"})
+
+	requestBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "fgateway_request_bytes_total",
+		Help: "Bytes received from clients.",
+	})
+
+	responseBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "fgateway_response_bytes_total",
+		Help: "Bytes sent to clients.",
 	})
+
+	requestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "fgateway_requests_in_flight",
+		Help: "Requests which are currently handled.",
+	})
+
+	functionRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fgateway_function_retries_total",
+		Help: "Retried function calls, by function.",
+	}, []string{"function"})
+
+	connections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "fgateway_function_connections",
+		Help: "Pooled gRPC connections to functions.",
+	})
+
+	chainLengths = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "fgateway_chain_length",
+		Help:    "Number of functions per request.",
+		Buckets: prometheus.LinearBuckets(1, 1, 10),
+	})
+
+	// CertificateExpiry holds the expiry times of the loaded certificates, see tlsconfig.Reloader.ExportExpiry
+	CertificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fgateway_certificate_expiry_timestamp_seconds",
+		Help: "Expiry time of the loaded certificates as unix timestamp, by certificate.",
+	}, []string{"certificate"})
 )
 
 func init() {
-	// Register the summary and the histogram with Prometheus's default registry.
-	prometheus.MustRegister(rpcDurations)
-	prometheus.MustRegister(rpcDurationsHistogram)
+	prometheus.MustRegister(requestDurations)
+	prometheus.MustRegister(functionDurations)
+	prometheus.MustRegister(functionErrors)
+	prometheus.MustRegister(requestBytes)
+	prometheus.MustRegister(responseBytes)
+	prometheus.MustRegister(requestsInFlight)
+	prometheus.MustRegister(functionRetries)
+	prometheus.MustRegister(connections)
+	prometheus.MustRegister(chainLengths)
+	prometheus.MustRegister(CertificateExpiry)
+}
+
+// Request tracks a single request to the gateway
+type Request struct {
+	start time.Time
+	in    int64
+	out   int64
+}
+
+// StartRequest starts tracking a request which calls a chain of the given length
+func StartRequest(chainLength int) *Request {
+	requestsInFlight.Inc()
+	chainLengths.Observe(float64(chainLength))
+	return &Request{start: time.Now()}
+}
+
+// Input wraps the input of the request to count its bytes
+func (r *Request) Input(input io.Reader) io.Reader {
+	return &countingReader{input, &r.in}
 }
 
-// Observe observes a function invocation duration
-func Observe(fn string, err bool, duration time.Duration) {
-	errLabel := fmt.Sprintf("%v", err)
-	rpcDurations.WithLabelValues(fn, errLabel).Observe(duration.Seconds())
-	rpcDurationsHistogram.Observe(duration.Seconds())
+// Output wraps the output of the request to count its bytes
+func (r *Request) Output(output io.Writer) io.Writer {
+	return &countingWriter{output, &r.out}
+}
+
+// Finish observes the end of the request
+func (r *Request) Finish(ctx context.Context, err error) {
+	requestsInFlight.Dec()
+	requestDurations.WithLabelValues(result(ctx, err)).Observe(time.Since(r.start).Seconds())
+	requestBytes.Add(float64(atomic.LoadInt64(&r.in)))
+	responseBytes.Add(float64(atomic.LoadInt64(&r.out)))
+}
+
+// ObserveFunction observes a single function call
+func ObserveFunction(ctx context.Context, fn string, err error, duration time.Duration) {
+	res := result(ctx, err)
+	functionDurations.WithLabelValues(fn, res).Observe(duration.Seconds())
+	if err != nil {
+		functionErrors.WithLabelValues(fn, res).Inc()
+	}
+}
+
+// ObserveDialError observes a function which could not be reached
+func ObserveDialError(fn string) {
+	functionErrors.WithLabelValues(fn, ErrorDial).Inc()
+}
+
+// ObserveRetry observes a retried function call
+func ObserveRetry(fn string) {
+	functionRetries.WithLabelValues(fn).Inc()
+}
+
+// SetConnections sets the number of pooled connections to functions
+func SetConnections(n int) {
+	connections.Set(float64(n))
+}
+
+// ErrorClass returns the class of an error returned by a call with the given context
+func ErrorClass(ctx context.Context, err error) string {
+	if e, ok := err.(*btrfaasgrpc.StatusError); ok {
+		err = e.Err
+	}
+	switch {
+	case err == context.DeadlineExceeded || ctx.Err() == context.DeadlineExceeded:
+		return ErrorTimeout
+	case err == context.Canceled || ctx.Err() == context.Canceled:
+		return ErrorCancel
+	}
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Unavailable:
+			return ErrorDial
+		case codes.DeadlineExceeded:
+			return ErrorTimeout
+		case codes.Canceled:
+			return ErrorCancel
+		}
+	}
+	return ErrorFunction
+}
+
+// result is "success" or the error class
+func result(ctx context.Context, err error) string {
+	if err == nil {
+		return "success"
+	}
+	return ErrorClass(ctx, err)
 }
 
 // Handler returns a prometheus /metrics handler
 func Handler() http.Handler {
 	return promhttp.Handler()
 }
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
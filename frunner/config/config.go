@@ -10,6 +10,8 @@ import (
 
 	"github.com/spf13/pflag"
 	"github.com/trusch/btrfaas/frunner/env"
+	"github.com/trusch/btrfaas/frunner/runnable/exec"
+	"github.com/trusch/btrfaas/tlsconfig"
 )
 
 // Config contains the common config for frunner
@@ -21,6 +23,17 @@ type Config struct {
 	CallTimeout           *time.Duration
 	ReadLimit             *int64
 	Buffer                *bool
+	Stderr                *string
+	Workers               *int
+	MaxConcurrency        *int
+	QueueSize             *int
+	QueueTimeout          *time.Duration
+	MetricsAddr           *string
+	HealthAddr            *string
+	Probe                 *bool
+	OTLPEndpoint          *string
+	ShutdownGracePeriod   *time.Duration
+	TLS                   *tlsconfig.Config
 }
 
 // New creates a new config object
@@ -34,13 +47,29 @@ func New() (*Config, error) {
 		CallTimeout:           flags.DurationP("call-timeout", "t", 0*time.Second, "function call timeout"),
 		ReadLimit:             flags.Int64("read-limit", -1, "limit the amount of data which can be contained in a requests body"),
 		Buffer:                flags.BoolP("buffer", "b", false, "buffer output before writing"),
+		Stderr:                flags.String("stderr", string(exec.DefaultStderrMode), "where to write stderr of the function: log, status or output"),
+		Workers:               flags.Int("workers", 0, "number of persistent worker processes, 0 forks a process per call"),
+		MaxConcurrency:        flags.Int("max-concurrency", 0, "maximum number of concurrent calls, 0 means unlimited"),
+		QueueSize:             flags.Int("queue-size", 0, "number of calls which may wait for a free slot when max-concurrency is reached"),
+		QueueTimeout:          flags.Duration("queue-timeout", 0*time.Second, "maximum time a call waits for a free slot, 0 waits until the call times out"),
+		MetricsAddr:           flags.String("metrics-addr", ":8000", "prometheus metrics listen address"),
+		HealthAddr:            flags.String("health-addr", "127.0.0.1:2425", "listen address of the grpc.health.v1 service for probes in the container, empty to disable it"),
+		Probe:                 flags.Bool("probe", false, "check the health service at --health-addr and exit with 0 if frunner is serving"),
+		OTLPEndpoint:          flags.String("otlp-endpoint", "", "OpenTelemetry collector to export traces to via OTLP/HTTP, like http://otel-collector:4318"),
+		ShutdownGracePeriod:   flags.Duration("shutdown-grace-period", 30*time.Second, "time running calls get to finish on SIGTERM before they are canceled"),
+		TLS:                   tlsconfig.New("btrfaas-function"),
 	}
+	cfg.TLS.AddFlags(flags, "tls", "grpc server")
+	flags.BoolVar(&cfg.TLS.Insecure, "insecure", false, "serve grpc without TLS, for local development only")
 	if err := cfg.parseCommandline(); err != nil {
 		return nil, err
 	}
 	if err := cfg.parseEnvironment(); err != nil {
 		return nil, err
 	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
@@ -86,6 +115,75 @@ func (cfg *Config) parseEnvironment() error {
 		v := true
 		cfg.Buffer = &v
 	}
+	if val, ok := env["FRUNNER_STDERR"]; ok {
+		cfg.Stderr = &val
+	}
+	if val, ok := env["FRUNNER_WORKERS"]; ok {
+		d, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		cfg.Workers = &d
+	}
+	if val, ok := env["FRUNNER_MAX_CONCURRENCY"]; ok {
+		d, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		cfg.MaxConcurrency = &d
+	}
+	if val, ok := env["FRUNNER_QUEUE_SIZE"]; ok {
+		d, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		cfg.QueueSize = &d
+	}
+	if val, ok := env["FRUNNER_QUEUE_TIMEOUT"]; ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		cfg.QueueTimeout = &d
+	}
+	if val, ok := env["FRUNNER_METRICS_ADDRESS"]; ok {
+		cfg.MetricsAddr = &val
+	}
+	if val, ok := env["FRUNNER_HEALTH_ADDRESS"]; ok {
+		cfg.HealthAddr = &val
+	}
+	if val, ok := env["FRUNNER_OTLP_ENDPOINT"]; ok {
+		cfg.OTLPEndpoint = &val
+	}
+	if val, ok := env["FRUNNER_SHUTDOWN_GRACE_PERIOD"]; ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		cfg.ShutdownGracePeriod = &d
+	}
+	return cfg.TLS.LoadEnvironment("FRUNNER_TLS")
+}
+
+// validate checks the config entries which only accept a fixed set of values
+func (cfg *Config) validate() error {
+	switch *cfg.Stderr {
+	case "log", "status", "output":
+	default:
+		return fmt.Errorf("invalid stderr mode: %v", *cfg.Stderr)
+	}
+	if *cfg.Workers < 0 {
+		return fmt.Errorf("invalid number of workers: %v", *cfg.Workers)
+	}
+	if *cfg.MaxConcurrency < 0 {
+		return fmt.Errorf("invalid max concurrency: %v", *cfg.MaxConcurrency)
+	}
+	if *cfg.QueueSize < 0 {
+		return fmt.Errorf("invalid queue size: %v", *cfg.QueueSize)
+	}
+	if *cfg.ShutdownGracePeriod < 0 {
+		return fmt.Errorf("invalid shutdown grace period: %v", *cfg.ShutdownGracePeriod)
+	}
 	return nil
 }
 
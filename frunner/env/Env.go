@@ -1,11 +1,14 @@
 package env
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strings"
+
+	"github.com/trusch/btrfaas/trace"
 )
 
 // Env represents a key/value mapping of environment variables
@@ -40,6 +43,14 @@ func (env Env) AddFromHTTPRequest(r *http.Request) {
 	}
 }
 
+// AddTrace adds the trace context of ctx as TRACEPARENT and BTRFAAS_TRACE_ID
+func (env Env) AddTrace(ctx context.Context) {
+	if sc, ok := trace.SpanContextFromContext(ctx); ok {
+		env["TRACEPARENT"] = sc.Traceparent()
+		env["BTRFAAS_TRACE_ID"] = sc.TraceID.String()
+	}
+}
+
 // Copy returns a copy of the current environment
 func (env Env) Copy() Env {
 	res := make(Env)
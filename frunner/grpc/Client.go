@@ -5,6 +5,7 @@ import (
 	"io"
 
 	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
+	"github.com/trusch/btrfaas/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 )
@@ -35,6 +36,11 @@ func NewClientWithContext(ctx context.Context, target string, opts ...grpc.DialO
 	return &Client{conn, client}, nil
 }
 
+// NewClientFromConn returns a new client instance using an existing connection
+func NewClientFromConn(conn *grpc.ClientConn) *Client {
+	return &Client{conn, btrfaasgrpc.NewFunctionRunnerClient(conn)}
+}
+
 // Close closes the client connection
 func (c *Client) Close() error {
 	return c.conn.Close()
@@ -44,9 +50,11 @@ func (c *Client) Close() error {
 func (c *Client) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	ctx = metadata.NewOutgoingContext(ctx, metadata.MD{
+	md := metadata.MD{
 		"options": options,
-	})
+	}
+	trace.InjectMetadata(ctx, md)
+	ctx = metadata.NewOutgoingContext(ctx, md)
 	cli, err := c.client.Run(ctx)
 	if err != nil {
 		return err
@@ -71,7 +79,7 @@ func (c *Client) Run(ctx context.Context, options []string, input io.Reader, out
 		case err := <-done:
 			{
 				if err != nil {
-					return err
+					return btrfaasgrpc.ErrorFromTrailer(cli, err)
 				}
 				todo--
 				if todo == 0 {
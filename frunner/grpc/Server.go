@@ -3,80 +3,123 @@ package grpc
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
-	"errors"
-	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 
 	btrfaasgrpc "github.com/trusch/btrfaas/grpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/trusch/btrfaas/frunner/config"
+	"github.com/trusch/btrfaas/frunner/env"
+	"github.com/trusch/btrfaas/frunner/metrics"
 	"github.com/trusch/btrfaas/frunner/runnable"
+	"github.com/trusch/btrfaas/frunner/runnable/limit"
+	"github.com/trusch/btrfaas/health"
+	"github.com/trusch/btrfaas/shutdown"
+	"github.com/trusch/btrfaas/tlsconfig"
+	"github.com/trusch/btrfaas/trace"
 )
 
 // Server is a gRPC server which serves function calls to a specific runnable
 type Server struct {
 	cmd      runnable.Runnable
 	cfg      *config.Config
+	env      env.Env
 	grpcOpts []grpc.ServerOption
+	mutex    sync.Mutex
+	server   *grpc.Server
+	reloader *tlsconfig.Reloader
 }
 
 // NewServer returns a new server instance
 func NewServer(cmd runnable.Runnable, cfg *config.Config, opts ...grpc.ServerOption) *Server {
-	return &Server{cmd, cfg, opts}
+	server := &Server{cmd: cmd, cfg: cfg, env: make(env.Env), grpcOpts: opts}
+	if err := server.env.ReadOSEnvironment(); err != nil {
+		log.Fatal(err)
+	}
+	return server
 }
 
 // ListenAndServe start listening for requests
 func (s *Server) ListenAndServe() error {
-	lis, err := net.Listen("tcp", *s.cfg.GRPCAddr)
+	// certificates are reloaded when their files change, running calls keep their connection
+	reloader, err := tlsconfig.NewReloader(s.cfg.TLS)
 	if err != nil {
 		return err
 	}
-	certificate, err := tls.LoadX509KeyPair("/run/secrets/btrfaas-function-cert.pem", "/run/secrets/btrfaas-function-key.pem")
+	reloader.ExportExpiry(metrics.CertificateExpiry, "server")
+	reloader.Watch()
+	lis, err := net.Listen("tcp", *s.cfg.GRPCAddr)
 	if err != nil {
-		certificate, err = tls.LoadX509KeyPair("/run/secrets/btrfaas-function-cert.pem/value", "/run/secrets/btrfaas-function-key.pem/value")
-		if err != nil {
-			return fmt.Errorf("could not load server key pair %v : %v : %s", "/run/secrets/btrfaas-function-cert.pem/value", "/run/secrets/btrfaas-function-key.pem/value", err)
-		}
+		reloader.Close()
+		return err
 	}
+	grpcServer := grpc.NewServer(append(s.grpcOpts, reloader.ServerOptions(tls.RequireAndVerifyClientCert)...)...)
+	btrfaasgrpc.RegisterFunctionRunnerServer(grpcServer, s)
+	health.Register(grpcServer)
+	s.mutex.Lock()
+	s.server = grpcServer
+	s.reloader = reloader
+	s.mutex.Unlock()
+	return grpcServer.Serve(lis)
+}
 
-	// Create a certificate pool from the certificate authority
-	certPool := x509.NewCertPool()
-	ca, err := ioutil.ReadFile("/run/secrets/btrfaas-ca-cert.pem")
-	if err != nil {
-		ca, err = ioutil.ReadFile("/run/secrets/btrfaas-ca-cert.pem/value")
-		if err != nil {
-			return fmt.Errorf("could not read ca certificate: %s", err)
-		}
+// Shutdown stops accepting new calls and waits until the running calls are finished or ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	grpcServer := s.grpcServer()
+	if grpcServer == nil {
+		return nil
 	}
+	done := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	// Append the client certificates from the CA
-	if ok := certPool.AppendCertsFromPEM(ca); !ok {
-		return errors.New("failed to append client certs")
+// Close stops the server immediately
+func (s *Server) Close() error {
+	if grpcServer := s.grpcServer(); grpcServer != nil {
+		grpcServer.Stop()
 	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.reloader != nil {
+		s.reloader.Close()
+	}
+	return nil
+}
 
-	// Create the TLS credentials
-	creds := credentials.NewTLS(&tls.Config{
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		Certificates: []tls.Certificate{certificate},
-		ClientCAs:    certPool,
-	})
-	grpcServer := grpc.NewServer(grpc.Creds(creds))
-	btrfaasgrpc.RegisterFunctionRunnerServer(grpcServer, s)
-	return grpcServer.Serve(lis)
+func (s *Server) grpcServer() *grpc.Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.server
 }
 
 // Run implements the server interface implied by the btrfaas protobuf service definition
-func (s *Server) Run(stream btrfaasgrpc.FunctionRunner_RunServer) error {
+func (s *Server) Run(stream btrfaasgrpc.FunctionRunner_RunServer) (err error) {
 	log.Debug("start serving request")
-	ctx := stream.Context()
+	ctx, cancel := shutdown.Bind(stream.Context())
+	defer cancel()
+	ctx, span := trace.StartSpan(trace.ExtractMetadata(ctx), "frunner.call", trace.KindServer)
+	defer func() {
+		span.End(err)
+	}()
+	environment := s.env.Copy()
+	environment.AddTrace(ctx)
+	ctx = env.NewContext(ctx, environment)
 	if *s.cfg.CallTimeout > 0 {
 		log.Debug("set timeout of ", *s.cfg.CallTimeout, " to context")
 		c, cancel := context.WithTimeout(ctx, *s.cfg.CallTimeout)
@@ -91,7 +134,7 @@ func (s *Server) Run(stream btrfaasgrpc.FunctionRunner_RunServer) error {
 
 	var input io.Reader = inputReader
 	if *s.cfg.ReadLimit > 0 {
-		input = io.LimitReader(input, *s.cfg.ReadLimit)
+		input = metrics.LimitReader(input, *s.cfg.ReadLimit)
 	}
 
 	done := make(chan error, 5)
@@ -114,11 +157,11 @@ func (s *Server) Run(stream btrfaasgrpc.FunctionRunner_RunServer) error {
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return fail(stream, ctx.Err())
 		case err := <-done:
 			{
 				if err != nil {
-					return err
+					return fail(stream, err)
 				}
 				todo--
 				if todo == 0 {
@@ -130,6 +173,20 @@ func (s *Server) Run(stream btrfaasgrpc.FunctionRunner_RunServer) error {
 
 }
 
+// fail attaches a status describing the error to the trailer of the stream
+func fail(stream btrfaasgrpc.FunctionRunner_RunServer, err error) error {
+	if limit.IsRejected(err) {
+		err = status.Error(codes.ResourceExhausted, err.Error())
+	}
+	st := btrfaasgrpc.StatusFromError(err)
+	if e, ok := err.(*runnable.Error); ok {
+		st.ExitCode = int32(e.ExitCode)
+		st.Stderr = e.Stderr
+	}
+	stream.SetTrailer(btrfaasgrpc.StatusToMetadata(st))
+	return err
+}
+
 func getOptionsFromStream(stream btrfaasgrpc.FunctionRunner_RunServer) []string {
 	md, ok := metadata.FromIncomingContext(stream.Context())
 	if !ok {
@@ -8,7 +8,11 @@ import (
 
 	"github.com/trusch/btrfaas/frunner/config"
 	"github.com/trusch/btrfaas/frunner/env"
+	"github.com/trusch/btrfaas/frunner/metrics"
 	"github.com/trusch/btrfaas/frunner/runnable"
+	"github.com/trusch/btrfaas/frunner/runnable/limit"
+	"github.com/trusch/btrfaas/shutdown"
+	"github.com/trusch/btrfaas/trace"
 )
 
 // Server serves HTTP requests and calls the given callable
@@ -36,17 +40,20 @@ func NewServer(cmd runnable.Runnable, cfg *config.Config) *Server {
 
 func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// prepare environment
+	ctx, cancel := shutdown.Bind(context.Background())
+	defer cancel()
+	ctx, span := trace.StartSpan(trace.ExtractHTTP(ctx, r.Header), "frunner.call", trace.KindServer)
 	environment := server.env.Copy()
 	environment.AddFromHTTPRequest(r)
+	environment.AddTrace(ctx)
 
 	// prepare input data
 	var input io.Reader = r.Body
 	if *server.cfg.ReadLimit > 0 {
-		input = io.LimitReader(input, *server.cfg.ReadLimit)
+		input = metrics.LimitReader(input, *server.cfg.ReadLimit)
 	}
 
 	// create context
-	ctx := context.Background()
 	ctx = env.NewContext(ctx, environment)
 	if *server.cfg.CallTimeout > 0 {
 		c, cancel := context.WithTimeout(ctx, *server.cfg.CallTimeout)
@@ -56,6 +63,13 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// call the function
 	err := server.cmd.Run(ctx, nil, input, w)
+	span.End(err)
+	if limit.IsRejected(err) {
+		log.Print("rejected call: ", err)
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if err != nil {
 		log.Print("error while calling: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -67,3 +81,13 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (server *Server) ListenAndServe() error {
 	return server.srv.ListenAndServe()
 }
+
+// Shutdown stops accepting new calls and waits until the running calls are finished or ctx is done
+func (server *Server) Shutdown(ctx context.Context) error {
+	return server.srv.Shutdown(ctx)
+}
+
+// Close stops the server immediately
+func (server *Server) Close() error {
+	return server.srv.Close()
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"log"
+	gohttp "net/http"
 	"os"
 	"strings"
 	"time"
@@ -11,7 +13,14 @@ import (
 	"github.com/trusch/btrfaas/frunner/env"
 	"github.com/trusch/btrfaas/frunner/grpc"
 	"github.com/trusch/btrfaas/frunner/http"
+	"github.com/trusch/btrfaas/frunner/metrics"
+	"github.com/trusch/btrfaas/frunner/runnable"
 	"github.com/trusch/btrfaas/frunner/runnable/exec"
+	"github.com/trusch/btrfaas/frunner/runnable/instrument"
+	"github.com/trusch/btrfaas/frunner/runnable/limit"
+	"github.com/trusch/btrfaas/health"
+	"github.com/trusch/btrfaas/shutdown"
+	"github.com/trusch/btrfaas/trace"
 	g "google.golang.org/grpc"
 	"google.golang.org/grpc/keepalive"
 )
@@ -26,21 +35,50 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *cfg.Probe {
+		probe(*cfg.HealthAddr)
+	}
 	if err = getBinaryAndArgs(); err != nil {
 		log.Fatal(err)
 	}
 
 	cfg.Print()
 
-	cmd := exec.NewRunnable(binary, binaryArgs...)
-	if *cfg.Buffer {
-		cmd.EnableOutputBuffering()
+	var exporter *trace.OTLPExporter
+	if *cfg.OTLPEndpoint != "" {
+		log.Print("export traces to ", *cfg.OTLPEndpoint)
+		exporter = trace.NewOTLPExporter(*cfg.OTLPEndpoint, "frunner")
+		trace.SetExporter(exporter)
+	}
+
+	base, err := createRunnable(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cmd := base
+	if *cfg.MaxConcurrency > 0 {
+		cmd = limit.New(cmd, *cfg.MaxConcurrency, *cfg.QueueSize, *cfg.QueueTimeout)
+	}
+	cmd = instrument.New(cmd)
+
+	log.Print("start serving prometheus metrics on ", *cfg.MetricsAddr)
+	go func() {
+		log.Fatal(metrics.ListenAndServe(*cfg.MetricsAddr))
+	}()
+
+	if *cfg.HealthAddr != "" {
+		log.Print("start serving grpc.health.v1 for probes on ", *cfg.HealthAddr)
+		go func() {
+			log.Fatal(health.ListenAndServe(*cfg.HealthAddr))
+		}()
 	}
 
 	httpServer := http.NewServer(cmd, cfg)
 	log.Print("start listening for requests via http on ", *cfg.HTTPAddr)
 	go func() {
-		log.Fatal(httpServer.ListenAndServe())
+		if err := httpServer.ListenAndServe(); err != gohttp.ErrServerClosed {
+			log.Fatal(err)
+		}
 	}()
 
 	grpcServer := grpc.NewServer(cmd, cfg, g.KeepaliveParams(keepalive.ServerParameters{
@@ -49,9 +87,52 @@ func main() {
 	}))
 	log.Print("start listening for requests via grpc on ", *cfg.GRPCAddr)
 	go func() {
-		log.Fatal(grpcServer.ListenAndServe())
+		// a graceful stop lets ListenAndServe return without error
+		if err := grpcServer.ListenAndServe(); err != nil {
+			log.Fatal(err)
+		}
 	}()
-	select {}
+
+	health.SetServing(true)
+	shutdown.Wait()
+	log.Printf("waiting up to %v for running calls", *cfg.ShutdownGracePeriod)
+	shutdown.Drain(*cfg.ShutdownGracePeriod, grpcServer, httpServer)
+	if pool, ok := base.(*exec.Pool); ok {
+		pool.Close()
+	}
+	if exporter != nil {
+		exporter.Flush()
+	}
+}
+
+// probe exits with 0 if the frunner in this container is serving, it is the health check command of deployments
+func probe(addr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	err := health.Probe(ctx, addr)
+	cancel()
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func createRunnable(cfg *config.Config) (runnable.Runnable, error) {
+	if *cfg.Workers > 0 {
+		pool := exec.NewPool(*cfg.Workers, binary, binaryArgs...)
+		if *cfg.Buffer {
+			pool.EnableOutputBuffering()
+		}
+		pool.SetStderrMode(exec.StderrMode(*cfg.Stderr))
+		log.Printf("starting %v workers", *cfg.Workers)
+		return pool, pool.Start()
+	}
+	cmd := exec.NewRunnable(binary, binaryArgs...)
+	if *cfg.Buffer {
+		cmd.EnableOutputBuffering()
+	}
+	cmd.SetStderrMode(exec.StderrMode(*cfg.Stderr))
+	return cmd, nil
 }
 
 func getBinaryAndArgs() error {
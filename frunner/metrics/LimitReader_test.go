@@ -0,0 +1,43 @@
+package metrics_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"time"
+
+	. "github.com/trusch/btrfaas/frunner/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LimitReader", func() {
+
+	It("should truncate inputs which exceed the limit", func() {
+		data, err := ioutil.ReadAll(LimitReader(bytes.NewBufferString("foobar"), 3))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("foo"))
+	})
+
+	It("should pass inputs within the limit", func() {
+		data, err := ioutil.ReadAll(LimitReader(bytes.NewBufferString("foobar"), 6))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("foobar"))
+	})
+
+	It("should not block on open inputs which reached the limit", func() {
+		inputReader, inputWriter := io.Pipe()
+		defer inputWriter.Close()
+		go inputWriter.Write([]byte("foo"))
+		done := make(chan []byte)
+		go func() {
+			data, _ := ioutil.ReadAll(LimitReader(inputReader, 3))
+			done <- data
+		}()
+		var data []byte
+		Eventually(done, time.Second).Should(Receive(&data))
+		Expect(string(data)).To(Equal("foo"))
+	})
+
+})
@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/trusch/btrfaas/health"
+)
+
+var (
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "frunner_queue_depth",
+		Help: "Number of calls waiting for a free slot.",
+	})
+
+	activeCalls = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "frunner_active_calls",
+		Help: "Number of calls which are currently executed.",
+	})
+
+	callsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "frunner_calls_in_flight",
+		Help: "Number of calls which are currently handled, including queued ones.",
+	})
+
+	callDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "frunner_call_duration_seconds",
+		Help:    "Call latency distribution.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 15),
+	}, []string{"result"})
+
+	inputBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "frunner_input_bytes_total",
+		Help: "Number of bytes read from the input of calls.",
+	})
+
+	outputBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "frunner_output_bytes_total",
+		Help: "Number of bytes written to the output of calls.",
+	})
+
+	exitCodes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "frunner_exit_codes_total",
+		Help: "Number of finished processes by exit code.",
+	}, []string{"code"})
+
+	timeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "frunner_call_timeouts_total",
+		Help: "Number of calls which exceeded the call timeout.",
+	})
+
+	kills = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "frunner_kills_total",
+		Help: "Number of processes which got killed, by reason (timeout or cancel).",
+	}, []string{"reason"})
+
+	truncations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "frunner_read_limit_truncations_total",
+		Help: "Number of call inputs which got truncated because of the read limit.",
+	})
+
+	// CertificateExpiry holds the expiry times of the loaded certificates, see tlsconfig.Reloader.ExportExpiry
+	CertificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "frunner_certificate_expiry_timestamp_seconds",
+		Help: "Expiry time of the loaded certificates as unix timestamp, by certificate.",
+	}, []string{"certificate"})
+)
+
+func init() {
+	prometheus.MustRegister(queueDepth)
+	prometheus.MustRegister(activeCalls)
+	prometheus.MustRegister(callsInFlight)
+	prometheus.MustRegister(callDurations)
+	prometheus.MustRegister(inputBytes)
+	prometheus.MustRegister(outputBytes)
+	prometheus.MustRegister(exitCodes)
+	prometheus.MustRegister(timeouts)
+	prometheus.MustRegister(kills)
+	prometheus.MustRegister(truncations)
+	prometheus.MustRegister(CertificateExpiry)
+}
+
+// SetQueueDepth sets the number of calls waiting for a free slot
+func SetQueueDepth(n int) {
+	queueDepth.Set(float64(n))
+}
+
+// SetActiveCalls sets the number of calls which are currently executed
+func SetActiveCalls(n int) {
+	activeCalls.Set(float64(n))
+}
+
+// CallStarted marks the start of a call, the returned function marks its end
+func CallStarted() func() {
+	callsInFlight.Inc()
+	return callsInFlight.Dec
+}
+
+// ObserveCall observes a finished call.
+// result is one of "success", "error", "timeout", "canceled" or "rejected".
+func ObserveCall(result string, duration time.Duration, bytesIn, bytesOut int64) {
+	callDurations.WithLabelValues(result).Observe(duration.Seconds())
+	inputBytes.Add(float64(bytesIn))
+	outputBytes.Add(float64(bytesOut))
+	if result == "timeout" {
+		timeouts.Inc()
+	}
+}
+
+// ObserveExitCode observes the exit code of a finished process
+func ObserveExitCode(code int) {
+	exitCodes.WithLabelValues(strconv.Itoa(code)).Inc()
+}
+
+// ObserveKill observes a process which got killed because its context is done
+func ObserveKill(err error) {
+	reason := "cancel"
+	if err == context.DeadlineExceeded {
+		reason = "timeout"
+	}
+	kills.WithLabelValues(reason).Inc()
+}
+
+// LimitReader works like io.LimitReader but counts inputs which got truncated.
+// It never reads beyond the limit after it is reached, since that would block on open input streams,
+// so a truncation is only counted if the excess data arrives together with the data before the limit.
+func LimitReader(r io.Reader, n int64) io.Reader {
+	return &limitReader{r, n}
+}
+
+type limitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, io.EOF
+	}
+	// read one byte more than allowed to find out if the input exceeds the limit
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		truncations.Inc()
+		n = int(l.n)
+		l.n = 0
+		return n, io.EOF
+	}
+	l.n -= int64(n)
+	return n, err
+}
+
+// Close closes the underlying reader if it is an io.Closer, this unblocks pending reads of aborted calls
+func (l *limitReader) Close() error {
+	if closer, ok := l.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Handler returns a prometheus /metrics handler
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ListenAndServe serves the prometheus metrics on /metrics and the health of the process on /healthz
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	mux.Handle(health.Path, health.Handler())
+	return http.ListenAndServe(addr, mux)
+}
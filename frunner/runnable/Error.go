@@ -0,0 +1,12 @@
+package runnable
+
+// Error is returned by runnables which can provide details about a failed call
+type Error struct {
+	Err      error
+	ExitCode int
+	Stderr   []byte
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
@@ -0,0 +1,37 @@
+package chain
+
+import (
+	"context"
+	"io"
+
+	"github.com/trusch/btrfaas/frunner/runnable"
+)
+
+// Bind returns a runnable which runs cmd with the given options followed by the options of the call
+func Bind(cmd runnable.Runnable, options []string) runnable.Runnable {
+	return &bound{cmd, options}
+}
+
+type bound struct {
+	cmd     runnable.Runnable
+	options []string
+}
+
+func (b *bound) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
+	return b.cmd.Run(ctx, append(append([]string{}, b.options...), options...), input, output)
+}
+
+// Bind returns a runnable which runs the chain with the given options, the options of the call are ignored.
+// It allows to use a chain as a part of another chain or of a parallel runnable.
+func (c *Chain) Bind(options [][]string) runnable.Runnable {
+	return &boundChain{c, options}
+}
+
+type boundChain struct {
+	chain   *Chain
+	options [][]string
+}
+
+func (b *boundChain) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
+	return b.chain.Run(ctx, b.options, input, output)
+}
@@ -0,0 +1,228 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/trusch/btrfaas/frunner/runnable"
+)
+
+// MergeMode defines how the outputs of parallel runnables are merged
+type MergeMode int
+
+const (
+	// Concat writes the outputs one after another in the order of the runnables.
+	// The output of the first runnable is streamed, the others are buffered until they are done,
+	// outputs larger than SpillSize are buffered in a temporary file.
+	Concat MergeMode = iota
+	// Interleave writes complete lines of the outputs as soon as they are available,
+	// an incomplete last line is terminated by a newline so that it doesn't run into a line of another runnable
+	Interleave
+)
+
+// SpillSize is the size up to which the buffered outputs of Concat are kept in memory
+var SpillSize = 1 << 20
+
+// Parallel runs runnables side by side, every runnable gets a copy of the input.
+// The input is fed to the runnables in lockstep without buffering, so a runnable which reads its input slowly
+// slows down the others. Runnables which stop reading their input early don't slow down the others.
+// The first error cancels all runnables and is returned as it is once they stopped.
+type Parallel struct {
+	mode      MergeMode
+	runnables []runnable.Runnable
+}
+
+// NewParallel creates a runnable which runs cmd in parallel and merges their outputs
+func NewParallel(mode MergeMode, cmd ...runnable.Runnable) *Parallel {
+	return &Parallel{mode, cmd}
+}
+
+// Run implements the runnable.Runnable interface, the options are passed to every runnable
+func (p *Parallel) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, len(p.runnables)+1)
+
+	readers := make([]*io.PipeReader, len(p.runnables))
+	writers := make([]*io.PipeWriter, len(p.runnables))
+	for i := range p.runnables {
+		readers[i], writers[i] = io.Pipe()
+	}
+	// fanOut isn't waited for on errors, it reads the input until EOF
+	go func() {
+		done <- fanOut(input, writers)
+	}()
+
+	outputs := p.outputs(output)
+	defer closeOutputs(outputs)
+	wg := &sync.WaitGroup{}
+	for i, cmd := range p.runnables {
+		wg.Add(1)
+		go func(i int, cmd runnable.Runnable) {
+			defer wg.Done()
+			err := cmd.Run(ctx, options, readers[i], outputs[i])
+			if err == nil {
+				err = flush(outputs[i])
+			}
+			// stop feeding a runnable once it is done, it may not have read all of its input
+			readers[i].Close()
+			done <- err
+		}(i, cmd)
+	}
+
+	for i := 0; i < len(p.runnables)+1; i++ {
+		if err := <-done; err != nil {
+			cancel()
+			for _, r := range readers {
+				r.CloseWithError(err)
+			}
+			// the runnables must not write to the output after Run returned
+			wg.Wait()
+			return err
+		}
+	}
+
+	if p.mode == Concat {
+		for _, out := range outputs[1:] {
+			if _, err := out.(*spillBuffer).WriteTo(output); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// outputs returns the writers the runnables write to
+func (p *Parallel) outputs(output io.Writer) []io.Writer {
+	res := make([]io.Writer, len(p.runnables))
+	mutex := &sync.Mutex{}
+	for i := range res {
+		switch {
+		case p.mode == Interleave:
+			res[i] = &lineWriter{output: output, mutex: mutex}
+		case i == 0:
+			res[i] = output
+		default:
+			res[i] = &spillBuffer{}
+		}
+	}
+	return res
+}
+
+func closeOutputs(outputs []io.Writer) {
+	for _, out := range outputs {
+		if b, ok := out.(*spillBuffer); ok {
+			b.Close()
+		}
+	}
+}
+
+// fanOut copies the input to all writers, writers whose reader got closed are dropped.
+// It keeps reading the input until EOF even if all writers are dropped, so that the previous runnable can finish.
+func fanOut(input io.Reader, writers []*io.PipeWriter) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := input.Read(buf)
+		for i, w := range writers {
+			if w == nil || n == 0 {
+				continue
+			}
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				writers[i] = nil
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			for _, w := range writers {
+				if w != nil {
+					// IMPORTANT: pipes need to be closed to send EOF
+					w.CloseWithError(err)
+				}
+			}
+			return err
+		}
+	}
+}
+
+// lineWriter writes complete lines to a shared output
+type lineWriter struct {
+	output io.Writer
+	mutex  *sync.Mutex
+	buf    []byte
+}
+
+func (w *lineWriter) Write(data []byte) (int, error) {
+	w.buf = append(w.buf, data...)
+	if idx := bytes.LastIndexByte(w.buf, '\n'); idx >= 0 {
+		if err := w.write(w.buf[:idx+1]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[idx+1:]
+	}
+	return len(data), nil
+}
+
+func (w *lineWriter) write(data []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, err := w.output.Write(data)
+	return err
+}
+
+// flush writes an incomplete last line of a lineWriter
+func flush(w io.Writer) error {
+	if lw, ok := w.(*lineWriter); ok && len(lw.buf) > 0 {
+		return lw.write(append(lw.buf, '\n'))
+	}
+	return nil
+}
+
+// spillBuffer keeps up to SpillSize bytes in memory and moves larger outputs to a temporary file
+type spillBuffer struct {
+	mem  bytes.Buffer
+	file *os.File
+}
+
+func (b *spillBuffer) Write(data []byte) (int, error) {
+	if b.file == nil && b.mem.Len()+len(data) > SpillSize {
+		file, err := ioutil.TempFile("", "btrfaas-parallel-")
+		if err != nil {
+			return 0, err
+		}
+		// the open file stays readable, removing it right away makes sure it doesn't outlive the process
+		os.Remove(file.Name())
+		b.file = file
+		if _, err = b.mem.WriteTo(file); err != nil {
+			return 0, err
+		}
+	}
+	if b.file != nil {
+		return b.file.Write(data)
+	}
+	return b.mem.Write(data)
+}
+
+// WriteTo writes the buffered output to w
+func (b *spillBuffer) WriteTo(w io.Writer) (int64, error) {
+	if b.file == nil {
+		return b.mem.WriteTo(w)
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, b.file)
+}
+
+// Close releases the temporary file
+func (b *spillBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	return b.file.Close()
+}
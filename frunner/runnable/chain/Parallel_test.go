@@ -0,0 +1,112 @@
+package chain_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/trusch/btrfaas/frunner/runnable"
+	. "github.com/trusch/btrfaas/frunner/runnable/chain"
+	"github.com/trusch/btrfaas/frunner/runnable/exec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parallel", func() {
+	toUpper := exec.NewRunnable("tr", "[:lower:]", "[:upper:]")
+	cat := exec.NewRunnable("cat", "-")
+
+	It("should concatenate the outputs in order", func() {
+		slow := exec.NewRunnable("sh", "-c", "sleep 0.2; cat")
+		parallel := NewParallel(Concat, slow, toUpper, cat)
+		output := &bytes.Buffer{}
+		Expect(parallel.Run(context.Background(), nil, bytes.NewBufferString("foo\n"), output)).To(Succeed())
+		Expect(output.String()).To(Equal("foo\nFOO\nfoo\n"))
+	})
+
+	It("should interleave complete lines", func() {
+		parallel := NewParallel(Interleave, toUpper, cat)
+		output := &bytes.Buffer{}
+		input := bytes.NewBufferString(strings.Repeat("foo\n", 1000) + "bar")
+		Expect(parallel.Run(context.Background(), nil, input, output)).To(Succeed())
+		lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+		sort.Strings(lines)
+		expected := strings.Split(strings.Repeat("FOO\n", 1000)+strings.Repeat("foo\n", 1000)+"BAR\nbar", "\n")
+		sort.Strings(expected)
+		Expect(lines).To(Equal(expected))
+	})
+
+	It("should not block if a branch stops reading early", func() {
+		head := exec.NewRunnable("head", "-c", "3")
+		parallel := NewParallel(Concat, head, exec.NewRunnable("wc", "-c"))
+		output := &bytes.Buffer{}
+		input := bytes.NewBufferString(strings.Repeat("x", 1<<20))
+		Expect(parallel.Run(context.Background(), nil, input, output)).To(Succeed())
+		Expect(strings.TrimSpace(output.String())).To(Equal("xxx1048576"))
+	})
+
+	It("should return the first error", func() {
+		fail := exec.NewRunnable("sh", "-c", "echo broken >&2; exit 3")
+		parallel := NewParallel(Concat, cat, fail)
+		err := parallel.Run(context.Background(), nil, bytes.NewBufferString("foo"), &bytes.Buffer{})
+		runErr, ok := err.(*runnable.Error)
+		Expect(ok).To(BeTrue())
+		Expect(runErr.ExitCode).To(Equal(3))
+		Expect(string(runErr.Stderr)).To(Equal("broken\n"))
+	})
+
+	It("should wait for all runnables after an error", func() {
+		stopped := int32(0)
+		fail := exec.NewRunnable("sh", "-c", "exit 3")
+		parallel := NewParallel(Interleave, &slowStop{&stopped}, fail)
+		Expect(parallel.Run(context.Background(), nil, bytes.NewBufferString("foo"), &bytes.Buffer{})).NotTo(Succeed())
+		Expect(atomic.LoadInt32(&stopped)).To(Equal(int32(1)))
+	})
+
+	It("should spill large outputs to a temporary file", func() {
+		defer func(size int) { SpillSize = size }(SpillSize)
+		SpillSize = 16
+		input := strings.Repeat("foo\n", 1000)
+		parallel := NewParallel(Concat, cat, toUpper, cat)
+		output := &bytes.Buffer{}
+		Expect(parallel.Run(context.Background(), nil, bytes.NewBufferString(input), output)).To(Succeed())
+		Expect(output.String()).To(Equal(input + strings.ToUpper(input) + input))
+	})
+
+	It("should be usable as a stage of a chain", func() {
+		parallel := NewParallel(Concat, cat, toUpper)
+		output := &bytes.Buffer{}
+		chain := New(Bind(cat, nil), parallel, exec.NewRunnable("tr", "o", "0"))
+		Expect(chain.Run(context.Background(), nil, bytes.NewBufferString("foo"), output)).To(Succeed())
+		Expect(output.String()).To(Equal("f00FOO"))
+	})
+
+	It("should run bound chains as branches", func() {
+		sed := exec.NewRunnable("sed")
+		branch := New(sed, toUpper).Bind([][]string{{"s/f/b/"}})
+		parallel := NewParallel(Concat, cat, branch)
+		output := &bytes.Buffer{}
+		Expect(parallel.Run(context.Background(), nil, bytes.NewBufferString("foo\n"), output)).To(Succeed())
+		Expect(output.String()).To(Equal("foo\nBOO\n"))
+	})
+})
+
+// slowStop takes a while to stop after it got canceled
+type slowStop struct {
+	stopped *int32
+}
+
+func (s *slowStop) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
+	io.Copy(ioutil.Discard, input)
+	<-ctx.Done()
+	time.Sleep(100 * time.Millisecond)
+	atomic.StoreInt32(s.stopped, 1)
+	return errors.New("canceled")
+}
@@ -0,0 +1,30 @@
+package chain
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/trusch/btrfaas/frunner/runnable"
+)
+
+// NewTee creates a runnable which passes its input through unchanged and runs cmd with a copy of the input.
+// The output of cmd is discarded, an error of cmd fails the tee.
+func NewTee(cmd runnable.Runnable) runnable.Runnable {
+	return NewParallel(Concat, passThrough{}, discard{cmd})
+}
+
+type passThrough struct{}
+
+func (passThrough) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
+	_, err := io.Copy(output, input)
+	return err
+}
+
+type discard struct {
+	cmd runnable.Runnable
+}
+
+func (d discard) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
+	return d.cmd.Run(ctx, options, input, ioutil.Discard)
+}
@@ -0,0 +1,36 @@
+package chain_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/trusch/btrfaas/frunner/runnable/chain"
+	"github.com/trusch/btrfaas/frunner/runnable/exec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tee", func() {
+	It("should pass the input through and feed the branch", func() {
+		dir, err := ioutil.TempDir("", "tee")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "audit")
+		audit := exec.NewRunnable("sh", "-c", "tr a-z A-Z > "+file+"; echo discarded")
+		output := &bytes.Buffer{}
+		Expect(NewTee(audit).Run(context.Background(), nil, bytes.NewBufferString("foo"), output)).To(Succeed())
+		Expect(output.String()).To(Equal("foo"))
+		bs, err := ioutil.ReadFile(file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(bs)).To(Equal("FOO"))
+	})
+
+	It("should fail if the branch fails", func() {
+		fail := exec.NewRunnable("sh", "-c", "cat >/dev/null; exit 1")
+		Expect(NewTee(fail).Run(context.Background(), nil, bytes.NewBufferString("foo"), &bytes.Buffer{})).NotTo(Succeed())
+	})
+})
@@ -5,6 +5,7 @@ import (
 	"context"
 
 	"github.com/trusch/btrfaas/frunner/env"
+	"github.com/trusch/btrfaas/frunner/runnable"
 	. "github.com/trusch/btrfaas/frunner/runnable/exec"
 
 	. "github.com/onsi/ginkgo"
@@ -48,4 +49,40 @@ var _ = Describe("Runnable", func() {
 		Expect(output.String()).To(Equal("bar"))
 	})
 
+	It("should report exit code and stderr of a failed call", func() {
+		cmd := NewRunnable("sh", "-c", "echo -n oops >&2; exit 3")
+		err := cmd.Run(context.Background(), nil, nil, &bytes.Buffer{})
+		Expect(err).To(HaveOccurred())
+		runErr, ok := err.(*runnable.Error)
+		Expect(ok).To(BeTrue())
+		Expect(runErr.ExitCode).To(Equal(3))
+		Expect(string(runErr.Stderr)).To(Equal("oops"))
+	})
+
+	It("should not mix stderr into the output by default", func() {
+		cmd := NewRunnable("sh", "-c", "echo -n foo; echo -n bar >&2")
+		output := &bytes.Buffer{}
+		Expect(cmd.Run(context.Background(), nil, nil, output)).To(Succeed())
+		Expect(output.String()).To(Equal("foo"))
+	})
+
+	It("should not mix stderr into the output of repeated calls", func() {
+		cmd := NewRunnable("sh", "-c", "cat; echo -n oops >&2")
+		for i := 0; i < 10; i++ {
+			input := bytes.NewBufferString("foobar")
+			output := &bytes.Buffer{}
+			Expect(cmd.Run(context.Background(), nil, input, output)).To(Succeed())
+			Expect(output.String()).To(Equal("foobar"))
+		}
+	})
+
+	It("should be possible to mix stderr into the output", func() {
+		cmd := NewRunnable("sh", "-c", "echo -n foo; echo -n bar >&2")
+		cmd.SetStderrMode(StderrOutput)
+		output := &bytes.Buffer{}
+		Expect(cmd.Run(context.Background(), nil, nil, output)).To(Succeed())
+		Expect(output.String()).To(ContainSubstring("foo"))
+		Expect(output.String()).To(ContainSubstring("bar"))
+	})
+
 })
@@ -0,0 +1,144 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/trusch/btrfaas/frunner/env"
+	"github.com/trusch/btrfaas/trace"
+)
+
+// Pool implements the Runnable interface using a pool of long-lived worker processes.
+// The workers speak the worker protocol on stdin/stdout (see protocol.go), so no process is forked per call.
+// Workers which crash, violate the protocol or get cancelled are killed and replaced.
+type Pool struct {
+	bin          string
+	args         []string
+	size         int
+	bufferOutput bool
+	stderrMode   StderrMode
+	workers      chan *worker
+}
+
+// NewPool creates a new Pool instance with the given amount of workers
+func NewPool(size int, bin string, args ...string) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	p := &Pool{
+		bin:        bin,
+		args:       args,
+		size:       size,
+		stderrMode: DefaultStderrMode,
+		workers:    make(chan *worker, size),
+	}
+	for i := 0; i < size; i++ {
+		p.workers <- nil
+	}
+	return p
+}
+
+// Start pre-forks all workers. Workers which are not started get started on their first call.
+func (p *Pool) Start() error {
+	var err error
+	for i := 0; i < p.size; i++ {
+		w := <-p.workers
+		if w == nil || !w.alive() {
+			var startErr error
+			w, startErr = p.spawn()
+			if startErr != nil && err == nil {
+				err = startErr
+			}
+		}
+		p.workers <- w
+	}
+	return err
+}
+
+// Close kills all workers. It waits until the currently running calls are finished.
+func (p *Pool) Close() {
+	for i := 0; i < p.size; i++ {
+		if w := <-p.workers; w != nil {
+			w.kill()
+		}
+	}
+	for i := 0; i < p.size; i++ {
+		p.workers <- nil
+	}
+}
+
+// Run implements the Runnable interface
+func (p *Pool) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) (err error) {
+	ctx, span := trace.StartSpan(ctx, "frunner.worker", trace.KindInternal)
+	span.SetAttribute("process.executable", p.bin)
+	defer func() {
+		span.End(err)
+	}()
+	var w *worker
+	select {
+	case w = <-p.workers:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() {
+		p.workers <- w
+	}()
+	if w == nil || !w.alive() {
+		if w != nil {
+			log.Printf("worker exited with code %v, restarting it", w.exitCode())
+			w.kill()
+		}
+		if w, err = p.spawn(); err != nil {
+			return err
+		}
+	}
+
+	header := &WorkerHeader{Options: options}
+	if env, err := env.FromContext(ctx); err == nil {
+		header.Env = env
+	}
+	out := output
+	if p.bufferOutput {
+		out = &bytes.Buffer{}
+	}
+	broken, err := w.call(ctx, header, input, out)
+	if broken {
+		log.Print("worker got killed because of: ", err)
+		w, _ = p.spawn()
+	}
+	if err != nil {
+		return err
+	}
+	if p.bufferOutput {
+		_, err = io.Copy(output, out.(*bytes.Buffer))
+	}
+	return err
+}
+
+// EnableOutputBuffering ensures that nothing is written to the output in case of an error
+func (p *Pool) EnableOutputBuffering() {
+	p.bufferOutput = true
+}
+
+// SetStderrMode sets what happens with the stderr output of the workers.
+// Since workers outlive calls, StderrOutput behaves like StderrLog.
+func (p *Pool) SetStderrMode(mode StderrMode) {
+	p.stderrMode = mode
+}
+
+// spawn starts a new worker, failures are logged and result in a nil worker
+func (p *Pool) spawn() (*worker, error) {
+	var stderr io.Writer
+	if p.stderrMode != StderrStatus {
+		stderr = newLogWriter(log.WithField("bin", p.bin))
+	}
+	w, err := startWorker(p.bin, p.args, stderr)
+	if err != nil {
+		log.Print("failed to start worker: ", err)
+		return nil, err
+	}
+	return w, nil
+}
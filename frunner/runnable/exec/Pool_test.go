@@ -0,0 +1,167 @@
+package exec_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/trusch/btrfaas/frunner/env"
+	"github.com/trusch/btrfaas/frunner/runnable"
+	. "github.com/trusch/btrfaas/frunner/runnable/exec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// the test binary doubles as worker process if this is set
+const workerEnvKey = "BTRFAAS_TEST_WORKER"
+
+func init() {
+	if os.Getenv(workerEnvKey) == "1" {
+		runTestWorker()
+		os.Exit(0)
+	}
+}
+
+// runTestWorker implements the worker protocol, the first option selects the behaviour
+func runTestWorker() {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		frameType, payload, err := ReadFrame(in)
+		if err != nil || frameType != FrameHeader {
+			return
+		}
+		header := &WorkerHeader{}
+		if err = json.Unmarshal(payload, header); err != nil {
+			return
+		}
+		mode := ""
+		if len(header.Options) > 0 {
+			mode = header.Options[0]
+		}
+		input, _ := ioutil.ReadAll(NewFrameReader(in))
+		switch mode {
+		case "crash":
+			os.Exit(2)
+		case "sleep":
+			time.Sleep(5 * time.Second)
+		case "fail":
+			WriteFrame(os.Stdout, FrameError, []byte("failed on purpose"))
+			continue
+		case "pid":
+			fmt.Fprint(NewFrameWriter(os.Stdout), os.Getpid())
+		case "env":
+			fmt.Fprint(NewFrameWriter(os.Stdout), header.Env["FOO"])
+		default:
+			NewFrameWriter(os.Stdout).Write(input)
+		}
+		WriteFrame(os.Stdout, FrameEnd, nil)
+	}
+}
+
+var _ = Describe("Pool", func() {
+
+	var pool *Pool
+
+	call := func(ctx context.Context, input io.Reader, options ...string) (string, error) {
+		output := &bytes.Buffer{}
+		err := pool.Run(ctx, options, input, output)
+		return output.String(), err
+	}
+
+	BeforeEach(func() {
+		os.Setenv(workerEnvKey, "1")
+		pool = NewPool(1, os.Args[0])
+		Expect(pool.Start()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		pool.Close()
+		os.Unsetenv(workerEnvKey)
+	})
+
+	It("should be possible to create and use a Pool multiple times", func() {
+		for i := 0; i < 10; i++ {
+			output, err := call(context.Background(), bytes.NewBufferString("foobar"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal("foobar"))
+		}
+	})
+
+	It("should not fork a process per call", func() {
+		pids := make(map[string]bool)
+		for i := 0; i < 10; i++ {
+			output, err := call(context.Background(), nil, "pid")
+			Expect(err).NotTo(HaveOccurred())
+			pids[output] = true
+		}
+		Expect(pids).To(HaveLen(1))
+	})
+
+	It("should pass large inputs in chunks", func() {
+		input := bytes.Repeat([]byte("0123456789"), 100000)
+		output, err := call(context.Background(), bytes.NewReader(input))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal(string(input)))
+	})
+
+	It("should pass the environment from the context", func() {
+		ctx := env.NewContext(context.Background(), env.Env{"FOO": "bar"})
+		output, err := call(ctx, nil, "env")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal("bar"))
+	})
+
+	It("should report errors of the worker and keep using it", func() {
+		pid, err := call(context.Background(), nil, "pid")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = call(context.Background(), nil, "fail")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("failed on purpose"))
+		output, err := call(context.Background(), nil, "pid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal(pid))
+	})
+
+	It("should replace crashed workers", func() {
+		pid, err := call(context.Background(), nil, "pid")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = call(context.Background(), nil, "crash")
+		Expect(err).To(BeAssignableToTypeOf(&runnable.Error{}))
+		Expect(err.(*runnable.Error).ExitCode).To(Equal(2))
+		output, err := call(context.Background(), nil, "pid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).NotTo(Equal(pid))
+	})
+
+	It("should replace workers which time out", func() {
+		pid, err := call(context.Background(), nil, "pid")
+		Expect(err).NotTo(HaveOccurred())
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err = call(ctx, nil, "sleep")
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		output, err := call(context.Background(), nil, "pid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).NotTo(Equal(pid))
+	})
+
+	It("should close the input of calls which time out", func() {
+		inputReader, inputWriter := io.Pipe()
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := call(ctx, inputReader)
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(time.Since(start)).To(BeNumerically("<", 500*time.Millisecond))
+		_, err = inputWriter.Write([]byte("foobar"))
+		Expect(err).To(Equal(io.ErrClosedPipe))
+	})
+
+})
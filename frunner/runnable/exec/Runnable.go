@@ -5,10 +5,31 @@ import (
 	"context"
 	"io"
 	"os/exec"
+	"syscall"
 
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/trusch/btrfaas/frunner/env"
+	"github.com/trusch/btrfaas/frunner/metrics"
+	"github.com/trusch/btrfaas/frunner/runnable"
+	"github.com/trusch/btrfaas/trace"
+)
+
+// stderrTailSize is the amount of stderr output which is reported in case of an error
+const stderrTailSize = 4096
+
+// StderrMode specifies what happens with the stderr output of a process
+type StderrMode string
+
+const (
+	// StderrStatus only keeps the tail of stderr to report it in case of an error
+	StderrStatus StderrMode = "status"
+	// StderrLog additionally writes stderr line by line to the log
+	StderrLog StderrMode = "log"
+	// StderrOutput additionally mixes stderr into the output of the function
+	StderrOutput StderrMode = "output"
+	// DefaultStderrMode is used unless SetStderrMode is called, it is also the default of the --stderr flag of frunner
+	DefaultStderrMode = StderrLog
 )
 
 // Runnable implements the Runnable interface using exec.Cmd
@@ -16,23 +37,42 @@ type Runnable struct {
 	bin          string
 	args         []string
 	bufferOutput bool
+	stderrMode   StderrMode
 }
 
 // NewRunnable creates a new Runnable instance
 func NewRunnable(bin string, args ...string) *Runnable {
 	return &Runnable{
-		bin:  bin,
-		args: args,
+		bin:        bin,
+		args:       args,
+		stderrMode: DefaultStderrMode,
 	}
 }
 
 // Run implements the Runnable interface
-func (r *Runnable) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
+func (r *Runnable) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) (err error) {
+	ctx, span := trace.StartSpan(ctx, "frunner.process", trace.KindInternal)
+	span.SetAttribute("process.executable", r.bin)
+	defer func() {
+		span.End(err)
+	}()
 	args := append(r.args, options...)
 	cmd := exec.Command(r.bin, args...)
 	cmd.Stdin = input
+	stderr := newTailBuffer(stderrTailSize)
+	cmd.Stderr = stderr
+	switch r.stderrMode {
+	case StderrLog:
+		logger := newLogWriter(log.WithField("bin", r.bin))
+		defer logger.Flush()
+		cmd.Stderr = io.MultiWriter(logger, stderr)
+	case StderrOutput:
+		if output != nil {
+			output = &syncWriter{w: output}
+			cmd.Stderr = io.MultiWriter(output, stderr)
+		}
+	}
 	cmd.Stdout = output
-	cmd.Stderr = output
 	if r.bufferOutput {
 		buf := &bytes.Buffer{}
 		cmd.Stdout = buf
@@ -47,7 +87,16 @@ func (r *Runnable) Run(ctx context.Context, options []string, input io.Reader, o
 	select {
 	case err := <-done:
 		{
-			if err == nil && r.bufferOutput {
+			metrics.ObserveExitCode(exitCode(err))
+			span.SetAttribute("process.exit_code", exitCode(err))
+			if err != nil {
+				return &runnable.Error{
+					Err:      err,
+					ExitCode: exitCode(err),
+					Stderr:   stderr.Bytes(),
+				}
+			}
+			if r.bufferOutput {
 				_, err = io.Copy(output, cmd.Stdout.(*bytes.Buffer))
 			}
 			return err
@@ -56,6 +105,7 @@ func (r *Runnable) Run(ctx context.Context, options []string, input io.Reader, o
 		{
 			if cmd.Process != nil {
 				cmd.Process.Kill()
+				metrics.ObserveKill(ctx.Err())
 				log.Print("process got killed because of: ", ctx.Err())
 			}
 			return ctx.Err()
@@ -67,3 +117,18 @@ func (r *Runnable) Run(ctx context.Context, options []string, input io.Reader, o
 func (r *Runnable) EnableOutputBuffering() {
 	r.bufferOutput = true
 }
+
+// SetStderrMode sets what happens with the stderr output of the process
+func (r *Runnable) SetStderrMode(mode StderrMode) {
+	r.stderrMode = mode
+}
+
+// exitCode extracts the exit code of a process from the error returned by exec.Cmd.Run()
+func exitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return 0
+}
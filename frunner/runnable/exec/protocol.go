@@ -0,0 +1,150 @@
+package exec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The worker protocol is spoken over stdin/stdout of long-lived worker processes.
+// Every message is a frame consisting of a one byte frame type, a 4 byte big-endian payload length and the payload.
+//
+// A call looks like this:
+//
+//	frunner -> worker: one FrameHeader, any number of FrameData, one FrameEnd
+//	worker -> frunner: any number of FrameData, then either FrameEnd (success) or FrameError (failure)
+//
+// The worker may start writing output before it read the whole input,
+// but it must read the input up to and including the FrameEnd before it sends its final frame.
+const (
+	// FrameHeader carries a JSON encoded WorkerHeader
+	FrameHeader byte = 'H'
+	// FrameData carries a chunk of input or output data
+	FrameData byte = 'D'
+	// FrameEnd marks the end of the input or the successful end of the output, it has no payload
+	FrameEnd byte = 'E'
+	// FrameError marks the failed end of the output, the payload is a human readable error message
+	FrameError byte = 'X'
+)
+
+// MaxFramePayload is the maximum payload size of a single frame
+const MaxFramePayload = 16 << 20
+
+// dataFrameSize is the payload size frunner uses when chunking input data
+const dataFrameSize = 32 << 10
+
+// WorkerHeader is the payload of the FrameHeader which starts every call
+type WorkerHeader struct {
+	Options []string          `json:"options"`
+	Env     map[string]string `json:"env"`
+}
+
+// WriteFrame writes a single frame
+func WriteFrame(w io.Writer, frameType byte, payload []byte) error {
+	if len(payload) > MaxFramePayload {
+		return errors.New("frame payload too big")
+	}
+	head := make([]byte, 5)
+	head[0] = frameType
+	binary.BigEndian.PutUint32(head[1:], uint32(len(payload)))
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// ReadFrame reads a single frame
+func ReadFrame(r io.Reader) (frameType byte, payload []byte, err error) {
+	head := make([]byte, 5)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(head[1:])
+	if size > MaxFramePayload {
+		return 0, nil, fmt.Errorf("frame payload too big: %v bytes", size)
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return head[0], payload, nil
+}
+
+// FrameWriter is an io.Writer which writes everything as data frames
+type FrameWriter struct {
+	w io.Writer
+}
+
+// NewFrameWriter returns a new FrameWriter
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w}
+}
+
+func (fw *FrameWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > dataFrameSize {
+			chunk = chunk[:dataFrameSize]
+		}
+		if err := WriteFrame(fw.w, FrameData, chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// FrameReader is an io.Reader which reads the data frames of a message until its end frame.
+// An error frame is returned as *WorkerError.
+type FrameReader struct {
+	r       io.Reader
+	pending []byte
+	err     error
+}
+
+// NewFrameReader returns a new FrameReader
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: r}
+}
+
+func (fr *FrameReader) Read(p []byte) (int, error) {
+	for len(fr.pending) == 0 {
+		if fr.err != nil {
+			return 0, fr.err
+		}
+		frameType, payload, err := ReadFrame(fr.r)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			fr.err = err
+			continue
+		}
+		switch frameType {
+		case FrameData:
+			fr.pending = payload
+		case FrameEnd:
+			fr.err = io.EOF
+		case FrameError:
+			fr.err = &WorkerError{string(payload)}
+		default:
+			fr.err = fmt.Errorf("unexpected frame type %q", frameType)
+		}
+	}
+	n := copy(p, fr.pending)
+	fr.pending = fr.pending[n:]
+	return n, nil
+}
+
+// WorkerError is the error a worker reported via a FrameError
+type WorkerError struct {
+	Message string
+}
+
+func (e *WorkerError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,220 @@
+package exec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/trusch/btrfaas/frunner/metrics"
+	"github.com/trusch/btrfaas/frunner/runnable"
+)
+
+// inputGracePeriod is how long a worker may take to consume the rest of the input after it sent its final frame
+const inputGracePeriod = 1 * time.Second
+
+// worker is a long-lived process which speaks the worker protocol
+type worker struct {
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *os.File
+	reader *bufio.Reader
+	stderr *tailBuffer
+	exited chan struct{}
+}
+
+// startWorker starts a new worker process
+func startWorker(bin string, args []string, stderr io.Writer) (*worker, error) {
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		stdinReader.Close()
+		stdinWriter.Close()
+		return nil, err
+	}
+	w := &worker{
+		cmd:    exec.Command(bin, args...),
+		stdin:  stdinWriter,
+		stdout: stdoutReader,
+		reader: bufio.NewReader(stdoutReader),
+		stderr: newTailBuffer(stderrTailSize),
+		exited: make(chan struct{}),
+	}
+	w.cmd.Stdin = stdinReader
+	w.cmd.Stdout = stdoutWriter
+	w.cmd.Stderr = w.stderr
+	if stderr != nil {
+		w.cmd.Stderr = io.MultiWriter(stderr, w.stderr)
+	}
+	err = w.cmd.Start()
+	// the child has its own copies of these now
+	stdinReader.Close()
+	stdoutWriter.Close()
+	if err != nil {
+		stdinWriter.Close()
+		stdoutReader.Close()
+		return nil, err
+	}
+	go func() {
+		w.cmd.Wait()
+		close(w.exited)
+	}()
+	return w, nil
+}
+
+// alive returns false if the worker process exited
+func (w *worker) alive() bool {
+	select {
+	case <-w.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// kill kills the worker process and waits until it exited
+func (w *worker) kill() {
+	w.cmd.Process.Kill()
+	w.stdin.Close()
+	w.stdout.Close()
+	<-w.exited
+}
+
+// exitCode returns the exit code of the worker process, or -1 if it got killed
+func (w *worker) exitCode() int {
+	if w.cmd.ProcessState == nil {
+		return 0
+	}
+	if status, ok := w.cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+		if status.Signaled() {
+			return -1
+		}
+		return status.ExitStatus()
+	}
+	return 0
+}
+
+// call executes a single call on the worker.
+// If broken is true the worker is out of sync with the protocol and is already killed.
+func (w *worker) call(ctx context.Context, header *WorkerHeader, input io.Reader, output io.Writer) (broken bool, err error) {
+	w.stderr.Reset()
+	if output == nil {
+		output = ioutil.Discard
+	}
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return false, err
+	}
+	if err = WriteFrame(w.stdin, FrameHeader, headerBytes); err != nil {
+		return true, w.fail(err)
+	}
+
+	writeDone := make(chan error, 1)
+	go func() {
+		var err error
+		if input != nil {
+			_, err = io.Copy(NewFrameWriter(w.stdin), input)
+		}
+		if err == nil {
+			err = WriteFrame(w.stdin, FrameEnd, nil)
+		}
+		writeDone <- err
+	}()
+	readDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(output, NewFrameReader(w.reader))
+		readDone <- err
+	}()
+
+	for {
+		select {
+		case err = <-writeDone:
+			if err != nil {
+				w.kill()
+				<-readDone
+				return true, err
+			}
+			writeDone = nil
+		case err = <-readDone:
+			workerErr, isWorkerErr := err.(*WorkerError)
+			if err != nil && !isWorkerErr {
+				return true, w.fail(err)
+			}
+			if writeDone != nil {
+				select {
+				case werr := <-writeDone:
+					if werr != nil {
+						w.kill()
+						return true, werr
+					}
+				case <-time.After(inputGracePeriod):
+					err = w.fail(errors.New("worker did not consume the whole input"))
+					stopInput(input, writeDone)
+					return true, err
+				}
+			}
+			if isWorkerErr {
+				return false, &runnable.Error{
+					Err:    workerErr,
+					Stderr: w.stderr.Bytes(),
+				}
+			}
+			return false, nil
+		case <-ctx.Done():
+			metrics.ObserveKill(ctx.Err())
+			w.kill()
+			<-readDone
+			stopInput(input, writeDone)
+			return true, ctx.Err()
+		}
+	}
+}
+
+// stopInput unblocks the goroutine which copies the input of an aborted call to the worker and waits for it.
+// The goroutine may block on reading the input, so it gets closed if it is an io.Closer.
+func stopInput(input io.Reader, writeDone <-chan error) {
+	if writeDone == nil {
+		return
+	}
+	if closer, ok := input.(io.Closer); ok {
+		// closing may block until a pending read returns, like it does for HTTP request bodies
+		go closer.Close()
+	}
+	select {
+	case <-writeDone:
+	case <-time.After(inputGracePeriod):
+		log.Warn("the input of an aborted call can't be unblocked, it is read until the caller closes it")
+	}
+}
+
+// fail kills the worker and returns an error describing what happened to it
+func (w *worker) fail(err error) error {
+	crashed := false
+	select {
+	case <-w.exited:
+		crashed = true
+	case <-time.After(100 * time.Millisecond):
+	}
+	w.kill()
+	if crashed {
+		metrics.ObserveExitCode(w.exitCode())
+		err = fmt.Errorf("worker crashed: %v", err)
+	}
+	return &runnable.Error{
+		Err:      err,
+		ExitCode: w.exitCode(),
+		Stderr:   w.stderr.Bytes(),
+	}
+}
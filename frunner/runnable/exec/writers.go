@@ -0,0 +1,94 @@
+package exec
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// tailBuffer is an io.Writer which only keeps the last bytes written to it
+type tailBuffer struct {
+	mutex sync.Mutex
+	size  int
+	data  []byte
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{size: size}
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.data = append(b.data, p...)
+	if len(b.data) > b.size {
+		b.data = b.data[len(b.data)-b.size:]
+	}
+	return len(p), nil
+}
+
+// Bytes returns a copy of the buffered bytes
+func (b *tailBuffer) Bytes() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	res := make([]byte, len(b.data))
+	copy(res, b.data)
+	return res
+}
+
+// Reset discards the buffered bytes
+func (b *tailBuffer) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.data = nil
+}
+
+// syncWriter serializes writes to an io.Writer which is shared by stdout and stderr
+type syncWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.w.Write(p)
+}
+
+// logWriter writes everything line by line to a logger
+type logWriter struct {
+	mutex  sync.Mutex
+	logger *log.Entry
+	buf    bytes.Buffer
+}
+
+func newLogWriter(logger *log.Entry) *logWriter {
+	return &logWriter{logger: logger}
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.buf.Write(p)
+	for {
+		idx := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if idx == -1 {
+			break
+		}
+		line := w.buf.Next(idx + 1)
+		w.logger.Info(string(line[:idx]))
+	}
+	return len(p), nil
+}
+
+// Flush logs the remaining incomplete line
+func (w *logWriter) Flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.buf.Len() > 0 {
+		w.logger.Info(w.buf.String())
+		w.buf.Reset()
+	}
+}
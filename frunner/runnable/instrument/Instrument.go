@@ -0,0 +1,77 @@
+package instrument
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/trusch/btrfaas/frunner/metrics"
+	"github.com/trusch/btrfaas/frunner/runnable"
+	"github.com/trusch/btrfaas/frunner/runnable/limit"
+)
+
+// Instrument is a runnable which reports metrics about the calls to another runnable
+type Instrument struct {
+	base runnable.Runnable
+}
+
+// New creates a new Instrument
+func New(base runnable.Runnable) *Instrument {
+	return &Instrument{base}
+}
+
+// Run implements the runnable.Runnable interface
+func (i *Instrument) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
+	defer metrics.CallStarted()()
+	start := time.Now()
+	in := &countingReader{r: input}
+	out := &countingWriter{w: output}
+	if input != nil {
+		input = in
+	}
+	if output != nil {
+		output = out
+	}
+	err := i.base.Run(ctx, options, input, output)
+	metrics.ObserveCall(result(ctx, err), time.Since(start), atomic.LoadInt64(&in.n), atomic.LoadInt64(&out.n))
+	return err
+}
+
+// result classifies the outcome of a call
+func result(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case limit.IsRejected(err):
+		return "rejected"
+	case ctx.Err() == context.DeadlineExceeded:
+		return "timeout"
+	case ctx.Err() == context.Canceled:
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,112 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/trusch/btrfaas/frunner/metrics"
+	"github.com/trusch/btrfaas/frunner/runnable"
+)
+
+var (
+	// ErrQueueFull is returned if all slots are busy and the wait queue is full
+	ErrQueueFull = errors.New("too many concurrent calls: queue is full")
+	// ErrQueueTimeout is returned if a call waited longer than the queue timeout for a free slot
+	ErrQueueTimeout = errors.New("too many concurrent calls: queue timeout exceeded")
+)
+
+// IsRejected returns true if the error means that the call got rejected because of the concurrency limit
+func IsRejected(err error) bool {
+	return err == ErrQueueFull || err == ErrQueueTimeout
+}
+
+// Limit is a runnable which limits the number of concurrent calls to another runnable.
+// Calls which exceed the limit wait in a bounded queue for a free slot.
+type Limit struct {
+	base         runnable.Runnable
+	slots        chan struct{}
+	queue        chan struct{}
+	queueTimeout time.Duration
+
+	mutex   sync.Mutex
+	active  int
+	waiting int
+}
+
+// New creates a new Limit which allows maxConcurrency concurrent calls and queueSize waiting calls.
+// A queueTimeout of zero lets calls wait until their context is done.
+func New(base runnable.Runnable, maxConcurrency, queueSize int, queueTimeout time.Duration) *Limit {
+	return &Limit{
+		base:         base,
+		slots:        make(chan struct{}, maxConcurrency),
+		queue:        make(chan struct{}, queueSize),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Run implements the runnable.Runnable interface
+func (l *Limit) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
+	if err := l.acquire(ctx); err != nil {
+		return err
+	}
+	defer l.release()
+	return l.base.Run(ctx, options, input, output)
+}
+
+func (l *Limit) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		l.setActive(1)
+		return nil
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return ErrQueueFull
+	}
+	l.setWaiting(1)
+	defer func() {
+		<-l.queue
+		l.setWaiting(-1)
+	}()
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		l.setActive(1)
+		return nil
+	case <-timeout:
+		return ErrQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Limit) release() {
+	<-l.slots
+	l.setActive(-1)
+}
+
+func (l *Limit) setActive(delta int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.active += delta
+	metrics.SetActiveCalls(l.active)
+}
+
+func (l *Limit) setWaiting(delta int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.waiting += delta
+	metrics.SetQueueDepth(l.waiting)
+}
@@ -0,0 +1,98 @@
+package limit_test
+
+import (
+	"context"
+	"io"
+	"time"
+
+	. "github.com/trusch/btrfaas/frunner/runnable/limit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// blocking is a runnable which blocks until it gets released
+type blocking struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blocking) Run(ctx context.Context, options []string, input io.Reader, output io.Writer) error {
+	b.started <- struct{}{}
+	<-b.release
+	return nil
+}
+
+var _ = Describe("Limit", func() {
+
+	var base *blocking
+
+	BeforeEach(func() {
+		base = &blocking{make(chan struct{}, 10), make(chan struct{})}
+	})
+
+	run := func(l *Limit) chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- l.Run(context.Background(), nil, nil, nil)
+		}()
+		return done
+	}
+
+	It("should reject calls if the queue is full", func() {
+		l := New(base, 1, 1, 0)
+		first := run(l)
+		Eventually(base.started).Should(Receive())
+		second := run(l)
+		third := run(l)
+		var rejected error
+		select {
+		case rejected = <-second:
+		case rejected = <-third:
+		case <-time.After(time.Second):
+		}
+		Expect(rejected).To(Equal(ErrQueueFull))
+		close(base.release)
+		Eventually(first).Should(Receive(BeNil()))
+		Eventually(func() bool {
+			return len(second)+len(third) == 1
+		}).Should(BeTrue())
+	})
+
+	It("should reject calls which wait longer than the queue timeout", func() {
+		l := New(base, 1, 1, 50*time.Millisecond)
+		first := run(l)
+		Eventually(base.started).Should(Receive())
+		second := run(l)
+		Eventually(second).Should(Receive(Equal(ErrQueueTimeout)))
+		close(base.release)
+		Eventually(first).Should(Receive(BeNil()))
+	})
+
+	It("should stop waiting if the context is done", func() {
+		l := New(base, 1, 1, 0)
+		first := run(l)
+		Eventually(base.started).Should(Receive())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(l.Run(ctx, nil, nil, nil)).To(Equal(context.Canceled))
+		close(base.release)
+		Eventually(first).Should(Receive(BeNil()))
+	})
+
+	It("should not run more calls than allowed at once", func() {
+		l := New(base, 2, 10, 0)
+		results := make([]chan error, 5)
+		for i := range results {
+			results[i] = run(l)
+		}
+		Eventually(base.started).Should(Receive())
+		Eventually(base.started).Should(Receive())
+		Consistently(base.started).ShouldNot(Receive())
+		close(base.release)
+		for _, res := range results {
+			Eventually(res).Should(Receive(BeNil()))
+		}
+	})
+
+})
@@ -23,26 +23,27 @@ package cmd
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"strings"
-
-	"google.golang.org/grpc/credentials"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/trusch/btrfaas/expression"
+	"github.com/trusch/btrfaas/fgateway/authz"
 	"github.com/trusch/btrfaas/fgateway/grpc"
-	g "google.golang.org/grpc"
+	"github.com/trusch/btrfaas/tlsconfig"
 )
 
-var cli *grpc.Client
+var (
+	cli        *grpc.Client
+	gatewayTLS = tlsconfig.New("client") // the shared client certificate, so that the gateway can authorize fui
+)
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
@@ -51,39 +52,51 @@ var RootCmd = &cobra.Command{
 	Long:  `BtrFaaS HTTP UI`,
 	Run: func(cmd *cobra.Command, args []string) {
 		gateway, _ := cmd.Flags().GetString("gateway")
-		// Create a certificate pool from the certificate authority
-		certPool := x509.NewCertPool()
-		ca, err := ioutil.ReadFile("/run/secrets/btrfaas-ca-cert.pem")
+		if insecure, _ := cmd.Flags().GetBool("insecure"); insecure {
+			gatewayTLS.Insecure = true
+		}
+		if err := gatewayTLS.LoadEnvironment("FUI_TLS"); err != nil {
+			log.Fatal(err)
+		}
+		creds, err := gatewayTLS.DialOption("fgateway")
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		// Append the certificates from the CA
-		if ok := certPool.AppendCertsFromPEM(ca); !ok {
+		cli, err := grpc.NewClient(gateway, creds)
+		if err != nil {
 			log.Fatal(err)
 		}
-
-		creds := credentials.NewTLS(&tls.Config{
-			ServerName: "fgateway",
-			RootCAs:    certPool,
-		})
-
-		cli, err := grpc.NewClient(gateway, g.WithTransportCredentials(creds))
+		tokens, err := setupTokens(cmd)
 		if err != nil {
 			log.Fatal(err)
 		}
+		requireToken, _ := cmd.Flags().GetBool("require-token")
+		if !requireToken {
+			log.Print("WARNING: /api/invoke accepts anonymous calls, they have the rights of the gateway client certificate")
+		}
 
 		http.HandleFunc("/api/invoke", func(w http.ResponseWriter, r *http.Request) {
-			expr := r.Header.Get("X-Btrfaas-Chain")
-			optionsStr := r.Header.Get("X-Btrfaas-Options")
-			chain := strings.Split(expr, "|")
-			options := [][]string{}
-			if err := json.Unmarshal([]byte(optionsStr), &options); err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+			expr, err := expression.Parse(r.Header.Get("X-Btrfaas-Chain"))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("malformed function expression: %v", err), http.StatusBadRequest)
+				return
+			}
+			chain := expr.Chain()
+			if status, err := authenticate(r, tokens, requireToken, chain); err != nil {
+				http.Error(w, err.Error(), status)
 				return
 			}
+			options, err := getOptions(r, expr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for i, stage := range expr.Stages() {
+				stage.Options = options[i]
+			}
 			buf := &bytes.Buffer{}
-			if err := cli.Run(context.Background(), chain, options, r.Body, buf); err != nil {
+			if err := cli.RunExpression(context.Background(), expr, r.Body, buf); err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				w.Write([]byte(err.Error()))
 				return
@@ -99,6 +112,64 @@ var RootCmd = &cobra.Command{
 	},
 }
 
+// setupTokens loads the API keys, an empty --tokens disables them
+func setupTokens(cmd *cobra.Command) (*authz.TokenStore, error) {
+	file, _ := cmd.Flags().GetString("tokens")
+	if file == "" {
+		return nil, nil
+	}
+	tokens, err := authz.NewTokenStore(file)
+	if err != nil {
+		return nil, err
+	}
+	interval, _ := cmd.Flags().GetDuration("tokens-reload-interval")
+	tokens.Watch(interval)
+	return tokens, nil
+}
+
+// authenticate checks the API key of a call and its scopes, it returns the HTTP status code of a rejected call
+func authenticate(r *http.Request, tokens *authz.TokenStore, required bool, chain []string) (int, error) {
+	key := authz.TokenFromRequest(r)
+	if key == "" {
+		if required {
+			return http.StatusUnauthorized, errors.New("authentication required")
+		}
+		return http.StatusOK, nil
+	}
+	var identity *authz.Identity
+	if tokens != nil {
+		identity = tokens.Authenticate(key)
+	}
+	if identity == nil {
+		log.Printf("rejected unknown token from %v", r.RemoteAddr)
+		return http.StatusUnauthorized, errors.New("invalid token")
+	}
+	if err := authz.CheckScopes(identity, chain); err != nil {
+		return http.StatusForbidden, err
+	}
+	return http.StatusOK, nil
+}
+
+// getOptions returns the options of the expression, followed by the ones of the X-Btrfaas-Options header (a JSON list per stage)
+func getOptions(r *http.Request, expr *expression.Expression) ([][]string, error) {
+	options := expr.Options()
+	header := r.Header.Get("X-Btrfaas-Options")
+	if header == "" {
+		return options, nil
+	}
+	extra := [][]string{}
+	if err := json.Unmarshal([]byte(header), &extra); err != nil {
+		return nil, fmt.Errorf("malformed options: %v", err)
+	}
+	if len(extra) > len(options) {
+		return nil, errors.New("more options than functions")
+	}
+	for i, opts := range extra {
+		options[i] = append(options[i], opts...)
+	}
+	return options, nil
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -113,6 +184,11 @@ func init() {
 	RootCmd.Flags().StringP("listen", "l", ":80", "http listen address")
 	RootCmd.Flags().StringP("gateway", "g", "fgateway:2424", "gateway address")
 	RootCmd.Flags().StringP("assets", "a", "assets", "asset directory")
+	gatewayTLS.AddFlags(RootCmd.Flags(), "tls", "gateway client")
+	RootCmd.Flags().Bool("insecure", false, "connect to the gateway without TLS, for local development only")
+	RootCmd.Flags().String("tokens", "/run/secrets/fgateway-tokens.yaml", "API keys for /api/invoke, a missing file means there are none, empty to disable them")
+	RootCmd.Flags().Duration("tokens-reload-interval", 10*time.Second, "how often the API keys are checked for changes, 0 disables reloading")
+	RootCmd.Flags().Bool("require-token", true, "reject calls of /api/invoke without an API key, fui calls the gateway with the shared client certificate so anonymous calls get its rights")
 }
 
 // initConfig reads in config file and ENV variables if set.
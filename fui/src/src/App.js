@@ -16,29 +16,20 @@ class App extends Component {
     this.state = {
       functions: [],
       data: '',
+      apiKey: '',
       result: '',
       execRunning: false,
     };
   }
 
   execute() {
-    const chainHeader = this.state.functions.map(e=>e.name).join('|');
-    const options = [];
-    for(let i=0;i<this.state.functions.length;i++){
-      options[i] = []
-      if (this.state.functions[i].options) {
-        const parts = this.state.functions[i].options.split(' ');
-        options[i] = parts;
-        for(let partIdx=0;partIdx<parts.length; partIdx++) {
-          const kv = parts[partIdx].split('=');
-          options[i][kv[0]] = kv[1];
-        }
-      }
-    }
-    const optionsHeader = JSON.stringify(options);
+    // the options are passed as typed, the server parses them with shell-style quoting
+    const chainHeader = this.state.functions.map(e=>e.options ? e.name + ' ' + e.options : e.name).join(' | ');
     const headers = new Headers();
     headers.set('X-Btrfaas-Chain', chainHeader);
-    headers.set('X-Btrfaas-Options', optionsHeader);
+    if (this.state.apiKey) {
+      headers.set('Authorization', 'Bearer ' + this.state.apiKey);
+    }
     const fetchOpts = {
       method: 'POST',
       headers: headers,
@@ -68,6 +59,11 @@ class App extends Component {
               data: evt.target.value,
             });
           }}/>
+          <TextField value={this.state.apiKey} type="password" floatingLabelText={'API key'} onChange={(evt)=>{
+            this.setState({
+              apiKey: evt.target.value,
+            });
+          }}/>
           <List>
           {this.state.functions.map((fn,idx)=>{
             return (
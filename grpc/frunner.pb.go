@@ -9,6 +9,7 @@ It is generated from these files:
 
 It has these top-level messages:
 	Data
+	Status
 */
 package grpc
 
@@ -48,8 +49,65 @@ func (m *Data) GetData() []byte {
 	return nil
 }
 
+type Status struct {
+	FunctionId    string `protobuf:"bytes,1,opt,name=function_id,json=functionId" json:"function_id,omitempty"`
+	ChainPosition int32  `protobuf:"varint,2,opt,name=chain_position,json=chainPosition" json:"chain_position,omitempty"`
+	ExitCode      int32  `protobuf:"varint,3,opt,name=exit_code,json=exitCode" json:"exit_code,omitempty"`
+	Stderr        []byte `protobuf:"bytes,4,opt,name=stderr,proto3" json:"stderr,omitempty"`
+	Retryable     bool   `protobuf:"varint,5,opt,name=retryable" json:"retryable,omitempty"`
+	Message       string `protobuf:"bytes,6,opt,name=message" json:"message,omitempty"`
+}
+
+func (m *Status) Reset()                    { *m = Status{} }
+func (m *Status) String() string            { return proto.CompactTextString(m) }
+func (*Status) ProtoMessage()               {}
+func (*Status) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *Status) GetFunctionId() string {
+	if m != nil {
+		return m.FunctionId
+	}
+	return ""
+}
+
+func (m *Status) GetChainPosition() int32 {
+	if m != nil {
+		return m.ChainPosition
+	}
+	return 0
+}
+
+func (m *Status) GetExitCode() int32 {
+	if m != nil {
+		return m.ExitCode
+	}
+	return 0
+}
+
+func (m *Status) GetStderr() []byte {
+	if m != nil {
+		return m.Stderr
+	}
+	return nil
+}
+
+func (m *Status) GetRetryable() bool {
+	if m != nil {
+		return m.Retryable
+	}
+	return false
+}
+
+func (m *Status) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*Data)(nil), "grpc.Data")
+	proto.RegisterType((*Status)(nil), "grpc.Status")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -159,13 +217,20 @@ var _FunctionRunner_serviceDesc = grpc1.ServiceDesc{
 func init() { proto.RegisterFile("frunner.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 114 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4d, 0x2b, 0x2a, 0xcd,
-	0xcb, 0x4b, 0x2d, 0xd2, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x49, 0x2f, 0x2a, 0x48, 0x56,
-	0x92, 0xe2, 0x62, 0x71, 0x49, 0x2c, 0x49, 0x14, 0x12, 0xe2, 0x62, 0x49, 0x49, 0x2c, 0x49, 0x94,
-	0x60, 0x54, 0x60, 0xd4, 0xe0, 0x09, 0x02, 0xb3, 0x8d, 0x4c, 0xb9, 0xf8, 0xdc, 0x4a, 0xf3, 0x92,
-	0x4b, 0x32, 0xf3, 0xf3, 0x82, 0xc0, 0x3a, 0x85, 0x94, 0xb9, 0x98, 0x83, 0x4a, 0xf3, 0x84, 0xb8,
-	0xf4, 0x40, 0x7a, 0xf5, 0x40, 0x1a, 0xa5, 0x90, 0xd8, 0x4a, 0x0c, 0x1a, 0x8c, 0x06, 0x8c, 0x49,
-	0x6c, 0x60, 0xf3, 0x8d, 0x01, 0x01, 0x00, 0x00, 0xff, 0xff, 0x58, 0x44, 0xae, 0xb9, 0x70, 0x00,
-	0x00, 0x00,
+	// 237 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x90, 0xc1, 0x4a, 0xc4, 0x30,
+	0x10, 0x86, 0x8d, 0xdb, 0xad, 0xdb, 0xd1, 0xdd, 0xc3, 0x1c, 0x24, 0xac, 0x82, 0xa5, 0x22, 0xf4,
+	0x54, 0x44, 0xf1, 0x09, 0x14, 0xc1, 0x9b, 0xc4, 0x07, 0x28, 0xd9, 0x66, 0x76, 0x0d, 0x68, 0x52,
+	0x92, 0x29, 0xe8, 0xc3, 0xf9, 0x6e, 0xd2, 0xd0, 0xa2, 0xb7, 0xf9, 0x3f, 0xfe, 0xc3, 0xff, 0x0d,
+	0xac, 0xf7, 0x61, 0x70, 0x8e, 0x42, 0xd3, 0x07, 0xcf, 0x1e, 0xb3, 0x43, 0xe8, 0xbb, 0x6a, 0x0b,
+	0xd9, 0x93, 0x66, 0x8d, 0x08, 0x99, 0xd1, 0xac, 0xa5, 0x28, 0x45, 0x7d, 0xa6, 0xd2, 0x5d, 0xfd,
+	0x08, 0xc8, 0xdf, 0x58, 0xf3, 0x10, 0xf1, 0x0a, 0x4e, 0xf7, 0x83, 0xeb, 0xd8, 0x7a, 0xd7, 0x5a,
+	0x93, 0x5a, 0x85, 0x82, 0x19, 0xbd, 0x18, 0xbc, 0x81, 0x4d, 0xf7, 0xae, 0xad, 0x6b, 0x7b, 0x1f,
+	0xed, 0xc8, 0xe4, 0x71, 0x29, 0xea, 0xa5, 0x5a, 0x27, 0xfa, 0x3a, 0x41, 0xbc, 0x80, 0x82, 0xbe,
+	0x2c, 0xb7, 0x9d, 0x37, 0x24, 0x17, 0xa9, 0xb1, 0x1a, 0xc1, 0xa3, 0x37, 0x84, 0xe7, 0x90, 0x47,
+	0x36, 0x14, 0x82, 0xcc, 0xd2, 0x8a, 0x29, 0xe1, 0x25, 0x14, 0x81, 0x38, 0x7c, 0xeb, 0xdd, 0x07,
+	0xc9, 0x65, 0x29, 0xea, 0x95, 0xfa, 0x03, 0x28, 0xe1, 0xe4, 0x93, 0x62, 0xd4, 0x07, 0x92, 0x79,
+	0x9a, 0x35, 0xc7, 0xbb, 0x07, 0xd8, 0x3c, 0x4f, 0x0b, 0x55, 0x32, 0xc7, 0x6b, 0x58, 0xa8, 0xc1,
+	0x21, 0x34, 0xa3, 0x7b, 0x33, 0x8a, 0x6f, 0xff, 0xdd, 0xd5, 0x51, 0x2d, 0x6e, 0xc5, 0x2e, 0x4f,
+	0xff, 0xb9, 0xff, 0x1d, 0x00, 0xeb, 0xc8, 0x06, 0x65, 0x30, 0x01, 0x00, 0x00,
 }
@@ -0,0 +1,83 @@
+package grpc
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// StatusTrailerKey is the trailer metadata key which holds the Status of a failed call
+const StatusTrailerKey = "btrfaas-status-bin"
+
+// StatusError is an error which carries a Status
+type StatusError struct {
+	Status *Status
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	msg := e.Status.Message
+	if msg == "" && e.Err != nil {
+		msg = e.Err.Error()
+	}
+	if e.Status.FunctionId != "" {
+		msg = fmt.Sprintf("%v (chain position %v): %v", e.Status.FunctionId, e.Status.ChainPosition, msg)
+	}
+	if e.Status.ExitCode != 0 {
+		msg = fmt.Sprintf("%v (exit code %v)", msg, e.Status.ExitCode)
+	}
+	return msg
+}
+
+// StatusFromError returns the Status of a StatusError or creates a new Status describing the given error,
+// such a Status is retryable if the error has the gRPC code Unavailable or ResourceExhausted
+func StatusFromError(err error) *Status {
+	if e, ok := err.(*StatusError); ok {
+		return e.Status
+	}
+	st := &Status{Message: err.Error()}
+	if s, ok := status.FromError(err); ok {
+		st.Message = s.Message()
+		st.Retryable = s.Code() == codes.Unavailable || s.Code() == codes.ResourceExhausted
+	}
+	return st
+}
+
+// StatusToMetadata encodes a Status so that it can be send as trailer metadata
+func StatusToMetadata(st *Status) metadata.MD {
+	bs, err := proto.Marshal(st)
+	if err != nil {
+		return nil
+	}
+	return metadata.Pairs(StatusTrailerKey, string(bs))
+}
+
+// StatusFromMetadata decodes a Status from trailer metadata, it returns nil if there is none
+func StatusFromMetadata(md metadata.MD) *Status {
+	values, ok := md[StatusTrailerKey]
+	if !ok || len(values) == 0 {
+		return nil
+	}
+	st := &Status{}
+	if err := proto.Unmarshal([]byte(values[0]), st); err != nil {
+		return nil
+	}
+	return st
+}
+
+// TrailerStream is a stream which is able to return trailer metadata, like FunctionRunner_RunClient
+type TrailerStream interface {
+	Trailer() metadata.MD
+}
+
+// ErrorFromTrailer turns err into a StatusError if the trailer of the stream contains a Status
+func ErrorFromTrailer(stream TrailerStream, err error) error {
+	st := StatusFromMetadata(stream.Trailer())
+	if st == nil {
+		return err
+	}
+	return &StatusError{Status: st, Err: err}
+}
@@ -0,0 +1,90 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Path is the HTTP path of the health endpoint used by orchestrator probes
+const Path = "/healthz"
+
+var (
+	mutex   sync.RWMutex
+	serving bool
+	server  = grpchealth.NewServer()
+)
+
+func init() {
+	server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// SetServing sets the health of the process, it starts as not serving
+func SetServing(s bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	serving = s
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if s {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	server.SetServingStatus("", status)
+}
+
+// Serving returns true if the process is serving
+func Serving() bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return serving
+}
+
+// Register registers the grpc.health.v1 service on a gRPC server
+func Register(s *grpc.Server) {
+	healthpb.RegisterHealthServer(s, server)
+}
+
+// Handler returns an HTTP handler which responds with 200 if the process is serving and with 503 otherwise
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Serving() {
+			http.Error(w, "NOT_SERVING", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("SERVING\n"))
+	})
+}
+
+// ListenAndServe serves grpc.health.v1 without TLS on addr for probes which run in the container,
+// so addr should be a loopback address
+func ListenAndServe(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer()
+	Register(s)
+	return s.Serve(lis)
+}
+
+// Probe checks the grpc.health.v1 service served by ListenAndServe, it returns an error unless the process is serving
+func Probe(ctx context.Context, addr string) error {
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("%v is %v", addr, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package health_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	. "github.com/trusch/btrfaas/health"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	AfterEach(func() {
+		SetServing(false)
+	})
+
+	It("should report the status via HTTP", func() {
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, httptest.NewRequest("GET", Path, nil))
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		SetServing(true)
+		rec = httptest.NewRecorder()
+		Handler().ServeHTTP(rec, httptest.NewRequest("GET", Path, nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should report the status via grpc.health.v1", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		server := grpc.NewServer()
+		Register(server)
+		go server.Serve(lis)
+		defer server.Stop()
+		conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		client := healthpb.NewHealthClient(conn)
+
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Status).To(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+		SetServing(true)
+		resp, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Status).To(Equal(healthpb.HealthCheckResponse_SERVING))
+	})
+
+	It("should be probed via a local health server", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		addr := lis.Addr().String()
+		lis.Close()
+		go ListenAndServe(addr)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		Expect(Probe(ctx, addr)).To(MatchError(ContainSubstring("NOT_SERVING")))
+		SetServing(true)
+		Expect(Probe(ctx, addr)).To(Succeed())
+	})
+})
@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/trusch/btrfaas/deployment"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Secret is the id of the secret containing the pipelines of the gateway
+const Secret = "fgateway-pipelines"
+
+// Manager manages the pipelines of a deployment.
+// The pipeline list is kept in ~/.btrfaas/<env>/pipelines.yaml and deployed as secret which the gateway reloads.
+type Manager struct {
+	platform deployment.SecretPlatform
+	env      string
+	path     string
+}
+
+// NewManager returns a new manager instance
+func NewManager(platform deployment.SecretPlatform, env string) (*Manager, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(home, ".btrfaas", env)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Manager{platform: platform, env: env, path: filepath.Join(dir, "pipelines.yaml")}, nil
+}
+
+// Deploy deploys a pipeline, a pipeline with the same id is replaced
+func (manager *Manager) Deploy(ctx context.Context, spec *Spec) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	list, err := manager.load(ctx)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for idx, existing := range list.Pipelines {
+		if existing.ID == spec.ID {
+			list.Pipelines[idx] = spec
+			replaced = true
+		}
+	}
+	if !replaced {
+		list.Pipelines = append(list.Pipelines, spec)
+	}
+	return manager.save(ctx, list)
+}
+
+// List returns all pipelines
+func (manager *Manager) List(ctx context.Context) ([]*Spec, error) {
+	list, err := manager.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return list.Pipelines, nil
+}
+
+// Undeploy removes a pipeline, the gateway stops resolving it after reloading the secret
+func (manager *Manager) Undeploy(ctx context.Context, id string) error {
+	list, err := manager.load(ctx)
+	if err != nil {
+		return err
+	}
+	for idx, spec := range list.Pipelines {
+		if spec.ID == id {
+			list.Pipelines = append(list.Pipelines[:idx], list.Pipelines[idx+1:]...)
+			return manager.save(ctx, list)
+		}
+	}
+	return fmt.Errorf("no such pipeline: %v", id)
+}
+
+// load reads the local pipeline list, or the deployed secret if there is no local copy.
+// A deployed secret which can't be read is an error, saving a new list would drop the deployed pipelines.
+func (manager *Manager) load(ctx context.Context) (*List, error) {
+	bs, err := ioutil.ReadFile(manager.path)
+	if os.IsNotExist(err) {
+		// swarm doesn't return the value of secrets, this only works on docker and kubernetes
+		if bs, err = deployment.ReadSecret(ctx, manager.platform, manager.env, Secret); err != nil {
+			return nil, fmt.Errorf("no local copy in %v and %v, restore the file to change the pipelines", manager.path, err)
+		}
+		if bs == nil {
+			return &List{}, nil
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return ParseList(bs)
+}
+
+func (manager *Manager) save(ctx context.Context, list *List) error {
+	bs, err := yaml.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err = deployment.ReplaceSecret(ctx, manager.platform, manager.env, Secret, bs); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(manager.path, bs, 0600)
+}
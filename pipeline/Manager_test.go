@@ -0,0 +1,166 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/trusch/btrfaas/deployment"
+	. "github.com/trusch/btrfaas/pipeline"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Manager", func() {
+	var (
+		ctx      = context.Background()
+		home     string
+		oldHome  string
+		platform *secretPlatform
+		manager  *Manager
+	)
+
+	BeforeEach(func() {
+		var err error
+		home, err = ioutil.TempDir("", "pipeline")
+		Expect(err).NotTo(HaveOccurred())
+		oldHome = os.Getenv("HOME")
+		os.Setenv("HOME", home)
+		homedir.DisableCache = true
+		platform = &secretPlatform{secrets: make(map[string][]byte)}
+		manager, err = NewManager(platform, "test")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.Setenv("HOME", oldHome)
+		os.RemoveAll(home)
+	})
+
+	deployedPipelines := func() []*Spec {
+		list, err := ParseList(platform.get(Secret))
+		Expect(err).NotTo(HaveOccurred())
+		return list.Pipelines
+	}
+
+	It("should deploy and replace pipelines", func() {
+		Expect(manager.Deploy(ctx, &Spec{ID: "logs", Expression: "sed -e s/a/b/ | to-upper"})).To(Succeed())
+		Expect(manager.Deploy(ctx, &Spec{ID: "upper", Expression: "to-upper"})).To(Succeed())
+		Expect(manager.Deploy(ctx, &Spec{ID: "logs", Expression: "to-upper | sed -e s/A/B/"})).To(Succeed())
+		pipelines := deployedPipelines()
+		Expect(pipelines).To(HaveLen(2))
+		Expect(pipelines[0].Expression).To(Equal("to-upper | sed -e s/A/B/"))
+		specs, err := manager.List(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(specs).To(Equal(pipelines))
+	})
+
+	It("should not deploy invalid pipelines", func() {
+		Expect(manager.Deploy(ctx, &Spec{ID: "logs", Expression: "(to-upper"})).NotTo(Succeed())
+		Expect(platform.get(Secret)).To(BeNil())
+	})
+
+	It("should undeploy pipelines", func() {
+		Expect(manager.Deploy(ctx, &Spec{ID: "logs", Expression: "to-upper"})).To(Succeed())
+		Expect(manager.Undeploy(ctx, "logs")).To(Succeed())
+		Expect(deployedPipelines()).To(BeEmpty())
+		Expect(manager.Undeploy(ctx, "logs")).NotTo(Succeed())
+	})
+
+	It("should fall back to the deployed pipelines without a local copy", func() {
+		Expect(manager.Deploy(ctx, &Spec{ID: "logs", Expression: "to-upper"})).To(Succeed())
+		Expect(os.RemoveAll(home)).To(Succeed())
+		var err error
+		manager, err = NewManager(platform, "test")
+		Expect(err).NotTo(HaveOccurred())
+		specs, err := manager.List(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(specs).To(HaveLen(1))
+	})
+
+	It("should not overwrite deployed pipelines which can't be read", func() {
+		Expect(manager.Deploy(ctx, &Spec{ID: "logs", Expression: "to-upper"})).To(Succeed())
+		Expect(os.RemoveAll(home)).To(Succeed())
+		platform.hideValues = true
+		var err error
+		manager, err = NewManager(platform, "test")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = manager.List(ctx)
+		Expect(err).To(HaveOccurred())
+		Expect(manager.Deploy(ctx, &Spec{ID: "upper", Expression: "to-upper"})).NotTo(Succeed())
+		Expect(manager.Undeploy(ctx, "logs")).NotTo(Succeed())
+		Expect(deployedPipelines()).To(HaveLen(1))
+	})
+
+	It("should start with an empty list if nothing is deployed", func() {
+		platform.hideValues = true
+		specs, err := manager.List(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(specs).To(BeEmpty())
+		Expect(manager.Deploy(ctx, &Spec{ID: "logs", Expression: "to-upper"})).To(Succeed())
+	})
+})
+
+// secretPlatform keeps secrets in memory, with hideValues it returns empty values like swarm
+type secretPlatform struct {
+	mutex      sync.Mutex
+	secrets    map[string][]byte
+	hideValues bool
+}
+
+func (p *secretPlatform) get(id string) []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.secrets[id]
+}
+
+func (p *secretPlatform) DeploySecret(ctx context.Context, options *deployment.DeploySecretOptions) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.secrets[options.ID] = options.Value
+	return nil
+}
+
+func (p *secretPlatform) UndeploySecret(ctx context.Context, options *deployment.UndeploySecretOptions) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.secrets, options.ID)
+	return nil
+}
+
+func (p *secretPlatform) UpdateSecret(ctx context.Context, options *deployment.UpdateSecretOptions) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.secrets[options.ID]; !ok {
+		return errors.New("no such secret")
+	}
+	p.secrets[options.ID] = options.Value
+	return nil
+}
+
+func (p *secretPlatform) GetSecret(ctx context.Context, options *deployment.GetSecretOptions) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	value, ok := p.secrets[options.ID]
+	if !ok {
+		return nil, errors.New("no such secret")
+	}
+	if p.hideValues {
+		return nil, nil
+	}
+	return value, nil
+}
+
+func (p *secretPlatform) ListSecrets(ctx context.Context, options *deployment.ListSecretsOptions) ([]*deployment.SecretInfo, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var res []*deployment.SecretInfo
+	for id := range p.secrets {
+		res = append(res, &deployment.SecretInfo{ID: id})
+	}
+	return res, nil
+}
@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/trusch/btrfaas/expression"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Spec is a named function expression, it is deployed from a file like
+//
+//	id: ingest-logs
+//	description: normalize and archive log lines
+//	expression: "sed -e 's/ERROR/error/' | tee (archive) | to-upper"
+//
+// Callers invoke it by its id like a function.
+type Spec struct {
+	ID          string `yaml:"id"`
+	Description string `yaml:"description,omitempty"`
+	Expression  string `yaml:"expression"`
+}
+
+// List is the content of the pipelines secret
+type List struct {
+	Pipelines []*Spec `yaml:"pipelines"`
+}
+
+// ParseSpec parses and validates a YAML pipeline spec
+func ParseSpec(bs []byte) (*Spec, error) {
+	spec := &Spec{}
+	if err := yaml.Unmarshal(bs, spec); err != nil {
+		return nil, err
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// ParseList parses and validates a YAML pipeline list
+func ParseList(bs []byte) (*List, error) {
+	list := &List{}
+	if err := yaml.Unmarshal(bs, list); err != nil {
+		return nil, err
+	}
+	for idx, spec := range list.Pipelines {
+		if spec == nil {
+			return nil, fmt.Errorf("pipeline %v is empty", idx+1)
+		}
+		if err := spec.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// Validate checks that the id can be used as a function id and that the expression parses
+func (spec *Spec) Validate() error {
+	if spec.ID == "" {
+		return fmt.Errorf("a pipeline needs an id")
+	}
+	id, err := expression.Parse(spec.ID)
+	if err != nil || !id.Linear() || len(id.Stages()) != 1 || id.Stages()[0].Function != spec.ID || len(id.Stages()[0].Options) > 0 {
+		return fmt.Errorf("invalid pipeline id %v, it must be a valid function id", expression.Quote(spec.ID))
+	}
+	if _, err = spec.Parse(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Parse parses the expression of the pipeline
+func (spec *Spec) Parse() (*expression.Expression, error) {
+	if spec.Expression == "" {
+		return nil, fmt.Errorf("pipeline %v has no expression", spec.ID)
+	}
+	expr, err := expression.Parse(spec.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline %v: malformed function expression: %v", spec.ID, err)
+	}
+	return expr, nil
+}
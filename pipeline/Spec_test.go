@@ -0,0 +1,36 @@
+package pipeline_test
+
+import (
+	. "github.com/trusch/btrfaas/pipeline"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Spec", func() {
+	It("should parse a spec", func() {
+		spec, err := ParseSpec([]byte("id: ingest-logs\nexpression: \"sed -e 's/a/b/' | (to-upper & wc)\"\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.ID).To(Equal("ingest-logs"))
+		expr, err := spec.Parse()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.Chain()).To(Equal([]string{"sed", "to-upper", "wc"}))
+	})
+
+	It("should reject invalid specs", func() {
+		for _, c := range []struct {
+			spec string
+			msg  string
+		}{
+			{"expression: echo", "needs an id"},
+			{"id: 'a b'\nexpression: echo", "invalid pipeline id"},
+			{"id: -a\nexpression: echo", "invalid pipeline id"},
+			{"id: logs", "has no expression"},
+			{"id: logs\nexpression: 'echo |'", "malformed function expression"},
+		} {
+			_, err := ParseSpec([]byte(c.spec))
+			Expect(err).To(HaveOccurred(), c.spec)
+			Expect(err.Error()).To(ContainSubstring(c.msg), c.spec)
+		}
+	})
+})
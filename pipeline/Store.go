@@ -0,0 +1,182 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/trusch/btrfaas/expression"
+)
+
+// maxDepth limits how deep pipelines may reference other pipelines, it catches pipelines which reference themselves
+const maxDepth = 8
+
+// Store keeps the deployed pipelines of the gateway and reloads them when their file changes.
+// A missing file means that there are no pipelines.
+type Store struct {
+	path      string
+	mutex     sync.RWMutex
+	raw       []byte
+	pipelines map[string]*expression.Expression
+	loaded    bool
+	stop      chan struct{}
+	once      sync.Once
+}
+
+// NewStore loads the pipeline file
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, stop: make(chan struct{})}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the pipeline file again and swaps the pipelines if it changed, a broken file keeps the current pipelines
+func (s *Store) Reload() (bool, error) {
+	bs, err := readFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("could not read pipelines: %v", err)
+	}
+	s.mutex.RLock()
+	unchanged := s.loaded && bytes.Equal(s.raw, bs)
+	s.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	list, err := ParseList(bs)
+	if err != nil {
+		return false, fmt.Errorf("%v: %v", s.path, err)
+	}
+	pipelines := make(map[string]*expression.Expression, len(list.Pipelines))
+	for _, spec := range list.Pipelines {
+		if pipelines[spec.ID], err = spec.Parse(); err != nil {
+			return false, err
+		}
+	}
+	s.mutex.Lock()
+	s.raw, s.pipelines, s.loaded = bs, pipelines, true
+	s.mutex.Unlock()
+	return true, nil
+}
+
+// Watch checks the pipeline file for changes every interval until Close is called
+func (s *Store) Watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				changed, err := s.Reload()
+				if err != nil {
+					log.Warnf("failed to reload pipelines %v: %v", s.path, err)
+				} else if changed {
+					log.Infof("reloaded pipelines %v", s.path)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops watching the pipeline file
+func (s *Store) Close() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+}
+
+// Resolve replaces the stages of expr which name a pipeline by the expression of the pipeline.
+// Pipelines take no options, since theirs are bound in the spec. A nil store returns expr as it is.
+func (s *Store) Resolve(expr *expression.Expression) (*expression.Expression, error) {
+	if s == nil {
+		return expr, nil
+	}
+	s.mutex.RLock()
+	pipelines := s.pipelines
+	s.mutex.RUnlock()
+	if len(pipelines) == 0 {
+		return expr, nil
+	}
+	pipeline, err := resolvePipeline(pipelines, expr.Pipeline, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &expression.Expression{Pipeline: pipeline}, nil
+}
+
+// resolvePipeline returns a copy of pipeline with resolved stages, the parsed pipelines are shared and never modified
+func resolvePipeline(pipelines map[string]*expression.Expression, pipeline *expression.Pipeline, depth int) (*expression.Pipeline, error) {
+	res := &expression.Pipeline{Nodes: make([]expression.Node, len(pipeline.Nodes))}
+	for i, node := range pipeline.Nodes {
+		var err error
+		switch node := node.(type) {
+		case *expression.Stage:
+			res.Nodes[i], err = resolveStage(pipelines, node, depth)
+		case *expression.Group:
+			res.Nodes[i], err = resolveGroup(pipelines, node, depth)
+		case *expression.Tee:
+			var branch *expression.Group
+			if branch, err = resolveGroup(pipelines, node.Branch, depth); err == nil {
+				res.Nodes[i] = &expression.Tee{Branch: branch, Column: node.Column}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func resolveStage(pipelines map[string]*expression.Expression, stage *expression.Stage, depth int) (expression.Node, error) {
+	expr, ok := pipelines[stage.Function]
+	if !ok {
+		copied := *stage
+		copied.Options = append([]string(nil), stage.Options...)
+		return &copied, nil
+	}
+	if len(stage.Options) > 0 {
+		return nil, fmt.Errorf("pipeline %v takes no options", stage.Function)
+	}
+	if depth == maxDepth {
+		return nil, fmt.Errorf("pipeline %v is nested too deep, does it reference itself?", stage.Function)
+	}
+	pipeline, err := resolvePipeline(pipelines, expr.Pipeline, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	return &expression.Group{Merge: expression.Concat, Branches: []*expression.Pipeline{pipeline}, Column: stage.Column}, nil
+}
+
+func resolveGroup(pipelines map[string]*expression.Expression, group *expression.Group, depth int) (*expression.Group, error) {
+	res := &expression.Group{Merge: group.Merge, Branches: make([]*expression.Pipeline, len(group.Branches)), Column: group.Column}
+	for i, branch := range group.Branches {
+		var err error
+		if res.Branches[i], err = resolvePipeline(pipelines, branch, depth); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// readFile reads a file, it falls back to <path>/value which is where kubernetes mounts secrets
+func readFile(path string) ([]byte, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		if value, e := ioutil.ReadFile(path + "/value"); e == nil {
+			return value, nil
+		}
+		return nil, err
+	}
+	return bs, nil
+}
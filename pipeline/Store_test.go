@@ -0,0 +1,102 @@
+package pipeline_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/trusch/btrfaas/expression"
+	. "github.com/trusch/btrfaas/pipeline"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Store", func() {
+	var (
+		dir   string
+		path  string
+		store *Store
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "pipeline")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "pipelines.yaml")
+		Expect(ioutil.WriteFile(path, []byte(`pipelines:
+- id: ingest-logs
+  expression: "sed -e 's/a b/c/' | tee (archive) | upper"
+- id: upper
+  expression: to-upper
+- id: loop
+  expression: echo | loop
+`), 0600)).To(Succeed())
+		store, err = NewStore(path)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	resolve := func(input string) (*expression.Expression, error) {
+		expr, err := expression.Parse(input)
+		Expect(err).NotTo(HaveOccurred())
+		return store.Resolve(expr)
+	}
+
+	It("should resolve pipelines recursively", func() {
+		expr, err := resolve("cat | ingest-logs")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.String()).To(Equal("cat | (sed -e 's/a b/c/' | tee (archive) | (to-upper))"))
+		Expect(expr.Chain()).To(Equal([]string{"cat", "sed", "archive", "to-upper"}))
+	})
+
+	It("should keep functions and not modify the pipelines", func() {
+		expr, err := resolve("(upper & echo)")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.String()).To(Equal("((to-upper) & echo)"))
+		expr.Stages()[0].Options = []string{"modified"}
+		expr, err = resolve("upper")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.Options()).To(Equal([][]string{nil}))
+	})
+
+	It("should reject options and cycles", func() {
+		_, err := resolve("upper -x")
+		Expect(err).To(MatchError(ContainSubstring("takes no options")))
+		_, err = resolve("loop")
+		Expect(err).To(MatchError(ContainSubstring("nested too deep")))
+	})
+
+	It("should reload the pipelines and keep them if the file is broken", func() {
+		Expect(ioutil.WriteFile(path, []byte("pipelines:\n- id: upper\n  expression: tr a-z A-Z\n"), 0600)).To(Succeed())
+		changed, err := store.Reload()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		expr, err := resolve("upper")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.String()).To(Equal("(tr a-z A-Z)"))
+
+		Expect(ioutil.WriteFile(path, []byte("pipelines:\n- id: upper\n"), 0600)).To(Succeed())
+		_, err = store.Reload()
+		Expect(err).To(HaveOccurred())
+		expr, err = resolve("upper")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr.String()).To(Equal("(tr a-z A-Z)"))
+	})
+
+	It("should treat a missing file and a nil store as no pipelines", func() {
+		empty, err := NewStore(filepath.Join(dir, "missing.yaml"))
+		Expect(err).NotTo(HaveOccurred())
+		expr, _ := expression.Parse("upper")
+		resolved, err := empty.Resolve(expr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolved).To(Equal(expr))
+		var store *Store
+		resolved, err = store.Resolve(expr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolved).To(Equal(expr))
+	})
+})
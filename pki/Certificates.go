@@ -0,0 +1,182 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// clockSkew is subtracted from NotBefore so that freshly issued certificates are accepted by hosts with a slightly wrong clock
+const clockSkew = 5 * time.Minute
+
+var rsaBits = 2048
+
+// certificate types, they are derived from the extended key usage.
+// User certificates are client certificates which are only kept in ~/.btrfaas/<env>/users.
+const (
+	TypeCA     = "ca"
+	TypeServer = "server"
+	TypeClient = "client"
+	TypeUser   = "user"
+)
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// newCA creates a self signed CA
+func newCA(validity time.Duration) (*x509.Certificate, crypto.Signer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, rsaBits)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "btrfaas"},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// issue creates a certificate of the given type for id, it doesn't outlive the CA.
+// The groups are stored as organizations of the subject.
+func issue(ca *x509.Certificate, caKey crypto.Signer, id, typ string, groups []string, validity time.Duration) (*x509.Certificate, crypto.Signer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, rsaBits)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.NotAfter) {
+		notAfter = ca.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: id, Organization: groups},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	switch typ {
+	case TypeServer:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.DNSNames = []string{id}
+	case TypeClient, TypeUser:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	default:
+		return nil, nil, fmt.Errorf("unknown certificate type: %v", typ)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// certType returns the type of a certificate
+func certType(cert *x509.Certificate) string {
+	if cert.IsCA {
+		return TypeCA
+	}
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth {
+			return TypeServer
+		}
+	}
+	return TypeClient
+}
+
+func encodeCert(certs ...*x509.Certificate) []byte {
+	var res []byte
+	for _, cert := range certs {
+		res = append(res, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return res
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("unsupported key type")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), nil
+}
+
+// parseCerts parses all certificates of a PEM bundle
+func parseCerts(bs []byte) ([]*x509.Certificate, error) {
+	var res []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bs = pem.Decode(bs)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, cert)
+	}
+	if len(res) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return res, nil
+}
+
+// parseKey parses a PEM encoded PKCS1, PKCS8 or EC private key
+func parseKey(bs []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return nil, errors.New("no private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported key type")
+	}
+	return signer, nil
+}
@@ -2,125 +2,591 @@ package pki
 
 import (
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/trusch/btrfaas/deployment"
-	"github.com/trusch/pki"
+	"github.com/trusch/btrfaas/deployment/vault"
+	yaml "gopkg.in/yaml.v2"
 )
 
-var (
-	curve   = ""
-	rsaBits = 2048
+const (
+	// ClientID is the id of the client certificate shared by the gateway and the command line tools
+	ClientID = "client"
+	// GatewayID is the id of the server certificate of the gateway
+	GatewayID = "fgateway"
+	// CASecret is the id of the secret containing the CA bundle
+	CASecret = "btrfaas-ca-cert"
+	// CRLSecret is the id of the secret containing the certificate revocation list
+	CRLSecret = "btrfaas-crl"
 )
 
-func init() {
-
-}
+var (
+	// userName matches the names of user certificates, they are used as file names
+	userName = regexp.MustCompile("^[a-z0-9-]+$")
+	// DefaultValidity is the validity of issued certificates if the environment doesn't configure one
+	DefaultValidity = 365 * 24 * time.Hour
+	// DefaultCAValidity is the validity of a new CA if the environment doesn't configure one
+	DefaultCAValidity = 10 * 365 * 24 * time.Hour
+)
 
-// Manager manages the public key infrastructure of a deployment
+// Manager manages the public key infrastructure of a deployment.
+// The CA, the settings, copies of the issued certificates and the revocation list are kept in ~/.btrfaas/<env>.
+// The CA key is encrypted if BTRFAAS_SECRET_PASSPHRASE or BTRFAAS_SECRET_KEY_FILE is set.
 type Manager struct {
 	platform deployment.SecretPlatform
 	env      string
-	ca       *pki.CA
+	dir      string
+	cas      []*x509.Certificate // the CA bundle, the current CA first
+	caKey    crypto.Signer
+	key      *vault.Key // seals the CA key, nil keeps it in plaintext
+	settings *settings
+}
+
+// settings are the persistent PKI settings of an environment
+type settings struct {
+	Validity   string `yaml:"validity,omitempty"`
+	CAValidity string `yaml:"caValidity,omitempty"`
+}
+
+// CertificateInfo describes a certificate of the environment
+type CertificateInfo struct {
+	ID        string
+	Type      string
+	Serial    string
+	NotBefore time.Time
+	NotAfter  time.Time
+	Groups    []string
+	Revoked   bool
+	Known     bool // false if the certificate is deployed but couldn't be read back, like swarm secrets of older versions
 }
 
 // NewManager returns a new manager instance and creates keys if necessary
 func NewManager(ctx context.Context, platform deployment.SecretPlatform, env string) (*Manager, error) {
-	var ca *pki.CA
 	home, err := homedir.Dir()
 	if err != nil {
 		return nil, err
 	}
-	caCrtFile := filepath.Join(home, fmt.Sprintf(".btrfaas/%v/ca-cert.pem", env))
-	caKeyFile := filepath.Join(home, fmt.Sprintf(".btrfaas/%v/ca-key.pem", env))
-	if _, err = os.Stat(caCrtFile); err != nil {
-		ca, err = pki.NewSelfSignedCA("btrfaas", curve, rsaBits)
-		if err != nil {
-			return nil, err
-		}
-		cert, getCertErr := ca.GetCertAsPEM()
-		if getCertErr != nil {
-			return nil, err
-		}
-		key, getKeyErr := ca.GetKeyAsPEM()
-		if getKeyErr != nil {
-			return nil, err
-		}
-		if err = os.MkdirAll(fmt.Sprintf("%v/.btrfaas/%v", home, env), 0755); err != nil {
-			return nil, err
-		}
-		if err = ioutil.WriteFile(caCrtFile, cert, 0600); err != nil {
-			return nil, err
-		}
-		if err = ioutil.WriteFile(caKeyFile, key, 0600); err != nil {
-			return nil, err
-		}
-	} else {
-		certBs, e := ioutil.ReadFile(caCrtFile)
+	key, err := vault.KeyFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	manager := &Manager{
+		platform: platform,
+		env:      env,
+		dir:      filepath.Join(home, ".btrfaas", env),
+		key:      key,
+		settings: &settings{},
+	}
+	if err = os.MkdirAll(manager.dir, 0755); err != nil {
+		return nil, err
+	}
+	if err = manager.loadSettings(); err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(manager.path("ca-cert.pem")); err != nil {
+		ca, key, e := newCA(manager.caValidity())
 		if e != nil {
 			return nil, e
 		}
-		keyBs, e := ioutil.ReadFile(caKeyFile)
-		if e != nil {
+		if e = manager.saveCA([]*x509.Certificate{ca}, key); e != nil {
 			return nil, e
 		}
-		ca, err = pki.NewCA(certBs, keyBs, nil)
-		if err != nil {
+	} else if err = manager.loadCA(); err != nil {
+		return nil, err
+	}
+	if _, err = platform.GetSecret(ctx, &deployment.GetSecretOptions{
+		EnvironmentID: env,
+		ID:            CASecret,
+	}); err != nil {
+		if err = platform.DeploySecret(ctx, &deployment.DeploySecretOptions{
+			EnvironmentID: env,
+			ID:            CASecret,
+			Value:         encodeCert(manager.cas...),
+		}); err != nil {
 			return nil, err
 		}
 	}
 	if _, err = platform.GetSecret(ctx, &deployment.GetSecretOptions{
 		EnvironmentID: env,
-		ID:            "btrfaas-ca-cert",
+		ID:            CRLSecret,
 	}); err != nil {
-		cert, err := ca.GetCertAsPEM()
+		revoked, err := manager.revoked()
 		if err != nil {
 			return nil, err
 		}
-		if err = platform.DeploySecret(ctx, &deployment.DeploySecretOptions{
-			EnvironmentID: env,
-			ID:            "btrfaas-ca-cert",
-			Value:         cert,
-		}); err != nil {
+		if err = manager.saveCRL(ctx, revoked); err != nil {
 			return nil, err
 		}
 	}
-	return &Manager{platform, env, ca}, nil
+	return manager, nil
 }
 
-// IssueClient issues a new client certificate and saves it as secret
-func (manager *Manager) IssueClient(ctx context.Context, id string) error {
-	cert, key, err := manager.ca.IssueClient(id, curve, rsaBits)
+// SetValidity sets and saves the validity of certificates and CAs which are issued from now on, 0 keeps the current value
+func (manager *Manager) SetValidity(validity, caValidity time.Duration) error {
+	if validity > 0 {
+		manager.settings.Validity = validity.String()
+	}
+	if caValidity > 0 {
+		manager.settings.CAValidity = caValidity.String()
+	}
+	bs, err := yaml.Marshal(manager.settings)
 	if err != nil {
 		return err
 	}
-	return saveCertAndKeyAsSecret(ctx, manager.platform, manager.env, id, cert, key)
+	return ioutil.WriteFile(manager.path("pki.yaml"), bs, 0600)
+}
+
+// IssueClient issues a new client certificate and saves it as secret
+func (manager *Manager) IssueClient(ctx context.Context, id string) error {
+	return manager.issue(ctx, id, TypeClient, nil)
 }
 
 // IssueServer issues a new server certificate and saves it as secret
 func (manager *Manager) IssueServer(ctx context.Context, id string) error {
-	cert, key, err := manager.ca.IssueServer(id, curve, rsaBits)
+	return manager.issue(ctx, id, TypeServer, nil)
+}
+
+// IssueUser issues a client certificate for a user, the groups can be used in the authorization policy of the gateway.
+// The certificate and the key are only saved in ~/.btrfaas/<env>/users.
+func (manager *Manager) IssueUser(ctx context.Context, name string, groups []string) error {
+	if !userName.MatchString(name) {
+		return fmt.Errorf("invalid user name %q, only a-z, 0-9 and - are allowed", name)
+	}
+	if _, _, err := manager.find(ctx, name); err == nil {
+		return fmt.Errorf("a certificate for %v already exists", name)
+	}
+	return manager.issue(ctx, name, TypeUser, groups)
+}
+
+// ClientFiles returns the paths of the client certificate and key of a user of an environment,
+// or of the shared client certificate if user is empty
+func ClientFiles(env, user string) (cert, key string, err error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Join(home, ".btrfaas", env, "certs")
+	name := ClientID
+	if user != "" {
+		if !userName.MatchString(user) {
+			return "", "", fmt.Errorf("invalid user name %q", user)
+		}
+		dir = filepath.Join(home, ".btrfaas", env, "users")
+		name = user
+	}
+	return filepath.Join(dir, name+"-cert.pem"), filepath.Join(dir, name+"-key.pem"), nil
+}
+
+// Rotate issues new certificates for the given ids, or for all certificates if no id is given.
+// The old certificates stay valid until they expire.
+func (manager *Manager) Rotate(ctx context.Context, ids ...string) error {
+	certs, err := manager.Certificates(ctx)
 	if err != nil {
 		return err
 	}
-	return saveCertAndKeyAsSecret(ctx, manager.platform, manager.env, id, cert, key)
+	byID := make(map[string]*CertificateInfo)
+	for _, cert := range certs {
+		if cert.Type != TypeCA {
+			byID[cert.ID] = cert
+		}
+	}
+	if len(ids) == 0 {
+		for id := range byID {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+	for _, id := range ids {
+		cert, ok := byID[id]
+		if !ok {
+			return fmt.Errorf("unknown certificate: %v", id)
+		}
+		if err = manager.issue(ctx, id, cert.Type, cert.Groups); err != nil {
+			return fmt.Errorf("failed to rotate %v: %v", id, err)
+		}
+	}
+	return nil
 }
 
-func saveCertAndKeyAsSecret(ctx context.Context, platform deployment.SecretPlatform, env, id string, cert, key []byte) error {
-	if err := platform.DeploySecret(ctx, &deployment.DeploySecretOptions{
-		EnvironmentID: env,
-		ID:            id + "-key",
-		Value:         key,
-	}); err != nil {
+// RotateCA creates a new CA and issues new certificates for everything.
+// The CA bundle contains the new and the previous CA, so that peers which didn't reload yet keep working.
+func (manager *Manager) RotateCA(ctx context.Context) error {
+	ca, key, err := newCA(manager.caValidity())
+	if err != nil {
 		return err
 	}
-	return platform.DeploySecret(ctx, &deployment.DeploySecretOptions{
-		EnvironmentID: env,
+	revoked, err := manager.revoked()
+	if err != nil {
+		return err
+	}
+	if err = manager.saveCA([]*x509.Certificate{ca, manager.cas[0]}, key); err != nil {
+		return err
+	}
+	if err = deployment.ReplaceSecret(ctx, manager.platform, manager.env, CASecret, encodeCert(manager.cas...)); err != nil {
+		return err
+	}
+	if err = manager.saveCRL(ctx, revoked); err != nil {
+		return err
+	}
+	return manager.Rotate(ctx)
+}
+
+// Revoke adds the current certificate of id to the revocation list.
+// If renew is true, a new certificate is issued so that the legitimate owner keeps working.
+// The certificates of the gateway can't be revoked without renewal, since no function could be called anymore.
+func (manager *Manager) Revoke(ctx context.Context, id string, renew bool) error {
+	if !renew && (id == ClientID || id == GatewayID) {
+		return fmt.Errorf("the certificate of %v is needed to call functions, it can only be revoked together with a renewal", id)
+	}
+	cert, typ, err := manager.find(ctx, id)
+	if err != nil {
+		return err
+	}
+	revoked, err := manager.revoked()
+	if err != nil {
+		return err
+	}
+	revoked = append(revoked, pkix.RevokedCertificate{
+		SerialNumber:   cert.SerialNumber,
+		RevocationTime: time.Now(),
+	})
+	if err = manager.saveCRL(ctx, revoked); err != nil {
+		return err
+	}
+	if !renew {
+		return nil
+	}
+	return manager.issue(ctx, id, typ, cert.Subject.Organization)
+}
+
+// Certificates returns the CAs and all issued certificates of the environment
+func (manager *Manager) Certificates(ctx context.Context) ([]*CertificateInfo, error) {
+	revoked, err := manager.revoked()
+	if err != nil {
+		return nil, err
+	}
+	isRevoked := make(map[string]bool)
+	for _, entry := range revoked {
+		isRevoked[entry.SerialNumber.String()] = true
+	}
+	var res []*CertificateInfo
+	for _, ca := range manager.cas {
+		res = append(res, newCertificateInfo(CASecret, TypeCA, ca, false))
+	}
+	ids, err := manager.issuedIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		cert, typ, err := manager.find(ctx, id)
+		if err != nil {
+			res = append(res, &CertificateInfo{ID: id, Type: legacyType(id)})
+			continue
+		}
+		res = append(res, newCertificateInfo(id, typ, cert, isRevoked[cert.SerialNumber.String()]))
+	}
+	users, err := manager.users()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range users {
+		cert, err := readCert(manager.path("users", name+"-cert.pem"))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, newCertificateInfo(name, TypeUser, cert, isRevoked[cert.SerialNumber.String()]))
+	}
+	return res, nil
+}
+
+func newCertificateInfo(id, typ string, cert *x509.Certificate, revoked bool) *CertificateInfo {
+	return &CertificateInfo{
+		ID:        id,
+		Type:      typ,
+		Groups:    cert.Subject.Organization,
+		Serial:    fmt.Sprintf("%x", cert.SerialNumber),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		Revoked:   revoked,
+		Known:     true,
+	}
+}
+
+// legacyType guesses the type of certificates issued by older versions which didn't keep a copy
+func legacyType(id string) string {
+	if id == ClientID {
+		return TypeClient
+	}
+	return TypeServer
+}
+
+func (manager *Manager) issue(ctx context.Context, id, typ string, groups []string) error {
+	cert, key, err := issue(manager.cas[0], manager.caKey, id, typ, groups, manager.validity())
+	if err != nil {
+		return err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+	certPEM := encodeCert(cert)
+	if typ == TypeUser {
+		if err = os.MkdirAll(manager.path("users"), 0700); err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(manager.path("users", id+"-key.pem"), keyPEM, 0600); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(manager.path("users", id+"-cert.pem"), certPEM, 0644)
+	}
+	if err = saveCertAndKeyAsSecret(ctx, manager.platform, manager.env, id, certPEM, keyPEM); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(manager.path("certs"), 0755); err != nil {
+		return err
+	}
+	if typ == TypeClient {
+		// client certificates are used by the command line tools as well
+		if err = ioutil.WriteFile(manager.path("certs", id+"-key.pem"), keyPEM, 0600); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(manager.path("certs", id+"-cert.pem"), certPEM, 0644)
+}
+
+// find returns the current certificate of id and its type from the local copies or the deployed secret
+func (manager *Manager) find(ctx context.Context, id string) (*x509.Certificate, string, error) {
+	if cert, err := readCert(manager.path("certs", id+"-cert.pem")); err == nil {
+		return cert, certType(cert), nil
+	}
+	if cert, err := readCert(manager.path("users", id+"-cert.pem")); err == nil {
+		return cert, TypeUser, nil
+	}
+	bs, err := manager.platform.GetSecret(ctx, &deployment.GetSecretOptions{
+		EnvironmentID: manager.env,
 		ID:            id + "-cert",
-		Value:         cert,
 	})
+	if err != nil || len(bs) == 0 {
+		return nil, "", fmt.Errorf("no certificate known for %v", id)
+	}
+	certs, err := parseCerts(bs)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid certificate of %v: %v", id, err)
+	}
+	return certs[0], certType(certs[0]), nil
+}
+
+func readCert(path string) (*x509.Certificate, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parseCerts(bs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate %v: %v", path, err)
+	}
+	return certs[0], nil
+}
+
+// users returns the names of the users with a certificate
+func (manager *Manager) users() ([]string, error) {
+	files, err := ioutil.ReadDir(manager.path("users"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), "-cert.pem") {
+			res = append(res, strings.TrimSuffix(file.Name(), "-cert.pem"))
+		}
+	}
+	return res, nil
+}
+
+// issuedIDs returns the ids of the local copies and of all deployed certificate/key pairs
+func (manager *Manager) issuedIDs(ctx context.Context) ([]string, error) {
+	known := make(map[string]bool)
+	files, err := ioutil.ReadDir(manager.path("certs"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), "-cert.pem") {
+			known[strings.TrimSuffix(file.Name(), "-cert.pem")] = true
+		}
+	}
+	secrets, err := manager.platform.ListSecrets(ctx, &deployment.ListSecretsOptions{EnvironmentID: manager.env})
+	if err != nil {
+		return nil, err
+	}
+	secretIDs := make(map[string]bool)
+	for _, secret := range secrets {
+		secretIDs[secret.ID] = true
+	}
+	for id := range secretIDs {
+		if id == CASecret || !strings.HasSuffix(id, "-cert") {
+			continue
+		}
+		if name := strings.TrimSuffix(id, "-cert"); secretIDs[name+"-key"] {
+			known[name] = true
+		}
+	}
+	res := make([]string, 0, len(known))
+	for id := range known {
+		res = append(res, id)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// revoked returns the entries of the local revocation list
+func (manager *Manager) revoked() ([]pkix.RevokedCertificate, error) {
+	bs, err := ioutil.ReadFile(manager.path("crl.pem"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	crl, err := x509.ParseCRL(bs)
+	if err != nil {
+		return nil, err
+	}
+	return crl.TBSCertList.RevokedCertificates, nil
+}
+
+// saveCRL signs the revocation list with the current CA, saves it locally and deploys it as secret
+func (manager *Manager) saveCRL(ctx context.Context, revoked []pkix.RevokedCertificate) error {
+	now := time.Now()
+	der, err := manager.cas[0].CreateCRL(rand.Reader, manager.caKey, revoked, now, manager.cas[0].NotAfter)
+	if err != nil {
+		return err
+	}
+	crl := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	if err = ioutil.WriteFile(manager.path("crl.pem"), crl, 0644); err != nil {
+		return err
+	}
+	return deployment.ReplaceSecret(ctx, manager.platform, manager.env, CRLSecret, crl)
+}
+
+func (manager *Manager) loadCA() error {
+	certBs, err := ioutil.ReadFile(manager.path("ca-cert.pem"))
+	if err != nil {
+		return err
+	}
+	keyBs, err := ioutil.ReadFile(manager.path("ca-key.pem"))
+	if err != nil {
+		return err
+	}
+	if manager.cas, err = parseCerts(certBs); err != nil {
+		return fmt.Errorf("invalid ca certificate: %v", err)
+	}
+	sealed := vault.IsSealed(keyBs)
+	if sealed {
+		if manager.key == nil {
+			return fmt.Errorf("could not load ca key: %v", vault.ErrNoKey)
+		}
+		if keyBs, err = manager.key.Open(keyBs); err != nil {
+			return fmt.Errorf("could not load ca key: %v", err)
+		}
+	}
+	if manager.caKey, err = parseKey(keyBs); err != nil {
+		return fmt.Errorf("invalid ca key: %v", err)
+	}
+	if !sealed && manager.key != nil {
+		// encrypt a key which was created before the passphrase got configured
+		return manager.saveCA(manager.cas, manager.caKey)
+	}
+	return nil
+}
+
+func (manager *Manager) saveCA(cas []*x509.Certificate, key crypto.Signer) error {
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+	if manager.key != nil {
+		if keyPEM, err = manager.key.Seal(keyPEM); err != nil {
+			return err
+		}
+	}
+	if err = ioutil.WriteFile(manager.path("ca-key.pem"), keyPEM, 0600); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(manager.path("ca-cert.pem"), encodeCert(cas...), 0600); err != nil {
+		return err
+	}
+	manager.cas, manager.caKey = cas, key
+	return nil
+}
+
+func (manager *Manager) loadSettings() error {
+	bs, err := ioutil.ReadFile(manager.path("pki.yaml"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = yaml.Unmarshal(bs, manager.settings); err != nil {
+		return err
+	}
+	for _, val := range []string{manager.settings.Validity, manager.settings.CAValidity} {
+		if _, err = parseValidity(val, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (manager *Manager) validity() time.Duration {
+	d, _ := parseValidity(manager.settings.Validity, DefaultValidity)
+	return d
+}
+
+func (manager *Manager) caValidity() time.Duration {
+	d, _ := parseValidity(manager.settings.CAValidity, DefaultCAValidity)
+	return d
+}
+
+func parseValidity(val string, def time.Duration) (time.Duration, error) {
+	if val == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return def, fmt.Errorf("invalid validity %v: %v", val, err)
+	}
+	if d <= 0 {
+		return def, errors.New("validity must be positive")
+	}
+	return d, nil
+}
+
+func (manager *Manager) path(elem ...string) string {
+	return filepath.Join(append([]string{manager.dir}, elem...)...)
+}
+
+func saveCertAndKeyAsSecret(ctx context.Context, platform deployment.SecretPlatform, env, id string, cert, key []byte) error {
+	if err := deployment.ReplaceSecret(ctx, platform, env, id+"-key", key); err != nil {
+		return err
+	}
+	return deployment.ReplaceSecret(ctx, platform, env, id+"-cert", cert)
 }
@@ -0,0 +1,308 @@
+package pki_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/trusch/btrfaas/deployment"
+	"github.com/trusch/btrfaas/deployment/vault"
+	. "github.com/trusch/btrfaas/pki"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Manager", func() {
+	var (
+		ctx      = context.Background()
+		home     string
+		oldHome  string
+		platform *secretPlatform
+		manager  *Manager
+	)
+
+	BeforeEach(func() {
+		var err error
+		home, err = ioutil.TempDir("", "pki")
+		Expect(err).NotTo(HaveOccurred())
+		oldHome = os.Getenv("HOME")
+		os.Setenv("HOME", home)
+		homedir.DisableCache = true
+		platform = &secretPlatform{secrets: make(map[string][]byte)}
+		manager, err = NewManager(ctx, platform, "test")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.Setenv("HOME", oldHome)
+		os.RemoveAll(home)
+	})
+
+	It("should deploy the CA and an empty revocation list", func() {
+		cas := parseCerts(platform.get(CASecret))
+		Expect(cas).To(HaveLen(1))
+		Expect(cas[0].IsCA).To(BeTrue())
+		crl, err := x509.ParseCRL(platform.get(CRLSecret))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cas[0].CheckCRLSignature(crl)).To(Succeed())
+		Expect(crl.TBSCertList.RevokedCertificates).To(BeEmpty())
+	})
+
+	It("should reuse the CA of the environment", func() {
+		ca := platform.get(CASecret)
+		_, err := NewManager(ctx, platform, "test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(platform.get(CASecret)).To(Equal(ca))
+	})
+
+	It("should issue certificates and list them", func() {
+		Expect(manager.IssueServer(ctx, "fgateway")).To(Succeed())
+		Expect(manager.IssueClient(ctx, "client")).To(Succeed())
+		Expect(platform.get("fgateway-key")).NotTo(BeEmpty())
+		certFile, keyFile, err := ClientFiles("test", "")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tls.LoadX509KeyPair(certFile, keyFile)
+		Expect(err).NotTo(HaveOccurred())
+		verify(platform, "fgateway", x509.ExtKeyUsageServerAuth)
+		verify(platform, "client", x509.ExtKeyUsageClientAuth)
+
+		certs, err := manager.Certificates(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certs).To(HaveLen(3))
+		Expect(certs[0].Type).To(Equal(TypeCA))
+		Expect(certs[1].ID).To(Equal("client"))
+		Expect(certs[1].Type).To(Equal(TypeClient))
+		Expect(certs[2].ID).To(Equal("fgateway"))
+		Expect(certs[2].Type).To(Equal(TypeServer))
+		Expect(certs[2].Known).To(BeTrue())
+		Expect(certs[2].NotAfter).To(BeTemporally("~", time.Now().Add(DefaultValidity), time.Minute))
+	})
+
+	It("should rotate certificates", func() {
+		Expect(manager.IssueServer(ctx, "fgateway")).To(Succeed())
+		Expect(manager.IssueServer(ctx, "echo")).To(Succeed())
+		gateway := verify(platform, "fgateway", x509.ExtKeyUsageServerAuth)
+		echo := verify(platform, "echo", x509.ExtKeyUsageServerAuth)
+
+		Expect(manager.Rotate(ctx, "echo")).To(Succeed())
+		Expect(verify(platform, "fgateway", x509.ExtKeyUsageServerAuth).SerialNumber).To(Equal(gateway.SerialNumber))
+		Expect(verify(platform, "echo", x509.ExtKeyUsageServerAuth).SerialNumber).NotTo(Equal(echo.SerialNumber))
+
+		Expect(manager.Rotate(ctx)).To(Succeed())
+		Expect(verify(platform, "fgateway", x509.ExtKeyUsageServerAuth).SerialNumber).NotTo(Equal(gateway.SerialNumber))
+		Expect(manager.Rotate(ctx, "unknown")).NotTo(Succeed())
+	})
+
+	It("should rotate the CA and keep the old one in the bundle", func() {
+		Expect(manager.IssueServer(ctx, "fgateway")).To(Succeed())
+		oldCA := parseCerts(platform.get(CASecret))[0]
+
+		Expect(manager.RotateCA(ctx)).To(Succeed())
+		cas := parseCerts(platform.get(CASecret))
+		Expect(cas).To(HaveLen(2))
+		Expect(cas[1].Equal(oldCA)).To(BeTrue())
+		cert := verify(platform, "fgateway", x509.ExtKeyUsageServerAuth)
+		Expect(cert.CheckSignatureFrom(cas[0])).To(Succeed())
+		crl, err := x509.ParseCRL(platform.get(CRLSecret))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cas[0].CheckCRLSignature(crl)).To(Succeed())
+
+		Expect(manager.RotateCA(ctx)).To(Succeed())
+		Expect(parseCerts(platform.get(CASecret))).To(HaveLen(2))
+	})
+
+	It("should revoke certificates", func() {
+		Expect(manager.IssueClient(ctx, "client")).To(Succeed())
+		Expect(manager.IssueClient(ctx, "alice")).To(Succeed())
+		client := verify(platform, "client", x509.ExtKeyUsageClientAuth)
+		alice := verify(platform, "alice", x509.ExtKeyUsageClientAuth)
+
+		Expect(manager.Revoke(ctx, "client", true)).To(Succeed())
+		Expect(manager.Revoke(ctx, "alice", false)).To(Succeed())
+		crl, err := x509.ParseCRL(platform.get(CRLSecret))
+		Expect(err).NotTo(HaveOccurred())
+		var serials []string
+		for _, entry := range crl.TBSCertList.RevokedCertificates {
+			serials = append(serials, entry.SerialNumber.String())
+		}
+		Expect(serials).To(ConsistOf(client.SerialNumber.String(), alice.SerialNumber.String()))
+		Expect(verify(platform, "client", x509.ExtKeyUsageClientAuth).SerialNumber).NotTo(Equal(client.SerialNumber))
+
+		certs, err := manager.Certificates(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		revoked := make(map[string]bool)
+		for _, cert := range certs {
+			revoked[cert.ID] = cert.Revoked
+		}
+		Expect(revoked).To(Equal(map[string]bool{CASecret: false, "alice": true, "client": false}))
+		Expect(manager.Revoke(ctx, "unknown", false)).NotTo(Succeed())
+	})
+
+	It("should refuse to revoke the certificates of the gateway without renewal", func() {
+		Expect(manager.IssueServer(ctx, GatewayID)).To(Succeed())
+		Expect(manager.IssueClient(ctx, ClientID)).To(Succeed())
+		Expect(manager.Revoke(ctx, GatewayID, false)).NotTo(Succeed())
+		Expect(manager.Revoke(ctx, ClientID, false)).NotTo(Succeed())
+		crl, err := x509.ParseCRL(platform.get(CRLSecret))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(crl.TBSCertList.RevokedCertificates).To(BeEmpty())
+	})
+
+	It("should issue user certificates without deploying them", func() {
+		Expect(manager.IssueServer(ctx, "echo")).To(Succeed())
+		Expect(manager.IssueUser(ctx, "alice", []string{"dev", "ops"})).To(Succeed())
+		Expect(manager.IssueUser(ctx, "alice", nil)).NotTo(Succeed())
+		Expect(manager.IssueUser(ctx, "echo", nil)).NotTo(Succeed())
+		Expect(manager.IssueUser(ctx, "../alice", nil)).NotTo(Succeed())
+		Expect(manager.IssueUser(ctx, "Alice", nil)).NotTo(Succeed())
+		_, _, err := ClientFiles("test", "../../certs/client")
+		Expect(err).To(HaveOccurred())
+		Expect(platform.get("alice-cert")).To(BeNil())
+
+		certFile, keyFile, err := ClientFiles("test", "alice")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tls.LoadX509KeyPair(certFile, keyFile)
+		Expect(err).NotTo(HaveOccurred())
+		bs, err := ioutil.ReadFile(certFile)
+		Expect(err).NotTo(HaveOccurred())
+		cert := parseCerts(bs)[0]
+		Expect(cert.Subject.CommonName).To(Equal("alice"))
+		Expect(cert.Subject.Organization).To(Equal([]string{"dev", "ops"}))
+		Expect(cert.ExtKeyUsage).To(Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}))
+
+		Expect(manager.Rotate(ctx, "alice")).To(Succeed())
+		certs, err := manager.Certificates(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		user := certs[len(certs)-1]
+		Expect(user.ID).To(Equal("alice"))
+		Expect(user.Type).To(Equal(TypeUser))
+		Expect(user.Groups).To(Equal([]string{"dev", "ops"}))
+		Expect(user.Serial).NotTo(Equal(fmt.Sprintf("%x", cert.SerialNumber)))
+
+		Expect(manager.Revoke(ctx, "alice", false)).To(Succeed())
+	})
+
+	It("should save the configured validity", func() {
+		Expect(manager.SetValidity(24*time.Hour, 0)).To(Succeed())
+		manager, err := NewManager(ctx, platform, "test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manager.IssueServer(ctx, "fgateway")).To(Succeed())
+		cert := verify(platform, "fgateway", x509.ExtKeyUsageServerAuth)
+		Expect(cert.NotAfter).To(BeTemporally("~", time.Now().Add(24*time.Hour), time.Minute))
+	})
+
+	It("should encrypt the CA key if a passphrase is configured", func() {
+		defer os.Unsetenv(vault.PassphraseEnv)
+		keyFile := filepath.Join(home, ".btrfaas", "test", "ca-key.pem")
+		bs, err := ioutil.ReadFile(keyFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vault.IsSealed(bs)).To(BeFalse())
+
+		// an existing key gets encrypted when the passphrase is configured
+		os.Setenv(vault.PassphraseEnv, "secret")
+		manager, err := NewManager(ctx, platform, "test")
+		Expect(err).NotTo(HaveOccurred())
+		bs, err = ioutil.ReadFile(keyFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vault.IsSealed(bs)).To(BeTrue())
+		Expect(manager.IssueServer(ctx, "fgateway")).To(Succeed())
+		verify(platform, "fgateway", x509.ExtKeyUsageServerAuth)
+
+		os.Setenv(vault.PassphraseEnv, "wrong")
+		_, err = NewManager(ctx, platform, "test")
+		Expect(err).To(HaveOccurred())
+		os.Unsetenv(vault.PassphraseEnv)
+		_, err = NewManager(ctx, platform, "test")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+// verify checks that the deployed certificate of id is signed by the deployed CA bundle and returns it
+func verify(platform *secretPlatform, id string, usage x509.ExtKeyUsage) *x509.Certificate {
+	cert := parseCerts(platform.get(id + "-cert"))[0]
+	pool := x509.NewCertPool()
+	Expect(pool.AppendCertsFromPEM(platform.get(CASecret))).To(BeTrue())
+	_, err := cert.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{usage}})
+	Expect(err).NotTo(HaveOccurred())
+	return cert
+}
+
+func parseCerts(bs []byte) []*x509.Certificate {
+	var res []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bs = pem.Decode(bs)
+		if block == nil {
+			return res
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		Expect(err).NotTo(HaveOccurred())
+		res = append(res, cert)
+	}
+}
+
+// secretPlatform keeps secrets in memory
+type secretPlatform struct {
+	mutex   sync.Mutex
+	secrets map[string][]byte
+}
+
+func (p *secretPlatform) get(id string) []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.secrets[id]
+}
+
+func (p *secretPlatform) DeploySecret(ctx context.Context, options *deployment.DeploySecretOptions) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.secrets[options.ID] = options.Value
+	return nil
+}
+
+func (p *secretPlatform) UndeploySecret(ctx context.Context, options *deployment.UndeploySecretOptions) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.secrets, options.ID)
+	return nil
+}
+
+func (p *secretPlatform) UpdateSecret(ctx context.Context, options *deployment.UpdateSecretOptions) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.secrets[options.ID]; !ok {
+		return errors.New("no such secret")
+	}
+	p.secrets[options.ID] = options.Value
+	return nil
+}
+
+func (p *secretPlatform) GetSecret(ctx context.Context, options *deployment.GetSecretOptions) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	value, ok := p.secrets[options.ID]
+	if !ok {
+		return nil, errors.New("no such secret")
+	}
+	return value, nil
+}
+
+func (p *secretPlatform) ListSecrets(ctx context.Context, options *deployment.ListSecretsOptions) ([]*deployment.SecretInfo, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var res []*deployment.SecretInfo
+	for id := range p.secrets {
+		res = append(res, &deployment.SecretInfo{ID: id})
+	}
+	return res, nil
+}
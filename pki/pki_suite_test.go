@@ -0,0 +1,13 @@
+package pki_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestPki(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Pki Suite")
+}
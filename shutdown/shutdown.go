@@ -0,0 +1,90 @@
+package shutdown
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/trusch/btrfaas/health"
+)
+
+// killTimeout is the time canceled calls get to report their errors before the servers are closed
+const killTimeout = 5 * time.Second
+
+// Server is a server which can be stopped gracefully, like http.Server
+type Server interface {
+	// Shutdown stops accepting new calls and waits until the running calls are finished or ctx is done
+	Shutdown(ctx context.Context) error
+	// Close stops the server immediately
+	Close() error
+}
+
+var killCtx, kill = context.WithCancel(context.Background())
+
+// Bind returns a context which is canceled as well when the grace period of the shutdown is over.
+// Servers bind the contexts of their calls, so that the calls (and their processes) get killed.
+func Bind(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-killCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Wait blocks until the process receives SIGTERM or SIGINT
+func Wait() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	sig := <-signals
+	log.Infof("received %v, shutting down", sig)
+}
+
+// Drain shuts the servers down: the health is set to not serving, the servers stop accepting new calls and the
+// running calls get gracePeriod to finish. Afterwards the remaining calls are canceled and the servers are closed.
+func Drain(gracePeriod time.Duration, servers ...Server) {
+	health.SetServing(false)
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	if err := shutdownAll(ctx, servers); err == nil {
+		log.Info("all calls finished")
+		return
+	}
+	log.Warnf("grace period of %v is over, canceling the remaining calls", gracePeriod)
+	kill()
+	ctx, cancel = context.WithTimeout(context.Background(), killTimeout)
+	defer cancel()
+	if err := shutdownAll(ctx, servers); err != nil {
+		for _, s := range servers {
+			s.Close()
+		}
+	}
+}
+
+func shutdownAll(ctx context.Context, servers []Server) error {
+	errs := make(chan error, len(servers))
+	wg := &sync.WaitGroup{}
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s Server) {
+			defer wg.Done()
+			errs <- s.Shutdown(ctx)
+		}(s)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package shutdown_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/trusch/btrfaas/health"
+	. "github.com/trusch/btrfaas/shutdown"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeServer is a server whose calls are tracked by a wait group
+type fakeServer struct {
+	calls  sync.WaitGroup
+	mutex  sync.Mutex
+	closed bool
+}
+
+func (s *fakeServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.calls.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *fakeServer) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeServer) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+// the specs run in order, since the grace period can only run out once per process
+var _ = Describe("Shutdown", func() {
+	It("should wait for running calls", func() {
+		health.SetServing(true)
+		server := &fakeServer{}
+		ctx, cancel := Bind(context.Background())
+		defer cancel()
+		server.calls.Add(1)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			server.calls.Done()
+		}()
+		start := time.Now()
+		Drain(time.Second, server)
+		Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(health.Serving()).To(BeFalse())
+		Expect(ctx.Err()).NotTo(HaveOccurred())
+		Expect(server.isClosed()).To(BeFalse())
+	})
+
+	It("should cancel the calls after the grace period", func() {
+		server := &fakeServer{}
+		ctx, cancel := Bind(context.Background())
+		defer cancel()
+		server.calls.Add(1)
+		go func() {
+			<-ctx.Done()
+			server.calls.Done()
+		}()
+		start := time.Now()
+		Drain(50*time.Millisecond, server)
+		Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+		Expect(ctx.Err()).To(Equal(context.Canceled))
+		Expect(server.isClosed()).To(BeFalse())
+	})
+})
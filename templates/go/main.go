@@ -5,6 +5,8 @@ import (
 
 	"github.com/trusch/btrfaas/frunner/config"
 	"github.com/trusch/btrfaas/frunner/grpc"
+	"github.com/trusch/btrfaas/frunner/metrics"
+	"github.com/trusch/btrfaas/health"
 )
 
 func main() {
@@ -15,10 +17,16 @@ func main() {
 
 	cmd := &Runnable{}
 
+	go func() {
+		log.Fatal(metrics.ListenAndServe(*cfg.MetricsAddr))
+	}()
+
 	go func() {
 		grpcServer := grpc.NewServer(cmd, cfg)
 		log.Fatal(grpcServer.ListenAndServe())
 	}()
 
+	health.SetServing(true)
+
 	select {}
 }
@@ -0,0 +1,269 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Reloader holds the TLS material of a Config and reloads it when the files change.
+// New handshakes use the reloaded material, established connections are not touched.
+type Reloader struct {
+	cfg      *Config
+	mutex    sync.RWMutex
+	files    [][]byte
+	cert     *tls.Certificate
+	ca       *x509.CertPool
+	revoked  map[string]bool
+	certExp  time.Time
+	caExp    time.Time
+	handlers []func()
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// NewReloader loads the TLS material of cfg, in insecure mode nothing is loaded
+func NewReloader(cfg *Config) (*Reloader, error) {
+	r := &Reloader{cfg: cfg, stop: make(chan struct{})}
+	if cfg.Insecure {
+		return r, nil
+	}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again and swaps the material if they changed.
+// On errors, like a half written rotation, the current material is kept.
+func (r *Reloader) Reload() (bool, error) {
+	if r.cfg.Insecure {
+		return false, nil
+	}
+	files := make([][]byte, 4)
+	var err error
+	if r.cfg.CertFile != "" {
+		if files[0], err = readFile(r.cfg.CertFile); err != nil {
+			return false, fmt.Errorf("could not read certificate: %v", err)
+		}
+		if files[1], err = readFile(r.cfg.KeyFile); err != nil {
+			return false, fmt.Errorf("could not read private key: %v", err)
+		}
+	}
+	if files[2], err = readFile(r.cfg.CAFile); err != nil {
+		return false, fmt.Errorf("could not read ca certificate: %v", err)
+	}
+	if r.cfg.CRLFile != "" {
+		if files[3], err = readFile(r.cfg.CRLFile); err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("could not read revocation list: %v", err)
+		}
+	}
+	r.mutex.RLock()
+	unchanged := r.files != nil && equalFiles(r.files, files)
+	r.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	var cert *tls.Certificate
+	var certExp time.Time
+	if r.cfg.CertFile != "" {
+		if cert, err = parseKeyPair(files[0], files[1]); err != nil {
+			return false, fmt.Errorf("%v: %v", r.cfg.CertFile, err)
+		}
+		certExp = cert.Leaf.NotAfter
+	}
+	ca, err := parseCA(files[2])
+	if err != nil {
+		return false, fmt.Errorf("%v: %v", r.cfg.CAFile, err)
+	}
+	cas := parseCerts(files[2])
+	var revoked map[string]bool
+	if len(files[3]) > 0 {
+		if revoked, err = parseCRL(files[3], cas); err != nil {
+			return false, fmt.Errorf("%v: %v", r.cfg.CRLFile, err)
+		}
+	}
+
+	r.mutex.Lock()
+	r.files = files
+	r.cert, r.certExp = cert, certExp
+	r.ca, r.caExp = ca, earliestExpiry(cas)
+	r.revoked = revoked
+	handlers := r.handlers
+	r.mutex.Unlock()
+	for _, fn := range handlers {
+		fn()
+	}
+	return true, nil
+}
+
+// Watch checks the files for changes every ReloadInterval of the config until Close is called
+func (r *Reloader) Watch() {
+	if r.cfg.Insecure || r.cfg.ReloadInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(r.cfg.ReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				changed, err := r.Reload()
+				if err != nil {
+					log.Warnf("failed to reload certificates of %v: %v", r.cfg.CertFile, err)
+				} else if changed {
+					log.Infof("reloaded certificates of %v", r.cfg.CertFile)
+				}
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops watching the files
+func (r *Reloader) Close() {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+}
+
+// OnReload registers a function which is called after the material got reloaded
+func (r *Reloader) OnReload(fn func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.handlers = append(r.handlers, fn)
+}
+
+// Expiry returns the expiry time of the certificate and the CA certificate which expires first,
+// they are zero if not loaded
+func (r *Reloader) Expiry() (cert, ca time.Time) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.certExp, r.caExp
+}
+
+// ExportExpiry sets the expiry times of the certificate and the CA as unix timestamps to the gauge,
+// labeled with name and name+"_ca", now and after every reload
+func (r *Reloader) ExportExpiry(gauge *prometheus.GaugeVec, name string) {
+	set := func(label string, t time.Time) {
+		if !t.IsZero() {
+			gauge.WithLabelValues(label).Set(float64(t.Unix()))
+		}
+	}
+	export := func() {
+		cert, ca := r.Expiry()
+		set(name, cert)
+		set(name+"_ca", ca)
+	}
+	export()
+	r.OnReload(export)
+}
+
+// ServerTLS returns a server config which uses the current certificate and CA for every handshake, for gRPC servers
+func (r *Reloader) ServerTLS(clientAuth tls.ClientAuthType) *tls.Config {
+	return r.serverTLS(clientAuth, []string{"h2"})
+}
+
+// HTTPServerTLS works like ServerTLS but negotiates HTTP/2 or HTTP/1.1, for HTTP servers
+func (r *Reloader) HTTPServerTLS(clientAuth tls.ClientAuthType) *tls.Config {
+	return r.serverTLS(clientAuth, []string{"h2", "http/1.1"})
+}
+
+func (r *Reloader) serverTLS(clientAuth tls.ClientAuthType, protos []string) *tls.Config {
+	return &tls.Config{
+		ClientAuth: clientAuth,
+		NextProtos: protos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mutex.RLock()
+			defer r.mutex.RUnlock()
+			cfg := &tls.Config{
+				ClientAuth:            clientAuth,
+				ClientCAs:             r.ca,
+				VerifyPeerCertificate: r.verifyPeer,
+				NextProtos:            protos, // the config replaces the one prepared by the server
+			}
+			if r.cert != nil {
+				cfg.Certificates = []tls.Certificate{*r.cert}
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientTLS returns a client config with the current certificate and CA.
+// Since the CA is fixed for the returned config, connections should be recreated on reload,
+// the revocation list is always the current one.
+func (r *Reloader) ClientTLS(serverName string) *tls.Config {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	cfg := &tls.Config{
+		ServerName:            serverName,
+		RootCAs:               r.ca,
+		VerifyPeerCertificate: r.verifyPeer,
+	}
+	if r.cert != nil {
+		cfg.Certificates = []tls.Certificate{*r.cert}
+		cfg.BuildNameToCertificate()
+	}
+	return cfg
+}
+
+// ServerOptions returns the gRPC server options which enable TLS, they are empty in insecure mode
+func (r *Reloader) ServerOptions(clientAuth tls.ClientAuthType) []grpc.ServerOption {
+	if r.cfg.Insecure {
+		return nil
+	}
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(r.ServerTLS(clientAuth)))}
+}
+
+// DialOption returns the gRPC dial option for a connection to serverName
+func (r *Reloader) DialOption(serverName string) (grpc.DialOption, error) {
+	if r.cfg.Insecure {
+		return grpc.WithInsecure(), nil
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(r.ClientTLS(serverName))), nil
+}
+
+// verifyPeer rejects verified peers whose certificate is revoked
+func (r *Reloader) verifyPeer(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, chain := range verifiedChains {
+		if len(chain) > 0 && r.revoked[chain[0].SerialNumber.String()] {
+			log.Warnf("rejected revoked certificate of %v (serial %x)", chain[0].Subject.CommonName, chain[0].SerialNumber)
+			return fmt.Errorf("certificate of %v is revoked", chain[0].Subject.CommonName)
+		}
+	}
+	return nil
+}
+
+func equalFiles(a, b [][]byte) bool {
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// earliestExpiry returns the earliest expiry time of the given certificates
+func earliestExpiry(certs []*x509.Certificate) time.Time {
+	var res time.Time
+	for _, cert := range certs {
+		if res.IsZero() || cert.NotAfter.Before(res) {
+			res = cert.NotAfter
+		}
+	}
+	return res
+}
@@ -0,0 +1,253 @@
+package tlsconfig_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	. "github.com/trusch/btrfaas/tlsconfig"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reloader", func() {
+	var (
+		dir   string
+		ca    *x509.Certificate
+		caKey *ecdsa.PrivateKey
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "tlsconfig")
+		Expect(err).NotTo(HaveOccurred())
+		ca, caKey = writeCert(dir, "ca", nil, nil, time.Hour)
+		writeCert(dir, "server", ca, caKey, time.Hour)
+		writeCert(dir, "client", ca, caKey, time.Hour)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	config := func(name string) *Config {
+		return &Config{
+			CertFile: filepath.Join(dir, name+"-cert.pem"),
+			KeyFile:  filepath.Join(dir, name+"-key.pem"),
+			CAFile:   filepath.Join(dir, "ca-cert.pem"),
+			CRLFile:  filepath.Join(dir, "crl.pem"),
+		}
+	}
+
+	It("should serve a rotated certificate to new connections and keep established ones", func() {
+		addr, r, stop := serve(config("server"))
+		defer stop()
+		client := config("client")
+		creds, err := client.DialOption("localhost")
+		Expect(err).NotTo(HaveOccurred())
+		conn, err := grpc.Dial(addr, creds)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		Expect(healthCheck(conn)).To(Succeed())
+		oldExpiry, _ := r.Expiry()
+
+		rotated, _ := writeCert(dir, "server", ca, caKey, 2*time.Hour)
+		changed, err := r.Reload()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		newExpiry, _ := r.Expiry()
+		Expect(newExpiry).To(BeTemporally(">", oldExpiry))
+
+		Expect(servedSerial(addr, client)).To(Equal(rotated.SerialNumber.String()))
+		Expect(healthCheck(conn)).To(Succeed())
+	})
+
+	It("should accept clients of a new CA after reloading it", func() {
+		addr, r, stop := serve(config("server"))
+		defer stop()
+		reloaded := make(chan struct{}, 1)
+		r.OnReload(func() { reloaded <- struct{}{} })
+
+		// the server keeps its certificate, the bundle contains the old and the new CA
+		oldCA, err := ioutil.ReadFile(filepath.Join(dir, "ca-cert.pem"))
+		Expect(err).NotTo(HaveOccurred())
+		newDir, err := ioutil.TempDir("", "tlsconfig")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(newDir)
+		newCA, newCAKey := writeCert(newDir, "ca", nil, nil, time.Hour)
+		writeCert(newDir, "client", newCA, newCAKey, time.Hour)
+		newCAPEM, err := ioutil.ReadFile(filepath.Join(newDir, "ca-cert.pem"))
+		Expect(err).NotTo(HaveOccurred())
+		client := &Config{
+			CertFile: filepath.Join(newDir, "client-cert.pem"),
+			KeyFile:  filepath.Join(newDir, "client-key.pem"),
+			CAFile:   filepath.Join(dir, "ca-cert.pem"),
+		}
+		creds, err := client.DialOption("localhost")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(check(addr, creds)).NotTo(Succeed())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "ca-cert.pem"), append(oldCA, newCAPEM...), 0600)).To(Succeed())
+		Expect(r.Reload()).To(BeTrue())
+		Eventually(reloaded).Should(Receive())
+		Expect(check(addr, creds)).To(Succeed())
+	})
+
+	It("should keep the current certificate if the new one is broken", func() {
+		r, err := NewReloader(config("server"))
+		Expect(err).NotTo(HaveOccurred())
+		expiry, _ := r.Expiry()
+		Expect(ioutil.WriteFile(filepath.Join(dir, "server-key.pem"), []byte("garbage"), 0600)).To(Succeed())
+		_, err = r.Reload()
+		Expect(err).To(HaveOccurred())
+		current, _ := r.Expiry()
+		Expect(current).To(Equal(expiry))
+	})
+
+	It("should watch the files for changes", func() {
+		cfg := config("server")
+		cfg.ReloadInterval = 10 * time.Millisecond
+		r, err := NewReloader(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		r.Watch()
+		defer r.Close()
+		_, caExpiry := r.Expiry()
+		Expect(caExpiry).To(BeTemporally("~", ca.NotAfter, time.Second))
+
+		rotated, _ := writeCert(dir, "server", ca, caKey, 3*time.Hour)
+		Eventually(func() time.Time {
+			cert, _ := r.Expiry()
+			return cert
+		}).Should(BeTemporally("~", rotated.NotAfter, time.Second))
+	})
+
+	It("should negotiate HTTP/1.1 or HTTP/2 and pass client certificates to HTTP handlers", func() {
+		r, err := NewReloader(config("server"))
+		Expect(err).NotTo(HaveOccurred())
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		server := &http.Server{
+			TLSConfig: r.HTTPServerTLS(tls.VerifyClientCertIfGiven),
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Write([]byte(req.Proto + " " + req.TLS.PeerCertificates[0].Subject.CommonName))
+			}),
+		}
+		go server.Serve(tls.NewListener(lis, server.TLSConfig))
+		defer server.Close()
+
+		client, err := NewReloader(config("client"))
+		Expect(err).NotTo(HaveOccurred())
+		transport := &http.Transport{TLSClientConfig: client.ClientTLS("localhost")}
+		resp, err := (&http.Client{Transport: transport}).Get("https://" + lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(Equal("HTTP/1.1 client"))
+
+		cfg := client.ClientTLS("localhost")
+		cfg.NextProtos = []string{"h2"}
+		conn, err := tls.Dial("tcp", lis.Addr().String(), cfg)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.ConnectionState().NegotiatedProtocol).To(Equal("h2"))
+	})
+
+	It("should export the expiry times to a gauge", func() {
+		r, err := NewReloader(config("server"))
+		Expect(err).NotTo(HaveOccurred())
+		gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "expiry"}, []string{"certificate"})
+		r.ExportExpiry(gauge, "server")
+		value := func(label string) float64 {
+			m := &dto.Metric{}
+			Expect(gauge.WithLabelValues(label).Write(m)).To(Succeed())
+			return m.GetGauge().GetValue()
+		}
+		Expect(value("server_ca")).To(BeNumerically("~", ca.NotAfter.Unix(), 1))
+
+		rotated, _ := writeCert(dir, "server", ca, caKey, 3*time.Hour)
+		Expect(r.Reload()).To(BeTrue())
+		Expect(value("server")).To(BeNumerically("~", rotated.NotAfter.Unix(), 1))
+	})
+
+	It("should reject revoked certificates", func() {
+		addr, r, stop := serve(config("server"))
+		defer stop()
+		client, _ := writeCert(dir, "client", ca, caKey, time.Hour)
+		creds, err := config("client").DialOption("localhost")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(check(addr, creds)).To(Succeed())
+
+		writeCRL(filepath.Join(dir, "crl.pem"), ca, caKey, client.SerialNumber)
+		Expect(r.Reload()).To(BeTrue())
+		Expect(check(addr, creds)).NotTo(Succeed())
+
+		// the client rejects a revoked server as well
+		server, _ := writeCert(dir, "server", ca, caKey, time.Hour)
+		writeCert(dir, "client", ca, caKey, time.Hour)
+		writeCRL(filepath.Join(dir, "crl.pem"), ca, caKey, server.SerialNumber)
+		Expect(r.Reload()).To(BeTrue())
+		creds, err = config("client").DialOption("localhost")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(check(addr, creds)).NotTo(Succeed())
+	})
+
+	It("should refuse a revocation list of another CA", func() {
+		r, err := NewReloader(config("server"))
+		Expect(err).NotTo(HaveOccurred())
+		otherDir, err := ioutil.TempDir("", "tlsconfig")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(otherDir)
+		other, otherKey := writeCert(otherDir, "ca", nil, nil, time.Hour)
+		writeCRL(filepath.Join(dir, "crl.pem"), other, otherKey)
+		_, err = r.Reload()
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+// writeCRL writes a revocation list of the given serial numbers signed by ca
+func writeCRL(path string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, serials ...*big.Int) {
+	var revoked []pkix.RevokedCertificate
+	for _, serial := range serials {
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: time.Now()})
+	}
+	der, err := ca.CreateCRL(rand.Reader, caKey, revoked, time.Now(), time.Now().Add(time.Hour))
+	Expect(err).NotTo(HaveOccurred())
+	Expect(ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600)).To(Succeed())
+}
+
+func healthCheck(conn *grpc.ClientConn) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+// servedSerial does a TLS handshake and returns the serial number of the server certificate
+func servedSerial(addr string, client *Config) string {
+	r, err := NewReloader(client)
+	Expect(err).NotTo(HaveOccurred())
+	cfg := r.ClientTLS("localhost")
+	cfg.NextProtos = []string{"h2"}
+	conn, err := tls.Dial("tcp", addr, cfg)
+	Expect(err).NotTo(HaveOccurred())
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.String()
+}
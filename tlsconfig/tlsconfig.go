@@ -0,0 +1,173 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
+)
+
+// SecretsDir is the directory where docker and kubernetes mount the secrets of a service
+const SecretsDir = "/run/secrets"
+
+// CAFile is the default location of the btrfaas CA certificate
+const CAFile = SecretsDir + "/btrfaas-ca-cert.pem"
+
+// CRLFile is the default location of the btrfaas certificate revocation list
+const CRLFile = SecretsDir + "/btrfaas-crl.pem"
+
+// Config locates the TLS material of a component
+type Config struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	CRLFile  string // revoked certificates are rejected, a missing file means nothing is revoked
+	Insecure bool   // use plaintext connections, for local development only
+
+	ReloadInterval time.Duration // how often a Reloader checks the files for changes, 0 disables it
+}
+
+// New returns a config for the key pair <name>-cert.pem and <name>-key.pem and the CA certificate in SecretsDir
+func New(name string) *Config {
+	return &Config{
+		CertFile: fmt.Sprintf("%v/%v-cert.pem", SecretsDir, name),
+		KeyFile:  fmt.Sprintf("%v/%v-key.pem", SecretsDir, name),
+		CAFile:   CAFile,
+		CRLFile:  CRLFile,
+
+		ReloadInterval: 10 * time.Second,
+	}
+}
+
+// AddFlags registers the flags --<prefix>-cert, --<prefix>-key, --<prefix>-ca, --<prefix>-crl and
+// --<prefix>-reload-interval, the current values are the defaults.
+// name describes the owner of the key pair in the usage, like "server".
+func (c *Config) AddFlags(flags *pflag.FlagSet, prefix, name string) {
+	flags.StringVar(&c.CertFile, prefix+"-cert", c.CertFile, name+" certificate file")
+	flags.StringVar(&c.KeyFile, prefix+"-key", c.KeyFile, name+" private key file")
+	flags.StringVar(&c.CAFile, prefix+"-ca", c.CAFile, "CA certificate file used by the "+name)
+	flags.StringVar(&c.CRLFile, prefix+"-crl", c.CRLFile, "certificate revocation list used by the "+name+", ignored if missing")
+	flags.DurationVar(&c.ReloadInterval, prefix+"-reload-interval", c.ReloadInterval, "how often the "+name+" checks its certificates for changes, 0 disables reloading")
+}
+
+// LoadEnvironment overrides the config with the environment variables <prefix>_CERT, <prefix>_KEY, <prefix>_CA,
+// <prefix>_CRL, <prefix>_INSECURE and <prefix>_RELOAD_INTERVAL
+func (c *Config) LoadEnvironment(prefix string) error {
+	if val, ok := os.LookupEnv(prefix + "_CERT"); ok {
+		c.CertFile = val
+	}
+	if val, ok := os.LookupEnv(prefix + "_KEY"); ok {
+		c.KeyFile = val
+	}
+	if val, ok := os.LookupEnv(prefix + "_CA"); ok {
+		c.CAFile = val
+	}
+	if val, ok := os.LookupEnv(prefix + "_CRL"); ok {
+		c.CRLFile = val
+	}
+	if val, ok := os.LookupEnv(prefix + "_INSECURE"); ok {
+		insecure, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid value for %v_INSECURE: %v", prefix, err)
+		}
+		c.Insecure = insecure
+	}
+	if val, ok := os.LookupEnv(prefix + "_RELOAD_INTERVAL"); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("invalid value for %v_RELOAD_INTERVAL: %v", prefix, err)
+		}
+		c.ReloadInterval = d
+	}
+	return nil
+}
+
+// DialOption loads the TLS material once and returns the gRPC dial option for a connection to serverName,
+// use a Reloader for long running clients
+func (c *Config) DialOption(serverName string) (grpc.DialOption, error) {
+	r, err := NewReloader(c)
+	if err != nil {
+		return nil, err
+	}
+	return r.DialOption(serverName)
+}
+
+func parseKeyPair(cert, key []byte) (*tls.Certificate, error) {
+	certificate, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("could not load key pair: %v", err)
+	}
+	if certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &certificate, nil
+}
+
+func parseCA(ca []byte) (*x509.CertPool, error) {
+	certPool := x509.NewCertPool()
+	if ok := certPool.AppendCertsFromPEM(ca); !ok {
+		return nil, errors.New("failed to append ca certs")
+	}
+	return certPool, nil
+}
+
+// parseCRL parses a revocation list and returns the revoked serial numbers, it must be signed by one of the CAs
+func parseCRL(bs []byte, cas []*x509.Certificate) (map[string]bool, error) {
+	crl, err := x509.ParseCRL(bs)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse revocation list: %v", err)
+	}
+	signed := false
+	for _, ca := range cas {
+		if ca.CheckCRLSignature(crl) == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return nil, errors.New("revocation list is not signed by the CA")
+	}
+	revoked := make(map[string]bool)
+	for _, entry := range crl.TBSCertList.RevokedCertificates {
+		revoked[entry.SerialNumber.String()] = true
+	}
+	return revoked, nil
+}
+
+// parseCerts parses the certificates of a PEM bundle
+func parseCerts(bundle []byte) []*x509.Certificate {
+	var res []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return res
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			res = append(res, cert)
+		}
+	}
+}
+
+// readFile reads a file, falling back to <path>/value which is where kubernetes mounts our secrets
+func readFile(path string) ([]byte, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		if value, e := ioutil.ReadFile(path + "/value"); e == nil {
+			return value, nil
+		}
+		return nil, err
+	}
+	return bs, nil
+}
@@ -0,0 +1,179 @@
+package tlsconfig_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	. "github.com/trusch/btrfaas/tlsconfig"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tlsconfig", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "tlsconfig")
+		Expect(err).NotTo(HaveOccurred())
+		ca, caKey := writeCert(dir, "ca", nil, nil, time.Hour)
+		writeCert(dir, "server", ca, caKey, time.Hour)
+		writeCert(dir, "client", ca, caKey, time.Hour)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	config := func(name string) *Config {
+		return &Config{
+			CertFile: filepath.Join(dir, name+"-cert.pem"),
+			KeyFile:  filepath.Join(dir, name+"-key.pem"),
+			CAFile:   filepath.Join(dir, "ca-cert.pem"),
+		}
+	}
+
+	It("should default to the secrets mounted by the platform", func() {
+		cfg := New("fgateway")
+		Expect(cfg.CertFile).To(Equal("/run/secrets/fgateway-cert.pem"))
+		Expect(cfg.KeyFile).To(Equal("/run/secrets/fgateway-key.pem"))
+		Expect(cfg.CAFile).To(Equal("/run/secrets/btrfaas-ca-cert.pem"))
+		Expect(cfg.CRLFile).To(Equal("/run/secrets/btrfaas-crl.pem"))
+		Expect(cfg.Insecure).To(BeFalse())
+		Expect(cfg.ReloadInterval).To(Equal(10 * time.Second))
+	})
+
+	It("should read paths and the insecure mode from the environment", func() {
+		os.Setenv("TLSCONFIG_TEST_CERT", "/tmp/cert.pem")
+		os.Setenv("TLSCONFIG_TEST_INSECURE", "true")
+		defer os.Unsetenv("TLSCONFIG_TEST_CERT")
+		defer os.Unsetenv("TLSCONFIG_TEST_INSECURE")
+		cfg := New("fgateway")
+		Expect(cfg.LoadEnvironment("TLSCONFIG_TEST")).To(Succeed())
+		Expect(cfg.CertFile).To(Equal("/tmp/cert.pem"))
+		Expect(cfg.KeyFile).To(Equal("/run/secrets/fgateway-key.pem"))
+		Expect(cfg.Insecure).To(BeTrue())
+
+		os.Setenv("TLSCONFIG_TEST_INSECURE", "maybe")
+		Expect(cfg.LoadEnvironment("TLSCONFIG_TEST")).NotTo(Succeed())
+	})
+
+	It("should fall back to the kubernetes secret layout", func() {
+		cfg := config("server")
+		for _, file := range []string{cfg.CertFile, cfg.KeyFile} {
+			bs, err := ioutil.ReadFile(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.Remove(file)).To(Succeed())
+			Expect(os.Mkdir(file, 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(file, "value"), bs, 0600)).To(Succeed())
+		}
+		r, err := NewReloader(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		cert, _ := r.Expiry()
+		Expect(cert).NotTo(BeZero())
+	})
+
+	It("should fail if the certificate is missing", func() {
+		cfg := config("unknown")
+		_, err := NewReloader(cfg)
+		Expect(err).To(HaveOccurred())
+		_, err = cfg.DialOption("localhost")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should require a client certificate signed by the CA", func() {
+		addr, _, stop := serve(config("server"))
+		defer stop()
+
+		creds, err := config("client").DialOption("localhost")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(check(addr, creds)).To(Succeed())
+
+		noCert := config("client")
+		noCert.CertFile = ""
+		creds, err = noCert.DialOption("localhost")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(check(addr, creds)).NotTo(Succeed())
+	})
+
+	It("should use plaintext in insecure mode", func() {
+		cfg := &Config{Insecure: true}
+		addr, _, stop := serve(cfg)
+		defer stop()
+		creds, err := cfg.DialOption("localhost")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(check(addr, creds)).To(Succeed())
+	})
+})
+
+func serve(cfg *Config) (string, *Reloader, func()) {
+	r, err := NewReloader(cfg)
+	Expect(err).NotTo(HaveOccurred())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	server := grpc.NewServer(r.ServerOptions(tls.RequireAndVerifyClientCert)...)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	return lis.Addr().String(), r, server.Stop
+}
+
+func check(addr string, creds grpc.DialOption) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.Dial(addr, creds)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+// writeCert writes <name>-cert.pem and <name>-key.pem to dir, the certificate is self signed if ca is nil
+func writeCert(dir, name string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, validity time.Duration) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		ca, caKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	Expect(ioutil.WriteFile(filepath.Join(dir, name+"-cert.pem"), certPEM, 0600)).To(Succeed())
+	Expect(ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600)).To(Succeed())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return cert, key
+}
@@ -0,0 +1,237 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Exporter receives all ended and sampled spans
+type Exporter interface {
+	Export(span *Span)
+}
+
+var (
+	exporterMutex sync.RWMutex
+	exporter      Exporter
+)
+
+// SetExporter sets the exporter for all spans, nil disables exporting
+func SetExporter(e Exporter) {
+	exporterMutex.Lock()
+	defer exporterMutex.Unlock()
+	exporter = e
+}
+
+func export(span *Span) {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
+	if exporter != nil {
+		exporter.Export(span)
+	}
+}
+
+const (
+	otlpQueueSize     = 2048
+	otlpBatchSize     = 512
+	otlpFlushInterval = 5 * time.Second
+)
+
+// OTLPExporter sends spans in batches to an OpenTelemetry collector using OTLP/HTTP with JSON encoding
+type OTLPExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+	queue       chan *Span
+	flush       chan chan struct{}
+}
+
+// NewOTLPExporter creates a new exporter, endpoint is the base URL of the collector like http://localhost:4318
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	e := &OTLPExporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *Span, otlpQueueSize),
+		flush:       make(chan chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+// Export implements the Exporter interface, spans are dropped if the queue is full
+func (e *OTLPExporter) Export(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		log.Warn("trace queue is full, dropping span ", span.name)
+	}
+}
+
+// Flush sends all queued spans
+func (e *OTLPExporter) Flush() {
+	done := make(chan struct{})
+	e.flush <- done
+	<-done
+}
+
+func (e *OTLPExporter) loop() {
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	send := func() {
+		if len(batch) > 0 {
+			if err := e.send(batch); err != nil {
+				log.Warnf("failed to export %v spans: %v", len(batch), err)
+			}
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= otlpBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-e.flush:
+			for drained := false; !drained; {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					drained = true
+				}
+			}
+			send()
+			close(done)
+		}
+	}
+}
+
+func (e *OTLPExporter) send(batch []*Span) error {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with %v", resp.Status)
+	}
+	return nil
+}
+
+// the following types are the JSON encoding of the OTLP trace protobuf messages
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func (e *OTLPExporter) encode(batch []*Span) *otlpRequest {
+	hostname, _ := os.Hostname()
+	resource := otlpResource{Attributes: []otlpAttribute{
+		attribute("service.name", e.serviceName),
+		attribute("host.name", hostname),
+	}}
+	spans := make([]otlpSpan, len(batch))
+	for i, span := range batch {
+		span.mutex.Lock()
+		s := otlpSpan{
+			TraceID:           span.context.TraceID.String(),
+			SpanID:            span.context.SpanID.String(),
+			Name:              span.name,
+			Kind:              int(span.kind),
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Status:            otlpStatus{Code: 1},
+		}
+		if span.parentID.IsValid() {
+			s.ParentSpanID = span.parentID.String()
+		}
+		for k, v := range span.attributes {
+			s.Attributes = append(s.Attributes, attribute(k, v))
+		}
+		if span.err != nil {
+			s.Status = otlpStatus{Code: 2, Message: span.err.Error()}
+		}
+		span.mutex.Unlock()
+		spans[i] = s
+	}
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   resource,
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/trusch/btrfaas/trace"}, Spans: spans}},
+	}}}
+}
+
+func attribute(key string, value interface{}) otlpAttribute {
+	var v map[string]interface{}
+	switch val := value.(type) {
+	case bool:
+		v = map[string]interface{}{"boolValue": val}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.Itoa(val)}
+	case int32:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(int64(val), 10)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": val}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(val)}
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
@@ -0,0 +1,106 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SpanKind describes the relationship of a span to its parent and children
+type SpanKind int
+
+const (
+	// KindInternal is an internal operation
+	KindInternal SpanKind = 1
+	// KindServer handles a request of a remote client
+	KindServer SpanKind = 2
+	// KindClient sends a request to a remote server
+	KindClient SpanKind = 3
+)
+
+// Span is a timed operation within a trace
+type Span struct {
+	mutex      sync.Mutex
+	name       string
+	kind       SpanKind
+	context    SpanContext
+	parentID   SpanID
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	remoteKey
+)
+
+// StartSpan starts a new span which is a child of the span (or remote span context) in ctx.
+// If there is none, a new trace is started.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]interface{}),
+	}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		span.context = SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+		span.parentID = parent.SpanID
+	} else {
+		span.context = SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+	}
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// Context returns the span context of the span
+func (s *Span) Context() SpanContext {
+	return s.context
+}
+
+// SetAttribute sets an attribute, supported values are strings, bools, integers and floats
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attributes[key] = value
+}
+
+// End ends the span and hands it to the exporter, err marks the span as failed
+func (s *Span) End(err error) {
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.err = err
+	s.mutex.Unlock()
+	if s.context.Sampled {
+		export(s)
+	}
+}
+
+// FromContext returns the span stored in ctx or nil
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// WithRemoteParent returns a context which contains a span context received from a remote caller
+func WithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span or the remote parent in ctx
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := FromContext(ctx); span != nil {
+		return span.context, true
+	}
+	sc, ok := ctx.Value(remoteKey).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
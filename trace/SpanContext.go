@@ -0,0 +1,106 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the lowercase hex representation
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid returns false for the all-zero ID
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// String returns the lowercase hex representation
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid returns false for the all-zero ID
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is the part of a span which is propagated to other services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid returns true if both IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%v-%v-%v", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value
+func ParseTraceparent(value string) (SpanContext, error) {
+	sc := SpanContext{}
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return sc, errors.New("malformed traceparent: " + value)
+	}
+	version, err := decodeHex(parts[0], 1)
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return sc, errors.New("unsupported traceparent version: " + value)
+	}
+	traceID, err := decodeHex(parts[1], 16)
+	if err != nil {
+		return sc, err
+	}
+	spanID, err := decodeHex(parts[2], 8)
+	if err != nil {
+		return sc, err
+	}
+	flags, err := decodeHex(parts[3], 1)
+	if err != nil {
+		return sc, err
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return SpanContext{}, errors.New("invalid trace or span id in traceparent: " + value)
+	}
+	return sc, nil
+}
+
+// decodeHex decodes a lowercase hex string of exactly size bytes
+func decodeHex(s string, size int) ([]byte, error) {
+	if len(s) != 2*size || strings.ToLower(s) != s {
+		return nil, errors.New("malformed traceparent field: " + s)
+	}
+	return hex.DecodeString(s)
+}
+
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return id
+}
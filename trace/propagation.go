@@ -0,0 +1,51 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// TraceparentKey is the gRPC metadata key and HTTP header which carries the W3C trace context
+const TraceparentKey = "traceparent"
+
+// InjectMetadata adds the trace context of ctx to outgoing gRPC metadata
+func InjectMetadata(ctx context.Context, md metadata.MD) {
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		md[TraceparentKey] = []string{sc.Traceparent()}
+	}
+}
+
+// ExtractMetadata returns a context which contains the remote trace context of incoming gRPC metadata
+func ExtractMetadata(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md[TraceparentKey]) == 0 {
+		return ctx
+	}
+	return extract(ctx, md[TraceparentKey][0])
+}
+
+// InjectHTTP adds the trace context of ctx to HTTP headers
+func InjectHTTP(ctx context.Context, header http.Header) {
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		header.Set(TraceparentKey, sc.Traceparent())
+	}
+}
+
+// ExtractHTTP returns a context which contains the remote trace context of incoming HTTP headers
+func ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	value := header.Get(TraceparentKey)
+	if value == "" {
+		return ctx
+	}
+	return extract(ctx, value)
+}
+
+func extract(ctx context.Context, traceparent string) context.Context {
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return ctx
+	}
+	return WithRemoteParent(ctx, sc)
+}
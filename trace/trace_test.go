@@ -0,0 +1,102 @@
+package trace_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/trusch/btrfaas/trace"
+	"google.golang.org/grpc/metadata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Trace", func() {
+
+	It("should parse and format traceparent values", func() {
+		value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		sc, err := ParseTraceparent(value)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sc.TraceID.String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(sc.SpanID.String()).To(Equal("00f067aa0ba902b7"))
+		Expect(sc.Sampled).To(BeTrue())
+		Expect(sc.Traceparent()).To(Equal(value))
+	})
+
+	It("should reject malformed traceparent values", func() {
+		for _, value := range []string{
+			"",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+			"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+			"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		} {
+			_, err := ParseTraceparent(value)
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+
+	It("should create child spans in the same trace", func() {
+		ctx, parent := StartSpan(context.Background(), "parent", KindServer)
+		_, child := StartSpan(ctx, "child", KindInternal)
+		Expect(child.Context().TraceID).To(Equal(parent.Context().TraceID))
+		Expect(child.Context().SpanID).NotTo(Equal(parent.Context().SpanID))
+	})
+
+	It("should propagate the trace context via gRPC metadata", func() {
+		ctx, span := StartSpan(context.Background(), "client", KindClient)
+		md := metadata.MD{}
+		InjectMetadata(ctx, md)
+		remote := ExtractMetadata(metadata.NewIncomingContext(context.Background(), md))
+		_, child := StartSpan(remote, "server", KindServer)
+		Expect(child.Context().TraceID).To(Equal(span.Context().TraceID))
+	})
+
+	It("should propagate the trace context via HTTP headers", func() {
+		ctx, span := StartSpan(context.Background(), "client", KindClient)
+		header := http.Header{}
+		InjectHTTP(ctx, header)
+		sc, ok := SpanContextFromContext(ExtractHTTP(context.Background(), header))
+		Expect(ok).To(BeTrue())
+		Expect(sc).To(Equal(span.Context()))
+	})
+
+	It("should export spans via OTLP/HTTP", func() {
+		bodies := make(chan map[string]interface{}, 1)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.URL.Path).To(Equal("/v1/traces"))
+			bs, _ := ioutil.ReadAll(r.Body)
+			body := make(map[string]interface{})
+			Expect(json.Unmarshal(bs, &body)).To(Succeed())
+			bodies <- body
+		}))
+		defer collector.Close()
+		exporter := NewOTLPExporter(collector.URL, "test")
+		SetExporter(exporter)
+		defer SetExporter(nil)
+
+		_, span := StartSpan(context.Background(), "failing", KindServer)
+		span.SetAttribute("answer", 42)
+		span.End(errors.New("oops"))
+		exporter.Flush()
+
+		var body map[string]interface{}
+		Eventually(bodies).Should(Receive(&body))
+		resourceSpans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+		spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+		Expect(spans).To(HaveLen(1))
+		exported := spans[0].(map[string]interface{})
+		Expect(exported["name"]).To(Equal("failing"))
+		Expect(exported["traceId"]).To(Equal(span.Context().TraceID.String()))
+		Expect(exported["status"]).To(HaveKeyWithValue("message", "oops"))
+	})
+
+})